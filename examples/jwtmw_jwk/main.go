@@ -4,7 +4,7 @@ import (
 	"context"
 	"flag"
 	"fmt"
-	"github.com/MicahParks/keyfunc"
+	"github.com/crossid/crossid-go/pkg/jwks"
 	"github.com/crossid/crossid-go/pkg/jwtmw"
 	"github.com/golang-jwt/jwt/v4"
 	"log"
@@ -23,28 +23,25 @@ func main() {
 	jwksURLPtr := flag.String("jwks-endpoint", "https://demo.crossid.io/oauth2/.well-known/jwks.json", "Well known JWKs endpoint")
 	flag.Parse()
 
-	opts := keyfunc.Options{
+	// Create the JWKs from the resource at the given URL, keys are refreshed in background.
+	keys, err := jwks.NewRemoteKeySet(context.Background(), *jwksURLPtr, &jwks.RemoteKeySetOpts{
 		RefreshErrorHandler: func(err error) {
-			log.Printf("There was an error with the jwt.KeyFunc\nError:%s\n", err.Error())
+			log.Printf("There was an error refreshing the JWKs\nError:%s\n", err.Error())
 		},
-	}
-
-	// Create the JWKs from the resource at the given URL.
-	jwks, err := keyfunc.Get(*jwksURLPtr, opts)
+	})
 	if err != nil {
 		log.Fatalf("Failed to create JWKs from resource at the given URL.\nError:%s\n", err.Error())
 	}
+	defer keys.Close()
 
 	// Create the middleware provider.
 	authmw := jwtmw.NewJWT(&jwtmw.JwtMiddlewareOpts{
-		// Ensure signing method to avoid tokens ׳with "none" method.
-		SigningMethod: jwt.SigningMethodRS256,
+		// Allow only the expected algorithms to avoid tokens ׳with "none" method or algorithm confusion.
+		Algorithms: []string{jwt.SigningMethodRS256.Alg()},
 		Logger: func(level jwtmw.Level, format string, args ...interface{}) {
 			log.Fatalf(format, args...)
 		},
-		KeyFunc: func(ctx context.Context, t *jwt.Token) (interface{}, error) {
-			return jwks.KeyFunc(t)
-		},
+		KeyFunc: keys.KeyFunc,
 	})
 
 	// Create a middleware that ensures token has the "openid" and "profile" scope.
@@ -52,11 +49,11 @@ func main() {
 
 	// Our protected handler
 	var protectedHandler = http.HandlerFunc(func(writer http.ResponseWriter, req *http.Request) {
-		// tok is the verified JWT token
-		tok := req.Context().Value(jwtmw.TokenCtxKey)
+		// claims are the claims of the verified JWT token
+		claims, _ := jwtmw.ClaimsFromContext(req.Context())
 
 		// Write the JWT claims.
-		for claim, value := range tok.(*jwt.Token).Claims.(jwt.MapClaims) {
+		for claim, value := range claims {
 			_, _ = writer.Write([]byte(fmt.Sprintf("  %s :%#v\n", claim, value)))
 		}
 
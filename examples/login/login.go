@@ -2,13 +2,17 @@ package main
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
 	"flag"
 	"fmt"
-	"github.com/julienschmidt/httprouter"
+	"github.com/crossid/crossid-go/pkg/oidc"
+	"github.com/crossid/crossid-go/pkg/session"
 	"github.com/toqueteos/webbrowser"
-	"golang.org/x/oauth2"
 	"html/template"
 	"net/http"
+	"net/url"
 	"strings"
 	"time"
 )
@@ -16,8 +20,17 @@ import (
 var indexPage = template.Must(template.New("").Parse(`<html>
 <body>
 <h1>Crossid Samples</h1>
+{{ if .Subject }}
+<p>Logged in as <code>{{ .Subject }}</code>, the access token expires at <code>{{ .Expiry }}</code>.</p>
+<form method="post" action="/logout">
+<input type="hidden" name="csrf_token" value="{{ .CSRFToken }}">
+<button type="submit">Logout</button>
+</form>
+{{ else }}
 <ul>
-<li><a href="{{ .URL }}">Login using code flow</a></li>
+<li><a href="/login">Login using code flow</a></li>
+</ul>
+{{ end }}
 </body>
 </html>
 `))
@@ -31,139 +44,131 @@ var errorPage = template.Must(template.New("").Parse(`<html>
 </html>
 `))
 
-var tokenUserResult = template.Must(template.New("").Parse(`<html>
-<html>
-<head></head>
-<body>
-<h1>Success!</h1>
-<ul>
-    <li>Access Token: <code>{{ .AccessToken }}</code></li>
-	<li>Expires at: <code>{{ .Expiry }}</code></li>
-    <li>Refresh Token: <code>{{ .RefreshToken }}</code></li>
-    <li>ID Token: <code>{{ .IDToken }}</code></li>
-</ul>
-</body>
-</html>`))
-
 func main() {
 	portPtr := flag.Int("port", 3005, "port")
 	issuerBaseURLPtr := flag.String("issuer-url", "https://demo.crossid.io/oauth2", "Issuer URL")
 	scopesPtr := flag.String("scope", "openid offline profile", "Requested scopes")
 	redirectURLPtr := flag.String("redirect-url", "https://localhost/callback", "where to redirect after login")
+	postLogoutRedirectURLPtr := flag.String("post-logout-redirect-url", "https://localhost/", "where to redirect after logout")
 	clientIDPtr := flag.String("client-id", "sample", "the registered client id in the authorization server")
 	clientSecretPtr := flag.String("client-secret", "super-secret", "the registered client secret in the authorization server")
 	audiencePtr := flag.String("audience", "https://api.example.com/products", "requested audience")
 	promptPtr := flag.String("prompt", "consent", "consent or none")
 	flag.Parse()
 
-	externalLocation := "localhost"
-	listenOn := fmt.Sprintf("%s:%d", externalLocation, *portPtr)
-	conf := oauth2.Config{
-		ClientID:     *clientIDPtr,
-		ClientSecret: *clientSecretPtr,
-		Endpoint: oauth2.Endpoint{
-			TokenURL: *issuerBaseURLPtr + "/token",
-			AuthURL:  *issuerBaseURLPtr + "/auth",
-		},
-		RedirectURL: *redirectURLPtr,
-		Scopes:      strings.Split(*scopesPtr, " "),
+	p, err := oidc.NewProvider(context.Background(), *issuerBaseURLPtr)
+	if err != nil {
+		panic(err)
 	}
+	defer p.Close()
 
-	state := RandomString(30)
-	nonce := RandomString(30)
-
-	authCodeURL := conf.AuthCodeURL(
-		state,
-		oauth2.SetAuthURLParam("audience", *audiencePtr),
-		oauth2.SetAuthURLParam("nonce", nonce),
-		//oauth2.SetAuthURLParam("max_age", *maxAge),
-		oauth2.SetAuthURLParam("prompt", *promptPtr),
-	)
-
-	_ = webbrowser.Open("https://" + externalLocation)
-
-	r := httprouter.New()
-	server := &http.Server{Addr: listenOn, Handler: r}
-	var shutdown = func() {
-		time.Sleep(time.Second * 1)
-		ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
-		defer cancel()
-		_ = server.Shutdown(ctx)
+	// a real app would load the secrets from its configuration so sessions survive restarts
+	cookieSecret := make([]byte, 32)
+	if _, err := rand.Read(cookieSecret); err != nil {
+		panic(err)
 	}
-
-	fmt.Println("listening on " + listenOn)
-	fmt.Println("callback url https://" + externalLocation + "/callback")
-	fmt.Printf("if browser is not opened automatically, navigate to: %s\n", "https://"+externalLocation)
-
-	r.GET("/", func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
-		_ = indexPage.Execute(w, &struct{ URL string }{URL: authCodeURL})
-	})
-
-	type derr struct {
-		Name        string
-		Description string
-		Hint        string
-		Debug       string
+	sessionKey := make([]byte, 32)
+	if _, err := rand.Read(sessionKey); err != nil {
+		panic(err)
 	}
 
-	r.GET("/callback", func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
-		if len(r.URL.Query().Get("error")) > 0 {
-			fmt.Printf("Got error: %s\n", r.URL.Query().Get("error_description"))
-
-			w.WriteHeader(http.StatusInternalServerError)
-			_ = errorPage.Execute(w, &derr{
-				Name:        r.URL.Query().Get("error"),
-				Description: r.URL.Query().Get("error_description"),
-				Hint:        r.URL.Query().Get("error_hint"),
-				Debug:       r.URL.Query().Get("error_debug"),
-			})
-
-			go shutdown()
-			return
-		}
+	// tokens are kept in encrypted cookies, set Store to keep them server-side instead
+	sessions, err := session.NewManager(&session.ManagerOpts{Keys: [][]byte{sessionKey}})
+	if err != nil {
+		panic(err)
+	}
 
-		if r.URL.Query().Get("state") != state {
-			fmt.Printf("states mismatch. Expected %s, got %s\n", state, r.URL.Query().Get("state"))
+	rp, err := oidc.NewRelyingParty(p, &oidc.RelyingPartyOpts{
+		ClientID:     *clientIDPtr,
+		ClientSecret: *clientSecretPtr,
+		RedirectURL:  *redirectURLPtr,
+		Scopes:       strings.Split(*scopesPtr, " "),
+		AuthParams: url.Values{
+			"audience": {*audiencePtr},
+			"prompt":   {*promptPtr},
+		},
+		CookieSecret:          cookieSecret,
+		PostLogoutRedirectURL: *postLogoutRedirectURLPtr,
+		PostLogin: func(w http.ResponseWriter, r *http.Request, res *oidc.LoginResult) {
+			s, _ := session.FromContext(r.Context())
+			// prevent session fixation
+			s.Renew()
+			s.Set("sub", res.IDToken.Subject)
+			s.Set("access_token", res.Tokens.AccessToken)
+			s.Set("refresh_token", res.Tokens.RefreshToken)
+			s.Set("id_token", res.Tokens.IDToken)
+			s.Set("expiry", res.Tokens.Expiry.Format(time.RFC1123))
+			// logouts are posted with this token so other sites cannot log the user out
+			csrf := make([]byte, 32)
+			if _, err := rand.Read(csrf); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			s.Set("csrf_token", base64.RawURLEncoding.EncodeToString(csrf))
+			http.Redirect(w, r, res.ReturnTo, http.StatusFound)
+		},
+		IDTokenHint: func(r *http.Request) string {
+			s, _ := session.FromContext(r.Context())
+			idt, _ := s.Get("id_token")
+			return idt
+		},
+		OnLogout: func(w http.ResponseWriter, r *http.Request) {
+			s, _ := session.FromContext(r.Context())
+			s.Destroy()
+		},
+		LogoutCSRFToken: func(r *http.Request) string {
+			s, _ := session.FromContext(r.Context())
+			csrf, _ := s.Get("csrf_token")
+			return csrf
+		},
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			fmt.Printf("login failed: %s\n", err)
+
+			d := struct {
+				Name        string
+				Description string
+			}{Name: err.Error()}
+			var ae *oidc.AuthError
+			if errors.As(err, &ae) {
+				d.Name, d.Description = ae.Code, ae.Description
+			}
 
 			w.WriteHeader(http.StatusInternalServerError)
-			_ = errorPage.Execute(w, &derr{
-				Name:        "states mismatch",
-				Description: "Expected state " + state + ", got " + r.URL.Query().Get("state"),
-			})
-			go shutdown()
-			return
-		}
+			_ = errorPage.Execute(w, &d)
+		},
+	})
+	if err != nil {
+		panic(err)
+	}
 
-		code := r.URL.Query().Get("code")
-		token, err := conf.Exchange(context.Background(), code)
-		if err != nil {
-			fmt.Printf("Unable to exchange code for token: %s\n", err)
+	externalLocation := "localhost"
+	listenOn := fmt.Sprintf("%s:%d", externalLocation, *portPtr)
 
-			w.WriteHeader(http.StatusInternalServerError)
-			_ = errorPage.Execute(w, &derr{
-				Name: err.Error(),
-			})
-			go shutdown()
-			return
-		}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		s, _ := session.FromContext(r.Context())
+		// the tokens are kept in the session, e.g., to call APIs on behalf of the user
+		d := struct {
+			Subject   string
+			Expiry    string
+			CSRFToken string
+		}{}
+		d.Subject, _ = s.Get("sub")
+		d.Expiry, _ = s.Get("expiry")
+		d.CSRFToken, _ = s.Get("csrf_token")
+		_ = indexPage.Execute(w, &d)
+	})
+	mux.Handle("/login", rp.LoginHandler())
+	mux.Handle("/callback", rp.CallbackHandler())
+	mux.Handle("/logout", rp.LogoutHandler())
 
-		idt := token.Extra("id_token")
-		_ = tokenUserResult.Execute(w, struct {
-			AccessToken  string
-			RefreshToken string
-			IDToken      string
-			Expiry       string
-		}{
-			AccessToken:  token.AccessToken,
-			RefreshToken: token.RefreshToken,
-			Expiry:       token.Expiry.Format(time.RFC1123),
-			IDToken:      fmt.Sprintf("%v", idt),
-		})
+	_ = webbrowser.Open("https://" + externalLocation)
 
-		go shutdown()
-	})
+	fmt.Println("listening on " + listenOn)
+	fmt.Println("callback url https://" + externalLocation + "/callback")
+	fmt.Printf("if browser is not opened automatically, navigate to: %s\n", "https://"+externalLocation)
 
-	if err := server.ListenAndServe(); err != nil {
+	if err := http.ListenAndServe(listenOn, sessions.Middleware(mux)); err != nil {
 		fmt.Println(err)
 	}
 }
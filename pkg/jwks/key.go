@@ -0,0 +1,225 @@
+/*
+Package jwks provides a JSON Web Key Set (RFC 7517) client that fetches, parses and caches keys
+that can be used to verify JWT signatures, typically by the jwtmw middleware.
+*/
+package jwks
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+const (
+	KeyTypeRSA = "RSA"
+	KeyTypeEC  = "EC"
+	KeyTypeOKP = "OKP"
+	KeyTypeOct = "oct"
+)
+
+// JSONWebKey is a single public (or symmetric) key of a key set.
+type JSONWebKey struct {
+	// Kid is the key ID, used to select a key from a set by the token's "kid" header.
+	Kid string `json:"kid,omitempty"`
+	// Kty is the key type (e.g., "RSA", "EC", "OKP", "oct").
+	Kty string `json:"kty"`
+	// Alg is the algorithm the key is intended to be used with, if advertised.
+	Alg string `json:"alg,omitempty"`
+	// Use is the intended use of the key, either "sig" or "enc".
+	Use string `json:"use,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+	K   string `json:"k,omitempty"`
+
+	// Key is the parsed key, one of *rsa.PublicKey, *ecdsa.PublicKey, ed25519.PublicKey or []byte.
+	Key interface{} `json:"-"`
+}
+
+// ParseKey parses a single JSON encoded JWK.
+func ParseKey(b []byte) (*JSONWebKey, error) {
+	k := new(JSONWebKey)
+	if err := json.Unmarshal(b, k); err != nil {
+		return nil, err
+	}
+
+	if err := k.parse(); err != nil {
+		return nil, err
+	}
+
+	return k, nil
+}
+
+// parse decodes the key material into k.Key
+func (k *JSONWebKey) parse() error {
+	switch k.Kty {
+	case KeyTypeRSA:
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return fmt.Errorf("invalid RSA modulus: %w", err)
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil {
+			return fmt.Errorf("invalid RSA exponent: %w", err)
+		}
+		if !e.IsInt64() || e.Int64() > 1<<31-1 {
+			return fmt.Errorf("invalid RSA exponent")
+		}
+		k.Key = &rsa.PublicKey{N: n, E: int(e.Int64())}
+	case KeyTypeEC:
+		crv, err := curve(k.Crv)
+		if err != nil {
+			return err
+		}
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return fmt.Errorf("invalid EC x coordinate: %w", err)
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return fmt.Errorf("invalid EC y coordinate: %w", err)
+		}
+		if !crv.IsOnCurve(x, y) {
+			return fmt.Errorf("EC point is not on curve %s", k.Crv)
+		}
+		k.Key = &ecdsa.PublicKey{Curve: crv, X: x, Y: y}
+	case KeyTypeOKP:
+		if k.Crv != "Ed25519" {
+			return fmt.Errorf("unsupported OKP curve '%s'", k.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil {
+			return fmt.Errorf("invalid OKP x: %w", err)
+		}
+		if len(x) != ed25519.PublicKeySize {
+			return fmt.Errorf("invalid Ed25519 public key size %d", len(x))
+		}
+		k.Key = ed25519.PublicKey(x)
+	case KeyTypeOct:
+		s, err := base64.RawURLEncoding.DecodeString(k.K)
+		if err != nil {
+			return fmt.Errorf("invalid symmetric key: %w", err)
+		}
+		if len(s) == 0 {
+			return fmt.Errorf("empty symmetric key")
+		}
+		k.Key = s
+	default:
+		return fmt.Errorf("unsupported key type '%s'", k.Kty)
+	}
+
+	return nil
+}
+
+// Supports returns true if k can be used to verify a signature made by alg.
+func (k *JSONWebKey) Supports(alg string) bool {
+	if k.Use != "" && k.Use != "sig" {
+		return false
+	}
+	if k.Alg != "" && alg != "" && k.Alg != alg {
+		return false
+	}
+	if alg == "" {
+		return true
+	}
+
+	switch {
+	case strings.HasPrefix(alg, "RS"), strings.HasPrefix(alg, "PS"):
+		return k.Kty == KeyTypeRSA
+	case strings.HasPrefix(alg, "ES"):
+		if k.Kty != KeyTypeEC {
+			return false
+		}
+		switch alg {
+		case "ES256":
+			return k.Crv == "P-256"
+		case "ES384":
+			return k.Crv == "P-384"
+		case "ES512":
+			return k.Crv == "P-521"
+		}
+		return false
+	case alg == "EdDSA":
+		return k.Kty == KeyTypeOKP
+	case strings.HasPrefix(alg, "HS"):
+		return k.Kty == KeyTypeOct
+	}
+
+	return false
+}
+
+// Thumbprint returns the base64url encoded SHA-256 JWK thumbprint of k (RFC 7638),
+// computed over the required members of its key type, as used by the "jkt" confirmation claim.
+func (k *JSONWebKey) Thumbprint() (string, error) {
+	var members interface{}
+	switch k.Kty {
+	case KeyTypeRSA:
+		members = struct {
+			E   string `json:"e"`
+			Kty string `json:"kty"`
+			N   string `json:"n"`
+		}{k.E, k.Kty, k.N}
+	case KeyTypeEC:
+		members = struct {
+			Crv string `json:"crv"`
+			Kty string `json:"kty"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+		}{k.Crv, k.Kty, k.X, k.Y}
+	case KeyTypeOKP:
+		members = struct {
+			Crv string `json:"crv"`
+			Kty string `json:"kty"`
+			X   string `json:"x"`
+		}{k.Crv, k.Kty, k.X}
+	case KeyTypeOct:
+		members = struct {
+			K   string `json:"k"`
+			Kty string `json:"kty"`
+		}{k.K, k.Kty}
+	default:
+		return "", fmt.Errorf("unsupported key type '%s'", k.Kty)
+	}
+
+	b, err := json.Marshal(members)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(b)
+
+	return base64.RawURLEncoding.EncodeToString(sum[:]), nil
+}
+
+func curve(crv string) (elliptic.Curve, error) {
+	switch crv {
+	case "P-256":
+		return elliptic.P256(), nil
+	case "P-384":
+		return elliptic.P384(), nil
+	case "P-521":
+		return elliptic.P521(), nil
+	}
+
+	return nil, fmt.Errorf("unsupported EC curve '%s'", crv)
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	if s == "" {
+		return nil, fmt.Errorf("missing value")
+	}
+	b, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+	if err != nil {
+		return nil, err
+	}
+
+	return new(big.Int).SetBytes(b), nil
+}
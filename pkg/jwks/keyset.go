@@ -0,0 +1,93 @@
+package jwks
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/golang-jwt/jwt/v4"
+)
+
+var (
+	ErrKeyNotFound   = fmt.Errorf("key not found")
+	ErrMissingKeyID  = fmt.Errorf("token has no kid header and key set has more than one candidate key")
+	ErrMissingMethod = fmt.Errorf("token has no alg header")
+)
+
+// KeySet is a parsed set of keys.
+type KeySet struct {
+	Keys []*JSONWebKey
+}
+
+// Parse parses a JSON encoded JWK set (i.e., the content of a jwks.json document).
+// keys with an unsupported type are skipped so a single unknown key does not invalidate the whole set.
+func Parse(b []byte) (*KeySet, error) {
+	var raw struct {
+		Keys []json.RawMessage `json:"keys"`
+	}
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return nil, fmt.Errorf("error decoding key set: %w", err)
+	}
+
+	ks := &KeySet{Keys: make([]*JSONWebKey, 0, len(raw.Keys))}
+	for _, rk := range raw.Keys {
+		k, err := ParseKey(rk)
+		if err != nil {
+			continue
+		}
+		ks.Keys = append(ks.Keys, k)
+	}
+
+	return ks, nil
+}
+
+// Lookup returns a key that matches kid and supports alg.
+// If kid is empty, a key is selected only if there is exactly one candidate that supports alg.
+func (s *KeySet) Lookup(kid, alg string) (*JSONWebKey, error) {
+	var found *JSONWebKey
+	for _, k := range s.Keys {
+		if kid != "" && k.Kid != kid {
+			continue
+		}
+		if !k.Supports(alg) {
+			continue
+		}
+		if kid != "" {
+			return k, nil
+		}
+		if found != nil {
+			return nil, ErrMissingKeyID
+		}
+		found = k
+	}
+
+	if found == nil {
+		return nil, ErrKeyNotFound
+	}
+
+	return found, nil
+}
+
+// KeyFunc returns the key that verifies t, selected by the "kid" and "alg" headers of t.
+// It is compatible with jwtmw.Keyfunc.
+func (s *KeySet) KeyFunc(_ context.Context, t *jwt.Token) (interface{}, error) {
+	kid, alg, err := headers(t)
+	if err != nil {
+		return nil, err
+	}
+
+	k, err := s.Lookup(kid, alg)
+	if err != nil {
+		return nil, err
+	}
+
+	return k.Key, nil
+}
+
+func headers(t *jwt.Token) (kid string, alg string, err error) {
+	alg, _ = t.Header["alg"].(string)
+	if alg == "" {
+		return "", "", ErrMissingMethod
+	}
+	kid, _ = t.Header["kid"].(string)
+	return kid, alg, nil
+}
@@ -0,0 +1,147 @@
+package jwks
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"github.com/crossid/crossid-go/pkg/x/testx"
+	"github.com/golang-jwt/jwt/v4"
+	"math/big"
+	"testing"
+)
+
+var rsaKey, _ = rsa.GenerateKey(rand.Reader, 2048)
+var ecKey, _ = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+var edPub, edKey, _ = ed25519.GenerateKey(rand.Reader)
+
+func b64(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func rsaJWK(kid string, k *rsa.PublicKey) map[string]string {
+	return map[string]string{
+		"kty": KeyTypeRSA,
+		"kid": kid,
+		"use": "sig",
+		"n":   b64(k.N.Bytes()),
+		"e":   b64(big.NewInt(int64(k.E)).Bytes()),
+	}
+}
+
+func ecJWK(kid string, k *ecdsa.PublicKey) map[string]string {
+	return map[string]string{
+		"kty": KeyTypeEC,
+		"kid": kid,
+		"crv": "P-256",
+		"x":   b64(k.X.Bytes()),
+		"y":   b64(k.Y.Bytes()),
+	}
+}
+
+func jwksJSON(t *testing.T, keys ...map[string]string) []byte {
+	b, err := json.Marshal(map[string]interface{}{"keys": keys})
+	testx.AssertNoError(t, err)
+	return b
+}
+
+func sign(t *testing.T, m jwt.SigningMethod, kid string, key interface{}) string {
+	tok := jwt.NewWithClaims(m, jwt.MapClaims{"sub": "alice"})
+	if kid != "" {
+		tok.Header["kid"] = kid
+	}
+	raw, err := tok.SignedString(key)
+	testx.AssertNoError(t, err)
+	return raw
+}
+
+func TestParse(t *testing.T) {
+	ks, err := Parse(jwksJSON(t,
+		rsaJWK("rsa", &rsaKey.PublicKey),
+		ecJWK("ec", &ecKey.PublicKey),
+		map[string]string{"kty": KeyTypeOKP, "kid": "ed", "crv": "Ed25519", "x": b64(edPub)},
+		map[string]string{"kty": KeyTypeOct, "kid": "hmac", "k": b64([]byte("secret"))},
+		map[string]string{"kty": "unknown", "kid": "skipped"},
+	))
+	testx.AssertNoError(t, err)
+	testx.AssertTrue(t, len(ks.Keys) == 4, fmt.Sprintf("expected 4 keys but got %d", len(ks.Keys)))
+
+	_, ok := ks.Keys[0].Key.(*rsa.PublicKey)
+	testx.AssertTrue(t, ok, "expected an RSA key")
+	testx.AssertTrue(t, ks.Keys[0].Key.(*rsa.PublicKey).Equal(&rsaKey.PublicKey), "RSA key mismatch")
+	testx.AssertTrue(t, ks.Keys[1].Key.(*ecdsa.PublicKey).Equal(&ecKey.PublicKey), "EC key mismatch")
+	testx.AssertTrue(t, ks.Keys[2].Key.(ed25519.PublicKey).Equal(edPub), "Ed25519 key mismatch")
+	testx.AssertTrue(t, string(ks.Keys[3].Key.([]byte)) == "secret", "symmetric key mismatch")
+
+	_, err = Parse([]byte("not json"))
+	testx.AssertError(t, err)
+}
+
+func TestKeySet_KeyFunc(t *testing.T) {
+	ks, err := Parse(jwksJSON(t,
+		rsaJWK("rsa1", &rsaKey.PublicKey),
+		rsaJWK("rsa2", &rsaKey.PublicKey),
+		ecJWK("ec", &ecKey.PublicKey),
+		map[string]string{"kty": KeyTypeRSA, "kid": "enc", "use": "enc", "n": b64(rsaKey.N.Bytes()), "e": "AQAB"},
+	))
+	testx.AssertNoError(t, err)
+
+	for k, tc := range []struct {
+		name string
+		tok  string
+		err  bool
+	}{
+		{name: "rsa by kid", tok: sign(t, jwt.SigningMethodRS256, "rsa2", rsaKey)},
+		{name: "ps by kid", tok: sign(t, jwt.SigningMethodPS256, "rsa1", rsaKey)},
+		{name: "ec by kid", tok: sign(t, jwt.SigningMethodES256, "ec", ecKey)},
+		{name: "ec without kid has a single candidate", tok: sign(t, jwt.SigningMethodES256, "", ecKey)},
+		{name: "rsa without kid is ambiguous", tok: sign(t, jwt.SigningMethodRS256, "", rsaKey), err: true},
+		{name: "unknown kid", tok: sign(t, jwt.SigningMethodRS256, "nope", rsaKey), err: true},
+		{name: "alg does not match key type", tok: sign(t, jwt.SigningMethodHS256, "rsa1", []byte("secret")), err: true},
+		{name: "encryption keys are ignored", tok: sign(t, jwt.SigningMethodRS256, "enc", rsaKey), err: true},
+		{name: "eddsa is not in set", tok: sign(t, jwt.SigningMethodEdDSA, "", edKey), err: true},
+	} {
+		t.Run(fmt.Sprintf("case=%d/%s", k, tc.name), func(t *testing.T) {
+			_, err := jwt.Parse(tc.tok, func(t *jwt.Token) (interface{}, error) {
+				return ks.KeyFunc(context.Background(), t)
+			})
+			if tc.err {
+				testx.AssertError(t, err)
+			} else {
+				testx.AssertNoError(t, err)
+			}
+		})
+	}
+}
+
+func TestJSONWebKey_Thumbprint(t *testing.T) {
+	// RFC 7638 section 3.1
+	k, err := ParseKey([]byte(`{"kty":"RSA","kid":"2011-04-29","alg":"RS256","e":"AQAB","n":"0vx7agoebGcQSuuPiLJXZptN9nndrQmbXEps2aiAFbWhM78LhWx4cbbfAAtVT86zwu1RK7aPFFxuhDR1L6tSoc_BJECPebWKRXjBZCiFV4n3oknjhMstn64tZ_2W-5JsGY4Hc5n9yBXArwl93lqt7_RN5w6Cf0h4QyQ5v-65YGjQR0_FDW2QvzqY368QQMicAtaSqzs8KJZgnYb9c7d0zgdAZHzu6qMQvRL5hajrn1n91CbOpbISD08qNLyrdkt-bFTWhAI4vMQFh6WeZu0fM4lFd2NcRwr3XPksINHaQ-G_xBniIqbw0Ls1jF44-csFCur-kEgU8awapJzKnqDKgw"}`))
+	testx.AssertNoError(t, err)
+	tp, err := k.Thumbprint()
+	testx.AssertNoError(t, err)
+	testx.AssertTrue(t, tp == "NzbLsXh8uDCcd-6MNwXF4W_7noWXFZAfHkxZsRGC9Xs", fmt.Sprintf("unexpected thumbprint %s", tp))
+
+	// optional members do not affect the thumbprint
+	b, err := json.Marshal(ecJWK("a", &ecKey.PublicKey))
+	testx.AssertNoError(t, err)
+	k1, err := ParseKey(b)
+	testx.AssertNoError(t, err)
+	b, err = json.Marshal(ecJWK("b", &ecKey.PublicKey))
+	testx.AssertNoError(t, err)
+	k2, err := ParseKey(b)
+	testx.AssertNoError(t, err)
+	tp1, err := k1.Thumbprint()
+	testx.AssertNoError(t, err)
+	tp2, err := k2.Thumbprint()
+	testx.AssertNoError(t, err)
+	testx.AssertTrue(t, tp1 == tp2, "expected thumbprints of the same key to match")
+
+	_, err = (&JSONWebKey{Kty: "unknown"}).Thumbprint()
+	testx.AssertError(t, err)
+}
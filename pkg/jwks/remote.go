@@ -0,0 +1,240 @@
+package jwks
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/golang-jwt/jwt/v4"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// versions is the last version assigned to a key set
+var versions uint64
+
+// RemoteKeySetOpts describes the options of a RemoteKeySet
+type RemoteKeySetOpts struct {
+	// HTTPClient is used to fetch the key set, defaults to an http.Client with a 10s timeout.
+	HTTPClient *http.Client
+	// RefreshInterval is the interval of background refreshes, defaults to 1 hour.
+	// a negative value disables background refreshes.
+	RefreshInterval time.Duration
+	// RefreshRateLimit is the minimal duration between two refreshes triggered by an unknown kid, whether the
+	// previous refresh failed or not, defaults to 5 minutes.
+	// this prevents an attacker from forcing a fetch per request by sending tokens with random kids.
+	RefreshRateLimit time.Duration
+	// RefreshErrorHandler is called when a refresh fails, the previously fetched keys remain in use.
+	RefreshErrorHandler func(err error)
+}
+
+func mergeRemoteKeySetOpts(opts ...*RemoteKeySetOpts) *RemoteKeySetOpts {
+	opt := RemoteKeySetOpts{
+		HTTPClient:          &http.Client{Timeout: 10 * time.Second},
+		RefreshInterval:     time.Hour,
+		RefreshRateLimit:    5 * time.Minute,
+		RefreshErrorHandler: func(err error) {},
+	}
+
+	for _, o := range opts {
+		if o == nil {
+			continue
+		}
+		if o.HTTPClient != nil {
+			opt.HTTPClient = o.HTTPClient
+		}
+		if o.RefreshInterval != 0 {
+			opt.RefreshInterval = o.RefreshInterval
+		}
+		if o.RefreshRateLimit != 0 {
+			opt.RefreshRateLimit = o.RefreshRateLimit
+		}
+		if o.RefreshErrorHandler != nil {
+			opt.RefreshErrorHandler = o.RefreshErrorHandler
+		}
+	}
+
+	return &opt
+}
+
+// RemoteKeySet is a key set fetched from a URL (e.g., https://<tenant>.crossid.io/oauth2/.well-known/jwks.json)
+// which is refreshed periodically in the background and whenever a token refers to an unknown kid.
+// It is safe for concurrent use.
+type RemoteKeySet struct {
+	url  string
+	opts RemoteKeySetOpts
+
+	mu          sync.RWMutex
+	keys        *KeySet
+	raw         []byte
+	version     uint64
+	refreshedAt time.Time
+
+	// refreshMu serializes fetches so concurrent misses result in a single request.
+	refreshMu sync.Mutex
+	// attemptedAt is the time of the last fetch, successful or not, guarded by refreshMu.
+	attemptedAt time.Time
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// NewRemoteKeySet fetches the key set at url and starts refreshing it in the background.
+// Close should be called once the key set is no longer needed to stop the background refresh.
+func NewRemoteKeySet(ctx context.Context, url string, opts ...*RemoteKeySetOpts) (*RemoteKeySet, error) {
+	s := &RemoteKeySet{
+		url:  url,
+		opts: *mergeRemoteKeySetOpts(opts...),
+		done: make(chan struct{}),
+	}
+
+	if err := s.Refresh(ctx); err != nil {
+		return nil, err
+	}
+
+	bctx, cancel := context.WithCancel(context.Background())
+	s.cancel = cancel
+	go s.refreshLoop(bctx)
+
+	return s, nil
+}
+
+// URL returns the location of the key set.
+func (s *RemoteKeySet) URL() string {
+	return s.url
+}
+
+// KeySet returns the most recently fetched key set.
+func (s *RemoteKeySet) KeySet() *KeySet {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.keys
+}
+
+// KeyFunc returns the key that verifies t, selected by the "kid" and "alg" headers of t.
+// If no key matches, the key set is refreshed (rate limited by RefreshRateLimit) and looked up again.
+// It is compatible with jwtmw.Keyfunc.
+func (s *RemoteKeySet) KeyFunc(ctx context.Context, t *jwt.Token) (interface{}, error) {
+	kid, alg, err := headers(t)
+	if err != nil {
+		return nil, err
+	}
+
+	k, err := s.KeySet().Lookup(kid, alg)
+	if err == nil {
+		return k.Key, nil
+	}
+	if !errors.Is(err, ErrKeyNotFound) {
+		return nil, err
+	}
+
+	// another request may have refreshed the key set while we waited, so look up again either way.
+	s.refreshOnMiss(ctx)
+	k, err = s.KeySet().Lookup(kid, alg)
+	if err != nil {
+		return nil, err
+	}
+
+	return k.Key, nil
+}
+
+// Version identifies the fetched key set, it changes whenever a refresh fetches a different key set (e.g., keys were rotated).
+// versions are unique across all the remote key sets of the process, so a replaced RemoteKeySet has a different version too.
+func (s *RemoteKeySet) Version() uint64 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.version
+}
+
+// Refresh fetches the key set now, regardless of the rate limit.
+func (s *RemoteKeySet) Refresh(ctx context.Context) error {
+	s.refreshMu.Lock()
+	defer s.refreshMu.Unlock()
+	return s.refresh(ctx)
+}
+
+// Close stops the background refresh.
+func (s *RemoteKeySet) Close() {
+	s.cancel()
+	<-s.done
+}
+
+// refreshOnMiss refreshes the key set unless a refresh was attempted recently.
+// failed attempts are rate limited too, so an unavailable endpoint is not fetched per request.
+func (s *RemoteKeySet) refreshOnMiss(ctx context.Context) {
+	s.refreshMu.Lock()
+	defer s.refreshMu.Unlock()
+
+	if time.Since(s.attemptedAt) < s.opts.RefreshRateLimit {
+		return
+	}
+
+	if err := s.refresh(ctx); err != nil {
+		s.opts.RefreshErrorHandler(err)
+	}
+}
+
+func (s *RemoteKeySet) refreshLoop(ctx context.Context) {
+	defer close(s.done)
+	if s.opts.RefreshInterval < 0 {
+		return
+	}
+
+	t := time.NewTicker(s.opts.RefreshInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			if err := s.Refresh(ctx); err != nil && ctx.Err() == nil {
+				s.opts.RefreshErrorHandler(err)
+			}
+		}
+	}
+}
+
+// refresh fetches the key set, callers must hold refreshMu.
+func (s *RemoteKeySet) refresh(ctx context.Context) error {
+	s.attemptedAt = time.Now()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := s.opts.HTTPClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("error fetching key set: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("error fetching key set: unexpected status code %d", resp.StatusCode)
+	}
+
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("error reading key set: %w", err)
+	}
+
+	ks, err := Parse(b)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	if !bytes.Equal(b, s.raw) {
+		s.raw = b
+		s.version = atomic.AddUint64(&versions, 1)
+	}
+	s.keys = ks
+	s.refreshedAt = time.Now()
+	s.mu.Unlock()
+
+	return nil
+}
@@ -0,0 +1,177 @@
+package jwks
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"fmt"
+	"github.com/crossid/crossid-go/pkg/x/testx"
+	"github.com/golang-jwt/jwt/v4"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type jwksServer struct {
+	mu    sync.Mutex
+	body  []byte
+	hits  int32
+	fails bool
+}
+
+func (s *jwksServer) set(b []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.body = b
+}
+
+func (s *jwksServer) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	atomic.AddInt32(&s.hits, 1)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.fails {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	_, _ = w.Write(s.body)
+}
+
+func parseWith(ks *RemoteKeySet, raw string) error {
+	_, err := jwt.Parse(raw, func(t *jwt.Token) (interface{}, error) {
+		return ks.KeyFunc(context.Background(), t)
+	})
+	return err
+}
+
+func TestRemoteKeySet_RefreshOnUnknownKid(t *testing.T) {
+	rotated, _ := rsa.GenerateKey(rand.Reader, 2048)
+	js := &jwksServer{body: jwksJSON(t, rsaJWK("k1", &rsaKey.PublicKey))}
+	srv := httptest.NewServer(js)
+	defer srv.Close()
+
+	ks, err := NewRemoteKeySet(context.Background(), srv.URL, &RemoteKeySetOpts{
+		RefreshInterval:  -1,
+		RefreshRateLimit: time.Millisecond,
+	})
+	testx.AssertNoError(t, err)
+	defer ks.Close()
+
+	testx.AssertNoError(t, parseWith(ks, sign(t, jwt.SigningMethodRS256, "k1", rsaKey)))
+	testx.AssertTrue(t, atomic.LoadInt32(&js.hits) == 1, "expected a single fetch")
+
+	// the issuer rotates its keys
+	js.set(jwksJSON(t, rsaJWK("k1", &rsaKey.PublicKey), rsaJWK("k2", &rotated.PublicKey)))
+	time.Sleep(2 * time.Millisecond)
+	testx.AssertNoError(t, parseWith(ks, sign(t, jwt.SigningMethodRS256, "k2", rotated)))
+	testx.AssertTrue(t, atomic.LoadInt32(&js.hits) == 2, "expected unknown kid to trigger a fetch")
+}
+
+func TestRemoteKeySet_Version(t *testing.T) {
+	rotated, _ := rsa.GenerateKey(rand.Reader, 2048)
+	js := &jwksServer{body: jwksJSON(t, rsaJWK("k1", &rsaKey.PublicKey))}
+	srv := httptest.NewServer(js)
+	defer srv.Close()
+
+	ks, err := NewRemoteKeySet(context.Background(), srv.URL, &RemoteKeySetOpts{RefreshInterval: -1})
+	testx.AssertNoError(t, err)
+	defer ks.Close()
+
+	v := ks.Version()
+	testx.AssertTrue(t, v != 0, "expected a version")
+	testx.AssertNoError(t, ks.Refresh(context.Background()))
+	testx.AssertTrue(t, ks.Version() == v, "expected version of an unchanged key set to remain")
+
+	js.set(jwksJSON(t, rsaJWK("k2", &rotated.PublicKey)))
+	testx.AssertNoError(t, ks.Refresh(context.Background()))
+	testx.AssertTrue(t, ks.Version() != v, "expected version to change once keys are rotated")
+}
+
+func TestRemoteKeySet_RateLimit(t *testing.T) {
+	js := &jwksServer{body: jwksJSON(t, rsaJWK("k1", &rsaKey.PublicKey))}
+	srv := httptest.NewServer(js)
+	defer srv.Close()
+
+	ks, err := NewRemoteKeySet(context.Background(), srv.URL, &RemoteKeySetOpts{
+		RefreshInterval:  -1,
+		RefreshRateLimit: time.Hour,
+	})
+	testx.AssertNoError(t, err)
+	defer ks.Close()
+
+	for i := 0; i < 10; i++ {
+		testx.AssertError(t, parseWith(ks, sign(t, jwt.SigningMethodRS256, "unknown", rsaKey)))
+	}
+	testx.AssertTrue(t, atomic.LoadInt32(&js.hits) == 1, "unknown kids should not trigger fetches within the rate limit")
+}
+
+func TestRemoteKeySet_RateLimitFailures(t *testing.T) {
+	js := &jwksServer{body: jwksJSON(t, rsaJWK("k1", &rsaKey.PublicKey))}
+	srv := httptest.NewServer(js)
+	defer srv.Close()
+
+	var failures int32
+	ks, err := NewRemoteKeySet(context.Background(), srv.URL, &RemoteKeySetOpts{
+		RefreshInterval:     -1,
+		RefreshRateLimit:    50 * time.Millisecond,
+		RefreshErrorHandler: func(err error) { atomic.AddInt32(&failures, 1) },
+	})
+	testx.AssertNoError(t, err)
+	defer ks.Close()
+
+	// the endpoint becomes unavailable
+	js.mu.Lock()
+	js.fails = true
+	js.mu.Unlock()
+	time.Sleep(60 * time.Millisecond)
+
+	for i := 0; i < 10; i++ {
+		testx.AssertError(t, parseWith(ks, sign(t, jwt.SigningMethodRS256, "unknown", rsaKey)))
+	}
+	testx.AssertTrue(t, atomic.LoadInt32(&js.hits) == 2, fmt.Sprintf("expected failed fetches to be rate limited, got %d fetches", atomic.LoadInt32(&js.hits)))
+	testx.AssertTrue(t, atomic.LoadInt32(&failures) == 1, "expected a single refresh error")
+
+	// the previously fetched keys remain in use
+	testx.AssertNoError(t, parseWith(ks, sign(t, jwt.SigningMethodRS256, "k1", rsaKey)))
+}
+
+func TestRemoteKeySet_BackgroundRefresh(t *testing.T) {
+	js := &jwksServer{body: jwksJSON(t, rsaJWK("k1", &rsaKey.PublicKey))}
+	srv := httptest.NewServer(js)
+	defer srv.Close()
+
+	errs := make(chan error, 10)
+	ks, err := NewRemoteKeySet(context.Background(), srv.URL, &RemoteKeySetOpts{
+		RefreshInterval:     5 * time.Millisecond,
+		RefreshRateLimit:    time.Hour,
+		RefreshErrorHandler: func(err error) { errs <- err },
+	})
+	testx.AssertNoError(t, err)
+	defer ks.Close()
+
+	js.set(jwksJSON(t, rsaJWK("k2", &rsaKey.PublicKey)))
+	deadline := time.Now().Add(time.Second)
+	for parseWith(ks, sign(t, jwt.SigningMethodRS256, "k2", rsaKey)) != nil {
+		if time.Now().After(deadline) {
+			t.Fatalf("key set was not refreshed in background")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	// a failed refresh keeps the previous keys
+	js.mu.Lock()
+	js.fails = true
+	js.mu.Unlock()
+	testx.AssertError(t, <-errs)
+	testx.AssertNoError(t, parseWith(ks, sign(t, jwt.SigningMethodRS256, "k2", rsaKey)))
+}
+
+func TestNewRemoteKeySet_FetchError(t *testing.T) {
+	srv := httptest.NewServer(&jwksServer{fails: true})
+	defer srv.Close()
+
+	_, err := NewRemoteKeySet(context.Background(), srv.URL)
+	testx.AssertError(t, err)
+}
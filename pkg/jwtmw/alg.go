@@ -0,0 +1,61 @@
+package jwtmw
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"fmt"
+	"github.com/golang-jwt/jwt/v4"
+	"strings"
+)
+
+// algorithms returns the allowed algorithms of o, nil means any algorithm is allowed.
+func algorithms(o *JwtMiddlewareOpts) []string {
+	if len(o.Algorithms) > 0 {
+		return o.Algorithms
+	}
+	if o.SigningMethod != nil {
+		return []string{o.SigningMethod.Alg()}
+	}
+
+	return nil
+}
+
+// verifyKeyType ensures key is of the type expected by alg.
+// this closes algorithm confusion attacks where a token is signed, for example, by HS256
+// using a public key as the secret while the KeyFunc returns the (public) key material as bytes.
+func verifyKeyType(alg string, key interface{}) error {
+	var ok bool
+	switch {
+	case strings.HasPrefix(alg, "HS"):
+		_, ok = key.([]byte)
+	case strings.HasPrefix(alg, "RS"), strings.HasPrefix(alg, "PS"):
+		_, ok = key.(*rsa.PublicKey)
+	case strings.HasPrefix(alg, "ES"):
+		var k *ecdsa.PublicKey
+		if k, ok = key.(*ecdsa.PublicKey); ok {
+			ok = ecdsaCurveMatches(alg, k)
+		}
+	case alg == jwt.SigningMethodEdDSA.Alg():
+		_, ok = key.(ed25519.PublicKey)
+	}
+
+	if !ok {
+		return fmt.Errorf("key of type %T cannot be used with algorithm '%s'", key, alg)
+	}
+
+	return nil
+}
+
+func ecdsaCurveMatches(alg string, k *ecdsa.PublicKey) bool {
+	switch alg {
+	case jwt.SigningMethodES256.Alg():
+		return k.Curve.Params().BitSize == 256
+	case jwt.SigningMethodES384.Alg():
+		return k.Curve.Params().BitSize == 384
+	case jwt.SigningMethodES512.Alg():
+		return k.Curve.Params().BitSize == 521
+	}
+
+	return false
+}
@@ -0,0 +1,132 @@
+package jwtmw
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"fmt"
+	"github.com/crossid/crossid-go/pkg/x/testx"
+	"github.com/golang-jwt/jwt/v4"
+	"net/http"
+	"testing"
+)
+
+func TestJWT_ValidateAlgorithms(t *testing.T) {
+	eckey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	testx.AssertNoError(t, err)
+
+	sign := func(m jwt.SigningMethod, key interface{}) string {
+		b, err := jwt.NewWithClaims(m, jwt.MapClaims{}).SignedString(key)
+		testx.AssertNoError(t, err)
+		return fmt.Sprintf("%s %s", BearerPrefix, b)
+	}
+
+	keyFunc := func(ctx context.Context, t *jwt.Token) (interface{}, error) {
+		switch t.Method.Alg() {
+		case "ES256":
+			return &eckey.PublicKey, nil
+		case "HS256":
+			return secret, nil
+		}
+		return &prkey.PublicKey, nil
+	}
+
+	for k, tc := range []struct {
+		name       string
+		algs       []string
+		jwt        string
+		valid      bool
+		keyVisited bool
+	}{
+		{
+			name:       "rollover - old algorithm",
+			algs:       []string{"RS256", "ES256"},
+			jwt:        sign(jwt.SigningMethodRS256, prkey),
+			valid:      true,
+			keyVisited: true,
+		},
+		{
+			name:       "rollover - new algorithm",
+			algs:       []string{"RS256", "ES256"},
+			jwt:        sign(jwt.SigningMethodES256, eckey),
+			valid:      true,
+			keyVisited: true,
+		},
+		{
+			name:       "rollover - PS256",
+			algs:       []string{"RS256", "PS256"},
+			jwt:        sign(jwt.SigningMethodPS256, prkey),
+			valid:      true,
+			keyVisited: true,
+		},
+		{
+			name: "disallowed algorithm never reaches KeyFunc",
+			algs: []string{"RS256", "ES256"},
+			jwt:  sign(jwt.SigningMethodHS256, secret),
+		},
+		{
+			name:       "any algorithm when not restricted",
+			jwt:        sign(jwt.SigningMethodHS256, secret),
+			valid:      true,
+			keyVisited: true,
+		},
+	} {
+		t.Run(fmt.Sprintf("case=%d", k), func(t *testing.T) {
+			r, err := http.NewRequest(http.MethodGet, "/", nil)
+			testx.AssertNoError(t, err)
+			r.Header.Set(BearerHeaderKey, tc.jwt)
+
+			visited := false
+			_, err = NewJWT(&JwtMiddlewareOpts{
+				Algorithms: tc.algs,
+				KeyFunc: func(ctx context.Context, t *jwt.Token) (interface{}, error) {
+					visited = true
+					return keyFunc(ctx, t)
+				},
+			}).Validate(r)
+			if tc.valid {
+				testx.AssertNoError(t, err)
+			} else {
+				testx.AssertError(t, err)
+			}
+			testx.AssertTrue(t, visited == tc.keyVisited, "unexpected KeyFunc invocation")
+		})
+	}
+}
+
+func TestVerifyKeyType(t *testing.T) {
+	p256, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	p384, _ := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	edpub, _, _ := ed25519.GenerateKey(rand.Reader)
+
+	for k, tc := range []struct {
+		alg   string
+		key   interface{}
+		valid bool
+	}{
+		{alg: "HS256", key: secret, valid: true},
+		{alg: "HS256", key: &prkey.PublicKey},
+		{alg: "RS256", key: &prkey.PublicKey, valid: true},
+		{alg: "PS512", key: &prkey.PublicKey, valid: true},
+		// a PEM encoded public key returned as bytes must not be usable as an HMAC secret of an RSA algorithm
+		{alg: "RS256", key: []byte("-----BEGIN PUBLIC KEY-----")},
+		{alg: "ES256", key: &p256.PublicKey, valid: true},
+		{alg: "ES384", key: &p256.PublicKey},
+		{alg: "ES384", key: &p384.PublicKey, valid: true},
+		{alg: "ES256", key: &prkey.PublicKey},
+		{alg: "EdDSA", key: edpub, valid: true},
+		{alg: "EdDSA", key: secret},
+		{alg: "none", key: jwt.UnsafeAllowNoneSignatureType},
+	} {
+		t.Run(fmt.Sprintf("case=%d", k), func(t *testing.T) {
+			err := verifyKeyType(tc.alg, tc.key)
+			if tc.valid {
+				testx.AssertNoError(t, err)
+			} else {
+				testx.AssertError(t, err)
+			}
+		})
+	}
+}
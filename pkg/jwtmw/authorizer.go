@@ -0,0 +1,107 @@
+package jwtmw
+
+import (
+	"context"
+	"fmt"
+	"github.com/golang-jwt/jwt/v4"
+	"net/http"
+)
+
+// Resource describes the resource a request accesses, typically extracted from the route.
+type Resource struct {
+	// Type is the kind of the resource (e.g., "project").
+	Type string `json:"type,omitempty"`
+	// ID identifies the resource (e.g., the project id of /projects/{id}).
+	ID string `json:"id,omitempty"`
+	// Action is the operation performed on the resource (e.g., "edit").
+	Action string `json:"action,omitempty"`
+	// Attributes are arbitrary attributes of the resource (e.g., the owner of the project).
+	Attributes map[string]interface{} `json:"attributes,omitempty"`
+}
+
+// ResourceFromRequest extracts the accessed resource from r, e.g., by the path parameters of the router in use.
+type ResourceFromRequest func(r *http.Request) (*Resource, error)
+
+// Decision is the outcome of an authorization.
+type Decision struct {
+	// Allowed is true if the request is allowed.
+	Allowed bool
+	// Reason explains the decision (e.g., the name of the matched rule).
+	Reason string
+}
+
+// Authorizer is a policy decision point that decides whether the subject of a verified token
+// may perform the request on the resource.
+type Authorizer interface {
+	Authorize(ctx context.Context, t *jwt.Token, r *http.Request, res *Resource) (*Decision, error)
+}
+
+// AuthorizerFunc is a function that implements Authorizer.
+type AuthorizerFunc func(ctx context.Context, t *jwt.Token, r *http.Request, res *Resource) (*Decision, error)
+
+func (f AuthorizerFunc) Authorize(ctx context.Context, t *jwt.Token, r *http.Request, res *Resource) (*Decision, error) {
+	return f(ctx, t, r, res)
+}
+
+// AuthorizationError is the cause of an access_denied ValidationError.
+type AuthorizationError struct {
+	// Resource is the resource access was denied to.
+	Resource *Resource
+	// Reason is the reason of the decision.
+	Reason string
+}
+
+func (e *AuthorizationError) Error() string {
+	return fmt.Sprintf("access denied: %s", e.Reason)
+}
+
+// WithAuthorizer returns a middleware that asks a, after the JWT middleware, whether the token put in context
+// may access the resource extracted from the request by resource.
+// It uses the same options as WithScopesCustom.
+func WithAuthorizer(a Authorizer, resource ResourceFromRequest, opt ...WithScopesOpt) func(next http.Handler) http.Handler {
+	if a == nil || resource == nil {
+		panic("authorizer and resource must be set.")
+	}
+
+	opts := newWithScopesOpts(opt)
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			tok, err := opts.TokenFromContext(r.Context())
+			if err != nil {
+				opts.Logger(Info, "missing token")
+				opts.ErrorWriter(w, r, err)
+				return
+			}
+
+			res, err := resource(r)
+			if err != nil {
+				opts.Logger(Info, "error extracting resource: %s", err)
+				opts.ErrorWriter(w, r, &ValidationError{Reason: ReasonAuthorization, Err: err, sentinel: ErrAuthorizationFailed})
+				return
+			}
+
+			d, err := a.Authorize(r.Context(), tok, r, res)
+			if err != nil {
+				opts.Logger(Info, "authorization error: %s", err)
+				opts.ErrorWriter(w, r, &ValidationError{Reason: ReasonAuthorization, Err: err, sentinel: ErrAuthorizationFailed})
+				return
+			}
+
+			if d == nil || !d.Allowed {
+				var reason string
+				if d != nil {
+					reason = d.Reason
+				}
+				opts.Logger(Info, "access denied: %s", reason)
+				opts.ErrorWriter(w, r, &ValidationError{
+					Reason:   ReasonAccessDenied,
+					Err:      &AuthorizationError{Resource: res, Reason: reason},
+					sentinel: ErrMissingClaim,
+				})
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,161 @@
+package jwtmw
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/crossid/crossid-go/pkg/x/testx"
+	"github.com/golang-jwt/jwt/v4"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// projects are owned by their key
+var projects = map[string]string{"p1": "alice", "p2": "bob"}
+
+func projectFromRequest(r *http.Request) (*Resource, error) {
+	id := strings.TrimPrefix(r.URL.Path, "/projects/")
+	owner, ok := projects[id]
+	if !ok {
+		return nil, fmt.Errorf("project '%s' not found", id)
+	}
+	action := "view"
+	if r.Method != http.MethodGet {
+		action = "edit"
+	}
+	return &Resource{Type: "project", ID: id, Action: action, Attributes: map[string]interface{}{"owner": owner}}, nil
+}
+
+func authorize(t *testing.T, a Authorizer, method, path string, claims jwt.MapClaims) (int, error) {
+	var got error
+	mw := WithAuthorizer(a, projectFromRequest, WithErrorWriter(func(w http.ResponseWriter, r *http.Request, err error) {
+		got = err
+		NewBearerErrorWriter()(w, r, err)
+	}))
+
+	r, err := http.NewRequest(method, path, nil)
+	testx.AssertNoError(t, err)
+	r.Header.Set(BearerHeaderKey, signHS256JWT(t, claims))
+	w := httptest.NewRecorder()
+	NewJWT(&JwtMiddlewareOpts{KeyFunc: validKeyFuncHS256}).Handler(mw(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))).ServeHTTP(w, r)
+	return w.Code, got
+}
+
+func TestWithAuthorizer_RuleAuthorizer(t *testing.T) {
+	a := NewRuleAuthorizer(
+		Rule{Name: "suspended", Condition: ClaimsCondition(ClaimEquals("suspended", true)), Effect: Deny},
+		Rule{Name: "anyone can view", ResourceType: "project", Actions: []string{"view"}},
+		Rule{Name: "owner can edit", ResourceType: "project", Actions: []string{"edit"}, Condition: ClaimEqualsAttribute("sub", "owner")},
+	)
+
+	for k, tc := range []struct {
+		method string
+		path   string
+		claims jwt.MapClaims
+		code   int
+		reason Reason
+	}{
+		{method: http.MethodGet, path: "/projects/p2", claims: jwt.MapClaims{"sub": "alice"}, code: http.StatusOK},
+		{method: http.MethodPut, path: "/projects/p1", claims: jwt.MapClaims{"sub": "alice"}, code: http.StatusOK},
+		{method: http.MethodPut, path: "/projects/p2", claims: jwt.MapClaims{"sub": "alice"}, code: http.StatusForbidden, reason: ReasonAccessDenied},
+		{method: http.MethodGet, path: "/projects/p1", claims: jwt.MapClaims{"sub": "alice", "suspended": true}, code: http.StatusForbidden, reason: ReasonAccessDenied},
+		{method: http.MethodGet, path: "/projects/p3", claims: jwt.MapClaims{"sub": "alice"}, code: http.StatusInternalServerError, reason: ReasonAuthorization},
+	} {
+		t.Run(fmt.Sprintf("case=%d", k), func(t *testing.T) {
+			code, err := authorize(t, a, tc.method, tc.path, tc.claims)
+			testx.AssertTrue(t, code == tc.code, fmt.Sprintf("expected code %d but got %d", tc.code, code))
+			if tc.reason == "" {
+				return
+			}
+			sentinel := ErrMissingClaim
+			if tc.reason == ReasonAuthorization {
+				sentinel = ErrAuthorizationFailed
+			}
+			testx.AssertTrue(t, errors.Is(err, sentinel), fmt.Sprintf("expected error to be %s", sentinel))
+			var ve *ValidationError
+			testx.AssertTrue(t, errors.As(err, &ve) && ve.Reason == tc.reason, fmt.Sprintf("expected reason %s but got %v", tc.reason, err))
+		})
+	}
+
+	d, err := a.Authorize(context.Background(), &jwt.Token{Claims: jwt.MapClaims{}}, nil, &Resource{Type: "invoice", Action: "view"})
+	testx.AssertNoError(t, err)
+	testx.AssertTrue(t, !d.Allowed && d.Reason == "no rule matched", "expected unmatched requests to be denied")
+}
+
+func TestOPAAuthorizer(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Input struct {
+				Claims   map[string]interface{} `json:"claims"`
+				Request  map[string]string      `json:"request"`
+				Resource Resource               `json:"resource"`
+			} `json:"input"`
+		}
+		testx.AssertNoError(t, json.NewDecoder(r.Body).Decode(&body))
+		in := body.Input
+
+		switch {
+		case r.URL.Path == "/v1/data/bool":
+			_ = json.NewEncoder(w).Encode(map[string]interface{}{"result": in.Request["method"] == http.MethodGet})
+		case r.URL.Path == "/v1/data/object":
+			allow := in.Claims["sub"] == in.Resource.Attributes["owner"]
+			_ = json.NewEncoder(w).Encode(map[string]interface{}{"result": map[string]interface{}{"allow": allow, "reason": "owner"}})
+		case r.URL.Path == "/v1/data/undefined":
+			_, _ = w.Write([]byte(`{}`))
+		default:
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer srv.Close()
+
+	for k, tc := range []struct {
+		url    string
+		method string
+		path   string
+		code   int
+		reason Reason
+	}{
+		{url: "/v1/data/bool", method: http.MethodGet, path: "/projects/p2", code: http.StatusOK},
+		{url: "/v1/data/bool", method: http.MethodPut, path: "/projects/p1", code: http.StatusForbidden, reason: ReasonAccessDenied},
+		{url: "/v1/data/object", method: http.MethodPut, path: "/projects/p1", code: http.StatusOK},
+		{url: "/v1/data/object", method: http.MethodPut, path: "/projects/p2", code: http.StatusForbidden, reason: ReasonAccessDenied},
+		{url: "/v1/data/undefined", method: http.MethodGet, path: "/projects/p1", code: http.StatusForbidden, reason: ReasonAccessDenied},
+		{url: "/v1/data/error", method: http.MethodGet, path: "/projects/p1", code: http.StatusInternalServerError, reason: ReasonAuthorization},
+	} {
+		t.Run(fmt.Sprintf("case=%d", k), func(t *testing.T) {
+			a := NewOPAAuthorizer(&OPAAuthorizerOpts{URL: srv.URL + tc.url})
+			code, err := authorize(t, a, tc.method, tc.path, jwt.MapClaims{"sub": "alice"})
+			testx.AssertTrue(t, code == tc.code, fmt.Sprintf("expected code %d but got %d", tc.code, code))
+			if tc.reason == "" {
+				return
+			}
+			var ve *ValidationError
+			testx.AssertTrue(t, errors.As(err, &ve) && ve.Reason == tc.reason, fmt.Sprintf("expected reason %s but got %v", tc.reason, err))
+		})
+	}
+}
+
+func TestWithAuthorizer_FailingDecisionPoint(t *testing.T) {
+	unreachable := NewOPAAuthorizer(&OPAAuthorizerOpts{URL: "http://127.0.0.1:1/v1/data/allow"})
+	timeout := AuthorizerFunc(func(ctx context.Context, t *jwt.Token, r *http.Request, res *Resource) (*Decision, error) {
+		return nil, context.DeadlineExceeded
+	})
+
+	for k, a := range []Authorizer{unreachable, timeout} {
+		t.Run(fmt.Sprintf("case=%d", k), func(t *testing.T) {
+			// by the bearer error writer and the default error writer
+			for _, opts := range [][]WithScopesOpt{{WithErrorWriter(NewBearerErrorWriter())}, nil} {
+				r := httptest.NewRequest(http.MethodGet, "/projects/p1", nil)
+				r = r.WithContext(context.WithValue(r.Context(), TokenCtxKey, &jwt.Token{Claims: jwt.MapClaims{"sub": "alice"}, Valid: true}))
+				w := httptest.NewRecorder()
+				WithAuthorizer(a, projectFromRequest, opts...)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})).ServeHTTP(w, r)
+				testx.AssertTrue(t, w.Code == http.StatusInternalServerError, fmt.Sprintf("expected code 500 but got %d", w.Code))
+				testx.AssertTrue(t, w.Header().Get("WWW-Authenticate") == "", "expected no challenge of a failed decision")
+				testx.AssertTrue(t, !strings.Contains(w.Body.String(), "insufficient"), "expected a failed decision not to be reported as insufficient privileges")
+			}
+		})
+	}
+}
@@ -0,0 +1,140 @@
+package jwtmw
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Error codes of RFC 6750 section 3.1
+const (
+	BearerErrorInvalidRequest    = "invalid_request"
+	BearerErrorInvalidToken      = "invalid_token"
+	BearerErrorInsufficientScope = "insufficient_scope"
+	// BearerErrorInvalidDPoPProof is defined by RFC 9449 section 7.1
+	BearerErrorInvalidDPoPProof = "invalid_dpop_proof"
+)
+
+// BearerErrorWriterOpts describes the options of the RFC 6750 error writer
+type BearerErrorWriterOpts struct {
+	// Realm is the protection space reported in the WWW-Authenticate header.
+	Realm string
+	// JSON writes the error as a JSON body (e.g., {"error": "invalid_token", "error_description": "..."})
+	// rather a plain text body.
+	JSON bool
+	// DPoPAlgorithms are the signing algorithms of DPoP proofs reported by the "algs" parameter of DPoP challenges,
+	// defaults to the default DPoPOpts.Algorithms.
+	DPoPAlgorithms []string
+}
+
+// NewBearerErrorWriter returns an error writer that complies with RFC 6750 section 3,
+// it sets the WWW-Authenticate header and a status code derived from the error:
+//
+//	ErrMissingToken - 401 without error code, as the client did not attempt to authenticate.
+//	ErrExtractingToken - 400 invalid_request.
+//	ErrMissingClaim - 403 insufficient_scope, with the required scopes if the error wraps a *ScopeError.
+//	ErrAuthorizationFailed - 500 without a challenge, as the access could not be decided.
+//	ReasonDPoPProof - 401 invalid_dpop_proof.
+//	any other error - 401 invalid_token.
+//
+// The challenge uses the DPoP scheme (RFC 9449 section 7.1), with the accepted proof algorithms,
+// if the request was sent with the DPoP scheme or its DPoP proof is invalid, and the Bearer scheme otherwise.
+//
+// It can be used as the ErrorWriter of both JWT.Handler and WithScopesCustom,
+// e.g., JwtMiddlewareOpts{ErrorWriter: NewBearerErrorWriter()} and WithErrorWriter(NewBearerErrorWriter()).
+func NewBearerErrorWriter(opts ...*BearerErrorWriterOpts) func(w http.ResponseWriter, r *http.Request, err error) {
+	o := BearerErrorWriterOpts{DPoPAlgorithms: mergeDPoPOpts().Algorithms}
+	for _, oo := range opts {
+		if oo == nil {
+			continue
+		}
+		if oo.Realm != "" {
+			o.Realm = oo.Realm
+		}
+		if oo.JSON {
+			o.JSON = oo.JSON
+		}
+		if oo.DPoPAlgorithms != nil {
+			o.DPoPAlgorithms = oo.DPoPAlgorithms
+		}
+	}
+
+	return func(w http.ResponseWriter, r *http.Request, err error) {
+		code, status := bearerErrorCode(err)
+
+		var params []string
+		if o.Realm != "" {
+			params = append(params, authParam("realm", o.Realm))
+		}
+		if code != "" {
+			params = append(params, authParam("error", code), authParam("error_description", err.Error()))
+		}
+		var se *ScopeError
+		if errors.As(err, &se) && len(se.Scopes) > 0 {
+			params = append(params, authParam("scope", strings.Join(se.Scopes, " ")))
+		}
+
+		challenge := BearerPrefix
+		if isDPoPChallenge(r, code) {
+			challenge = DPoPPrefix
+			params = append(params, authParam("algs", strings.Join(o.DPoPAlgorithms, " ")))
+		}
+		if len(params) > 0 {
+			challenge += " " + strings.Join(params, ", ")
+		}
+		if status < http.StatusInternalServerError {
+			w.Header().Set("WWW-Authenticate", challenge)
+		}
+
+		if !o.JSON {
+			http.Error(w, err.Error(), status)
+			return
+		}
+
+		body := map[string]string{"error_description": err.Error()}
+		if code != "" {
+			body["error"] = code
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		w.WriteHeader(status)
+		_ = json.NewEncoder(w).Encode(body)
+	}
+}
+
+// bearerErrorCode returns the RFC 6750 error code and the http status code of err
+func bearerErrorCode(err error) (string, int) {
+	switch {
+	case errors.Is(err, ErrMissingToken):
+		return "", http.StatusUnauthorized
+	case errors.Is(err, ErrExtractingToken):
+		return BearerErrorInvalidRequest, http.StatusBadRequest
+	case errors.Is(err, ErrMissingClaim):
+		return BearerErrorInsufficientScope, http.StatusForbidden
+	case errors.Is(err, ErrAuthorizationFailed):
+		return "", http.StatusInternalServerError
+	}
+
+	var ve *ValidationError
+	if errors.As(err, &ve) && ve.Reason == ReasonDPoPProof {
+		return BearerErrorInvalidDPoPProof, http.StatusUnauthorized
+	}
+
+	return BearerErrorInvalidToken, http.StatusUnauthorized
+}
+
+// isDPoPChallenge returns true if the error of r, whose error code is code, should be challenged by the DPoP scheme.
+func isDPoPChallenge(r *http.Request, code string) bool {
+	if code == BearerErrorInvalidDPoPProof {
+		return true
+	}
+	return r != nil && strings.EqualFold(authorizationScheme(r), DPoPPrefix)
+}
+
+// authParam formats an auth-param as a quoted string
+func authParam(k, v string) string {
+	v = strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(v)
+	return fmt.Sprintf(`%s="%s"`, k, v)
+}
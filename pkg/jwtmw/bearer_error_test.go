@@ -0,0 +1,111 @@
+package jwtmw
+
+import (
+	"fmt"
+	"github.com/crossid/crossid-go/pkg/x/testx"
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/tidwall/gjson"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestNewBearerErrorWriter(t *testing.T) {
+	for k, tc := range []struct {
+		opts   *BearerErrorWriterOpts
+		err    error
+		status int
+		header string
+		code   string
+	}{
+		{
+			opts:   &BearerErrorWriterOpts{Realm: "api"},
+			err:    ErrMissingToken,
+			status: http.StatusUnauthorized,
+			header: `Bearer realm="api"`,
+		},
+		{
+			err:    ErrMissingToken,
+			status: http.StatusUnauthorized,
+			header: `Bearer`,
+		},
+		{
+			opts:   &BearerErrorWriterOpts{Realm: "api"},
+			err:    ErrExtractingToken,
+			status: http.StatusBadRequest,
+			header: `Bearer realm="api", error="invalid_request", error_description="error extracting token"`,
+			code:   BearerErrorInvalidRequest,
+		},
+		{
+			err:    newValidationError(ReasonExpired, "exp", fmt.Errorf("token is expired")),
+			status: http.StatusUnauthorized,
+			header: `Bearer error="invalid_token", error_description="invalid token: expired"`,
+			code:   BearerErrorInvalidToken,
+		},
+		{
+			err: &ValidationError{
+				Reason:   ReasonInsufficientScope,
+				Err:      &ScopeError{Scopes: []string{"orders:read", "orders:write"}},
+				sentinel: ErrMissingClaim,
+			},
+			status: http.StatusForbidden,
+			header: `Bearer error="insufficient_scope", error_description="insufficient privileges: insufficient_scope", scope="orders:read orders:write"`,
+			code:   BearerErrorInsufficientScope,
+		},
+		{
+			err:    fmt.Errorf(`a "quoted" error`),
+			status: http.StatusUnauthorized,
+			header: `Bearer error="invalid_token", error_description="a \"quoted\" error"`,
+			code:   BearerErrorInvalidToken,
+		},
+	} {
+		for _, asJSON := range []bool{false, true} {
+			t.Run(fmt.Sprintf("case=%d/json=%v", k, asJSON), func(t *testing.T) {
+				opts := &BearerErrorWriterOpts{JSON: asJSON}
+				w := httptest.NewRecorder()
+				NewBearerErrorWriter(tc.opts, opts)(w, nil, tc.err)
+
+				testx.AssertTrue(t, w.Code == tc.status, fmt.Sprintf("expected code %d but got %d", tc.status, w.Code))
+				testx.AssertTrue(t, w.Header().Get("WWW-Authenticate") == tc.header, fmt.Sprintf("unexpected header: %s", w.Header().Get("WWW-Authenticate")))
+				if asJSON {
+					b, err := ioutil.ReadAll(w.Body)
+					testx.AssertNoError(t, err)
+					testx.AssertTrue(t, gjson.GetBytes(b, "error").String() == tc.code, "unexpected error code")
+					testx.AssertTrue(t, gjson.GetBytes(b, "error_description").String() == tc.err.Error(), "unexpected error description")
+				}
+			})
+		}
+	}
+}
+
+func TestBearerErrorWriter_Middlewares(t *testing.T) {
+	ew := NewBearerErrorWriter(&BearerErrorWriterOpts{Realm: "api"})
+	jmw := NewJWT(&JwtMiddlewareOpts{KeyFunc: validKeyFuncHS256, ErrorWriter: ew})
+	smw := WithScopesCustom([]string{"foo"}, WithErrorWriter(ew))
+	h := jmw.Handler(smw(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})))
+
+	r, err := http.NewRequest(http.MethodGet, "/", nil)
+	testx.AssertNoError(t, err)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	testx.AssertTrue(t, w.Code == http.StatusUnauthorized, fmt.Sprintf("expected code 401 but got %d", w.Code))
+	testx.AssertTrue(t, w.Header().Get("WWW-Authenticate") == `Bearer realm="api"`, "unexpected header")
+
+	r.Header.Set(BearerHeaderKey, signHS256JWT(t, jwt.MapClaims{"scp": []string{"bar"}}))
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	testx.AssertTrue(t, w.Code == http.StatusForbidden, fmt.Sprintf("expected code 403 but got %d", w.Code))
+	testx.AssertTrue(t, w.Header().Get("WWW-Authenticate") == `Bearer realm="api", error="insufficient_scope", error_description="insufficient privileges: insufficient_scope", scope="foo"`, "unexpected header")
+}
+
+func TestErrorWriter_DefaultsToPlainText(t *testing.T) {
+	// a bearer error writer of one middleware does not affect others
+	_ = NewJWT(&JwtMiddlewareOpts{KeyFunc: validKeyFuncHS256, ErrorWriter: NewBearerErrorWriter()})
+
+	h := NewJWT(&JwtMiddlewareOpts{KeyFunc: validKeyFuncHS256}).Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	testx.AssertTrue(t, w.Code == http.StatusUnauthorized, fmt.Sprintf("expected code 401 but got %d", w.Code))
+	testx.AssertTrue(t, w.Header().Get("WWW-Authenticate") == "", "expected no WWW-Authenticate header")
+}
@@ -0,0 +1,206 @@
+package jwtmw
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/crossid/crossid-go/pkg/x/claimsx"
+	"github.com/crossid/crossid-go/pkg/x/stringslice"
+	"github.com/golang-jwt/jwt/v4"
+	"strings"
+	"time"
+)
+
+// AudienceMatch defines how the required audiences are matched against the "aud" claim.
+type AudienceMatch int
+
+const (
+	// AudienceMatchAny requires at least one of the required audiences to be present.
+	AudienceMatchAny AudienceMatch = iota
+	// AudienceMatchAll requires all the required audiences to be present.
+	AudienceMatchAll
+)
+
+// timeClaimsErrors are the validation errors of the time based claims, which are subject to leeway.
+const timeClaimsErrors = jwt.ValidationErrorExpired | jwt.ValidationErrorNotValidYet | jwt.ValidationErrorIssuedAt
+
+// claimsPolicy describes the registered claims expected by the middleware.
+type claimsPolicy struct {
+	issuers         []string
+	audiences       []string
+	audienceMatch   AudienceMatch
+	authorizedParty string
+	maxAge          time.Duration
+	leeway          time.Duration
+}
+
+func newClaimsPolicy(o *JwtMiddlewareOpts) *claimsPolicy {
+	return &claimsPolicy{
+		issuers:         o.Issuers,
+		audiences:       o.Audiences,
+		audienceMatch:   o.AudienceMatch,
+		authorizedParty: o.AuthorizedParty,
+		maxAge:          o.MaxAge,
+		leeway:          o.Leeway,
+	}
+}
+
+// verify validates the claims of t at the time now, a failure is returned as a *ValidationError.
+// the claims' own Valid() runs first, time based failures are then re-evaluated with leeway.
+// the registered claims are read from the raw token so the same rules apply to any claims type.
+// jwt's own claims types overflow dates out of the int64 range, their time claims are always re-evaluated.
+func (p *claimsPolicy) verify(t *jwt.Token, now time.Time) error {
+	var mc jwt.MapClaims
+	verifyTimes := false
+	switch t.Claims.(type) {
+	case jwt.MapClaims, *jwt.RegisteredClaims:
+		verifyTimes = true
+	}
+
+	err := t.Claims.Valid()
+	if err != nil {
+		var ve *jwt.ValidationError
+		if p.leeway <= 0 || !errors.As(err, &ve) || ve.Errors&^timeClaimsErrors != 0 {
+			return asValidationError(err)
+		}
+		verifyTimes = true
+	}
+
+	if verifyTimes {
+		if mc, err = rawClaims(t); err != nil {
+			return newValidationError(ReasonMalformed, "", err)
+		}
+		if err := p.verifyTimes(mc, now); err != nil {
+			return err
+		}
+	}
+
+	if len(p.issuers) == 0 && len(p.audiences) == 0 && p.authorizedParty == "" && p.maxAge == 0 {
+		return nil
+	}
+
+	if mc == nil {
+		if mc, err = rawClaims(t); err != nil {
+			return newValidationError(ReasonMalformed, "", err)
+		}
+	}
+
+	if len(p.issuers) > 0 {
+		iss, _ := mc["iss"].(string)
+		if stringslice.IndexOf(p.issuers, iss) == -1 {
+			return newValidationError(ReasonIssuer, "iss", fmt.Errorf("unexpected issuer '%s'", iss))
+		}
+	}
+
+	if len(p.audiences) > 0 {
+		if err := p.verifyAudience(claimsx.Audiences(mc)); err != nil {
+			return err
+		}
+	}
+
+	if p.authorizedParty != "" {
+		if azp, _ := mc["azp"].(string); azp != p.authorizedParty {
+			return newValidationError(ReasonAuthorizedParty, "azp", fmt.Errorf("unexpected authorized party '%s'", azp))
+		}
+	}
+
+	if p.maxAge > 0 {
+		iat, ok := claimsx.NumericDate(mc["iat"])
+		if !ok {
+			return newValidationError(ReasonMaxAge, "iat", fmt.Errorf("token has no iat claim"))
+		}
+		if now.Sub(iat) > p.maxAge+p.leeway {
+			return newValidationError(ReasonMaxAge, "iat", fmt.Errorf("token was issued more than %s ago", p.maxAge))
+		}
+	}
+
+	return nil
+}
+
+// verifyTimes validates the exp, nbf and iat claims of mc, tolerating a clock skew of leeway.
+func (p *claimsPolicy) verifyTimes(mc jwt.MapClaims, now time.Time) error {
+	if exp, ok := claimsx.NumericDate(mc["exp"]); ok && !now.Before(exp.Add(p.leeway)) {
+		return newValidationError(ReasonExpired, "exp", jwt.ErrTokenExpired)
+	}
+	if nbf, ok := claimsx.NumericDate(mc["nbf"]); ok && now.Add(p.leeway).Before(nbf) {
+		return newValidationError(ReasonNotValidYet, "nbf", jwt.ErrTokenNotValidYet)
+	}
+	if iat, ok := claimsx.NumericDate(mc["iat"]); ok && now.Add(p.leeway).Before(iat) {
+		return newValidationError(ReasonIssuedAt, "iat", jwt.ErrTokenUsedBeforeIssued)
+	}
+
+	return nil
+}
+
+func (p *claimsPolicy) verifyAudience(aud []string) error {
+	for _, a := range p.audiences {
+		found := stringslice.IndexOf(aud, a) > -1
+		if found && p.audienceMatch == AudienceMatchAny {
+			return nil
+		}
+		if !found && p.audienceMatch == AudienceMatchAll {
+			return newValidationError(ReasonAudience, "aud", fmt.Errorf("audience '%s' is missing", a))
+		}
+	}
+
+	if p.audienceMatch == AudienceMatchAny {
+		return newValidationError(ReasonAudience, "aud", fmt.Errorf("none of the expected audiences is present"))
+	}
+
+	return nil
+}
+
+// MapClaimsFromToken returns the claims of t as a map, regardless of the claims type t was parsed into.
+// t is expected to be verified, e.g., a token put in context by the JWT middleware.
+func MapClaimsFromToken(t *jwt.Token) (jwt.MapClaims, error) {
+	return rawClaims(t)
+}
+
+// rawClaims decodes the claims segment of t into a map, regardless of the claims type t was decoded into.
+// it does not verify the token, callers should rely on it only after the signature is verified
+// or for routing decisions that are verified later on.
+func rawClaims(t *jwt.Token) (jwt.MapClaims, error) {
+	if mc, ok := t.Claims.(jwt.MapClaims); ok {
+		return mc, nil
+	}
+
+	parts := strings.Split(t.Raw, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("token contains an invalid number of segments")
+	}
+
+	b, err := jwt.DecodeSegment(parts[1])
+	if err != nil {
+		return nil, err
+	}
+
+	mc := jwt.MapClaims{}
+	if err := json.Unmarshal(b, &mc); err != nil {
+		return nil, err
+	}
+
+	return mc, nil
+}
+
+// copyClaims returns a deep copy of mc, which may be modified without affecting mc.
+func copyClaims(mc jwt.MapClaims) jwt.MapClaims {
+	c := make(jwt.MapClaims, len(mc))
+	for k, v := range mc {
+		c[k] = copyClaimValue(v)
+	}
+	return c
+}
+
+func copyClaimValue(v interface{}) interface{} {
+	switch vv := v.(type) {
+	case map[string]interface{}:
+		return map[string]interface{}(copyClaims(vv))
+	case []interface{}:
+		c := make([]interface{}, len(vv))
+		for k, e := range vv {
+			c[k] = copyClaimValue(e)
+		}
+		return c
+	}
+	return v
+}
@@ -0,0 +1,43 @@
+package jwtmw
+
+import (
+	"github.com/golang-jwt/jwt/v4"
+	"reflect"
+)
+
+// ClaimsFactory returns a new claims value that a single token is decoded into (e.g., func() jwt.Claims { return &MyClaims{} }).
+type ClaimsFactory func() jwt.Claims
+
+// ClaimsFactoryOf returns a ClaimsFactory of claims of the same type as prototype.
+// a pointer prototype yields a new zero value of the pointed type, a map prototype (e.g., jwt.MapClaims) yields a new empty map,
+// any other prototype is returned as is. a nil prototype yields jwt.MapClaims.
+func ClaimsFactoryOf(prototype jwt.Claims) ClaimsFactory {
+	if prototype == nil {
+		return func() jwt.Claims { return jwt.MapClaims{} }
+	}
+	if _, ok := prototype.(jwt.MapClaims); ok {
+		return func() jwt.Claims { return jwt.MapClaims{} }
+	}
+
+	t := reflect.TypeOf(prototype)
+	switch t.Kind() {
+	case reflect.Ptr:
+		return func() jwt.Claims {
+			return reflect.New(t.Elem()).Interface().(jwt.Claims)
+		}
+	case reflect.Map:
+		return func() jwt.Claims {
+			return reflect.MakeMap(t).Interface().(jwt.Claims)
+		}
+	}
+
+	return func() jwt.Claims { return prototype }
+}
+
+// claimsFactory returns f if set, otherwise a factory of the prototype c.
+func claimsFactory(f ClaimsFactory, c jwt.Claims) ClaimsFactory {
+	if f != nil {
+		return f
+	}
+	return ClaimsFactoryOf(c)
+}
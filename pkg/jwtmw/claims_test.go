@@ -0,0 +1,183 @@
+package jwtmw
+
+import (
+	"fmt"
+	"github.com/crossid/crossid-go/pkg/x/testx"
+	"github.com/golang-jwt/jwt/v4"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestJWT_ValidateRegisteredClaims(t *testing.T) {
+	now := time.Now()
+	for k, tc := range []struct {
+		name  string
+		opts  *JwtMiddlewareOpts
+		jwt   string
+		valid bool
+	}{
+		{
+			name:  "expected issuer",
+			opts:  &JwtMiddlewareOpts{Issuers: []string{"a.crossid.io", "b.crossid.io"}},
+			jwt:   signHS256JWT(t, jwt.MapClaims{"iss": "b.crossid.io"}),
+			valid: true,
+		},
+		{
+			name: "unexpected issuer",
+			opts: &JwtMiddlewareOpts{Issuers: []string{"a.crossid.io"}},
+			jwt:  signHS256JWT(t, jwt.MapClaims{"iss": "evil.io"}),
+		},
+		{
+			name: "missing issuer",
+			opts: &JwtMiddlewareOpts{Issuers: []string{"a.crossid.io"}},
+			jwt:  signHS256JWT(t, jwt.MapClaims{}),
+		},
+		{
+			name:  "issuer of custom claims",
+			opts:  &JwtMiddlewareOpts{Issuers: []string{"a.crossid.io"}, Claims: &claimsWithScopes{}},
+			jwt:   signHS256JWT(t, claimsWithScopes{StandardClaims: jwt.StandardClaims{Issuer: "a.crossid.io"}}),
+			valid: true,
+		},
+		{
+			name: "unexpected issuer of custom claims",
+			opts: &JwtMiddlewareOpts{Issuers: []string{"a.crossid.io"}, Claims: &claimsWithScopes{}},
+			jwt:  signHS256JWT(t, claimsWithScopes{StandardClaims: jwt.StandardClaims{Issuer: "evil.io"}}),
+		},
+		{
+			name:  "audience as string",
+			opts:  &JwtMiddlewareOpts{Audiences: []string{"api"}},
+			jwt:   signHS256JWT(t, jwt.StandardClaims{Audience: "api"}),
+			valid: true,
+		},
+		{
+			name:  "audience as array",
+			opts:  &JwtMiddlewareOpts{Audiences: []string{"api"}, Claims: &jwt.RegisteredClaims{}},
+			jwt:   signHS256JWT(t, jwt.RegisteredClaims{Audience: jwt.ClaimStrings{"web", "api"}}),
+			valid: true,
+		},
+		{
+			name:  "any audience",
+			opts:  &JwtMiddlewareOpts{Audiences: []string{"api", "other"}},
+			jwt:   signHS256JWT(t, jwt.MapClaims{"aud": []string{"api"}}),
+			valid: true,
+		},
+		{
+			name: "no audience matches",
+			opts: &JwtMiddlewareOpts{Audiences: []string{"api", "other"}},
+			jwt:  signHS256JWT(t, jwt.MapClaims{"aud": []string{"web"}}),
+		},
+		{
+			name: "missing audience",
+			opts: &JwtMiddlewareOpts{Audiences: []string{"api"}},
+			jwt:  signHS256JWT(t, jwt.MapClaims{}),
+		},
+		{
+			name:  "all audiences",
+			opts:  &JwtMiddlewareOpts{Audiences: []string{"api", "other"}, AudienceMatch: AudienceMatchAll},
+			jwt:   signHS256JWT(t, jwt.MapClaims{"aud": []string{"other", "web", "api"}}),
+			valid: true,
+		},
+		{
+			name: "not all audiences",
+			opts: &JwtMiddlewareOpts{Audiences: []string{"api", "other"}, AudienceMatch: AudienceMatchAll},
+			jwt:  signHS256JWT(t, jwt.MapClaims{"aud": []string{"api"}}),
+		},
+		{
+			name:  "authorized party",
+			opts:  &JwtMiddlewareOpts{AuthorizedParty: "client"},
+			jwt:   signHS256JWT(t, jwt.MapClaims{"azp": "client"}),
+			valid: true,
+		},
+		{
+			name: "unexpected authorized party",
+			opts: &JwtMiddlewareOpts{AuthorizedParty: "client"},
+			jwt:  signHS256JWT(t, jwt.MapClaims{"azp": "other"}),
+		},
+		{
+			name:  "max age",
+			opts:  &JwtMiddlewareOpts{MaxAge: time.Minute},
+			jwt:   signHS256JWT(t, jwt.MapClaims{"iat": now.Add(-30 * time.Second).Unix()}),
+			valid: true,
+		},
+		{
+			name: "too old",
+			opts: &JwtMiddlewareOpts{MaxAge: time.Minute},
+			jwt:  signHS256JWT(t, jwt.MapClaims{"iat": now.Add(-2 * time.Minute).Unix()}),
+		},
+		{
+			name: "max age requires iat",
+			opts: &JwtMiddlewareOpts{MaxAge: time.Minute},
+			jwt:  signHS256JWT(t, jwt.MapClaims{}),
+		},
+		{
+			name:  "expired within leeway",
+			opts:  &JwtMiddlewareOpts{Leeway: time.Minute},
+			jwt:   signHS256JWT(t, jwt.MapClaims{"exp": now.Add(-30 * time.Second).Unix()}),
+			valid: true,
+		},
+		{
+			name: "expired beyond leeway",
+			opts: &JwtMiddlewareOpts{Leeway: time.Minute},
+			jwt:  signHS256JWT(t, jwt.MapClaims{"exp": now.Add(-2 * time.Minute).Unix()}),
+		},
+		{
+			name:  "not valid yet within leeway",
+			opts:  &JwtMiddlewareOpts{Leeway: time.Minute},
+			jwt:   signHS256JWT(t, jwt.MapClaims{"nbf": now.Add(30 * time.Second).Unix(), "iat": now.Add(30 * time.Second).Unix()}),
+			valid: true,
+		},
+		{
+			name: "not valid yet beyond leeway",
+			opts: &JwtMiddlewareOpts{Leeway: time.Minute},
+			jwt:  signHS256JWT(t, jwt.MapClaims{"nbf": now.Add(2 * time.Minute).Unix()}),
+		},
+		{
+			name: "far future not before",
+			opts: &JwtMiddlewareOpts{},
+			jwt:  signHS256JWT(t, jwt.MapClaims{"nbf": 1e12}),
+		},
+		{
+			name: "far future issued at",
+			opts: &JwtMiddlewareOpts{},
+			jwt:  signHS256JWT(t, jwt.MapClaims{"iat": 1e300}),
+		},
+		{
+			name:  "far future expiry",
+			opts:  &JwtMiddlewareOpts{},
+			jwt:   signHS256JWT(t, jwt.MapClaims{"exp": 1e12}),
+			valid: true,
+		},
+		{
+			name:  "leeway applies to registered claims",
+			opts:  &JwtMiddlewareOpts{Leeway: time.Minute, Claims: &jwt.RegisteredClaims{}},
+			jwt:   signHS256JWT(t, jwt.RegisteredClaims{ExpiresAt: jwt.NewNumericDate(now.Add(-30 * time.Second))}),
+			valid: true,
+		},
+		{
+			name:  "leeway applies to custom claims",
+			opts:  &JwtMiddlewareOpts{Leeway: time.Minute, Claims: &claimsWithScopes{}},
+			jwt:   signHS256JWT(t, claimsWithScopes{StandardClaims: jwt.StandardClaims{ExpiresAt: now.Add(-30 * time.Second).Unix()}}),
+			valid: true,
+		},
+		{
+			name: "leeway does not bypass custom claims validation",
+			opts: &JwtMiddlewareOpts{Leeway: time.Minute, Claims: &fooClaim{}},
+			jwt:  signHS256JWT(t, jwt.MapClaims{"thefoo": "baz"}),
+		},
+	} {
+		t.Run(fmt.Sprintf("case=%d", k), func(t *testing.T) {
+			r, err := http.NewRequest(http.MethodGet, "/", nil)
+			testx.AssertNoError(t, err)
+			r.Header.Set(BearerHeaderKey, tc.jwt)
+
+			tc.opts.KeyFunc = validKeyFuncHS256
+			_, err = NewJWT(tc.opts).Validate(r)
+			if tc.valid {
+				testx.AssertNoError(t, err)
+			} else {
+				testx.AssertError(t, err)
+			}
+		})
+	}
+}
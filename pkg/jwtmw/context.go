@@ -0,0 +1,121 @@
+package jwtmw
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/golang-jwt/jwt/v4"
+	"strings"
+)
+
+// ctxKey is the type of the context keys of this package, it prevents collisions with keys of other packages.
+type ctxKey int
+
+const (
+	// TokenCtxKey is the default context key of the verified token put in context by the JWT middleware.
+	TokenCtxKey ctxKey = iota
+	// principalCtxKey is the context key of the principal put in context by the JWT middleware.
+	principalCtxKey
+)
+
+// TokenFromContext returns the verified token put in context by the JWT middleware.
+// the accessors of this file read the token stored under the default TokenCtxKey,
+// tokens stored under a custom JwtMiddlewareOpts.TokenCtxKey must be read by that key.
+func TokenFromContext(ctx context.Context) (*jwt.Token, bool) {
+	return tokenFromContext(ctx, TokenCtxKey)
+}
+
+func tokenFromContext(ctx context.Context, key interface{}) (*jwt.Token, bool) {
+	t, ok := ctx.Value(key).(*jwt.Token)
+	return t, ok && t != nil
+}
+
+// ClaimsFromContext returns the claims of the verified token as a map, regardless of the claims type it was parsed into.
+func ClaimsFromContext(ctx context.Context) (jwt.MapClaims, bool) {
+	t, ok := TokenFromContext(ctx)
+	if !ok {
+		return nil, false
+	}
+
+	mc, err := rawClaims(t)
+	if err != nil {
+		return nil, false
+	}
+
+	return mc, true
+}
+
+// ClaimsAs returns the claims of the verified token as T, e.g., ClaimsAs[*jwt.RegisteredClaims](ctx).
+// if the token was not parsed into T (see JwtMiddlewareOpts.Claims) the claims are decoded into a new T,
+// which must then be a struct, a pointer to a struct or a map.
+func ClaimsAs[T any](ctx context.Context) (T, bool) {
+	var zero T
+
+	t, ok := TokenFromContext(ctx)
+	if !ok {
+		return zero, false
+	}
+	if c, ok := t.Claims.(T); ok {
+		return c, true
+	}
+
+	mc, err := rawClaims(t)
+	if err != nil {
+		return zero, false
+	}
+	b, err := json.Marshal(mc)
+	if err != nil {
+		return zero, false
+	}
+
+	var c T
+	if err := json.Unmarshal(b, &c); err != nil {
+		return zero, false
+	}
+
+	return c, true
+}
+
+// SubjectFromContext returns the "sub" claim of the verified token.
+func SubjectFromContext(ctx context.Context) (string, bool) {
+	mc, ok := ClaimsFromContext(ctx)
+	if !ok {
+		return "", false
+	}
+
+	sub, ok := mc["sub"].(string)
+	return sub, ok && sub != ""
+}
+
+// ScopesFromContext returns the scopes of the verified token, either from the ScopesClaim, an array
+// or a space delimited string, or from the space delimited "scope" claim.
+func ScopesFromContext(ctx context.Context) ([]string, bool) {
+	mc, ok := ClaimsFromContext(ctx)
+	if !ok {
+		return nil, false
+	}
+
+	return scopesFromClaims(mc)
+}
+
+func scopesFromClaims(mc jwt.MapClaims) ([]string, bool) {
+	switch v := mc[ScopesClaim].(type) {
+	case []string:
+		return v, true
+	case []interface{}:
+		scopes := make([]string, 0, len(v))
+		for _, s := range v {
+			if s, ok := s.(string); ok {
+				scopes = append(scopes, s)
+			}
+		}
+		return scopes, true
+	case string:
+		return strings.Fields(v), true
+	}
+
+	if s, ok := mc["scope"].(string); ok {
+		return strings.Fields(s), true
+	}
+
+	return nil, false
+}
@@ -0,0 +1,77 @@
+package jwtmw
+
+import (
+	"context"
+	"fmt"
+	"github.com/crossid/crossid-go/pkg/x/testx"
+	"github.com/golang-jwt/jwt/v4"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestContextAccessors(t *testing.T) {
+	for k, tc := range []struct {
+		opts *JwtMiddlewareOpts
+	}{
+		{opts: &JwtMiddlewareOpts{}},
+		{opts: &JwtMiddlewareOpts{Claims: &claimsWithScopes{}}},
+	} {
+		t.Run(fmt.Sprintf("case=%d", k), func(t *testing.T) {
+			r, err := http.NewRequest(http.MethodGet, "/", nil)
+			testx.AssertNoError(t, err)
+			r.Header.Set(BearerHeaderKey, signHS256JWT(t, jwt.MapClaims{"sub": "alice", "scp": []string{"a", "b"}, "iss": "crossid.io"}))
+
+			called := false
+			h := func(w http.ResponseWriter, r *http.Request) {
+				called = true
+				ctx := r.Context()
+
+				tok, ok := TokenFromContext(ctx)
+				testx.AssertTrue(t, ok && tok.Valid, "expected a valid token")
+
+				mc, ok := ClaimsFromContext(ctx)
+				testx.AssertTrue(t, ok && mc["iss"] == "crossid.io", "unexpected claims")
+
+				sub, ok := SubjectFromContext(ctx)
+				testx.AssertTrue(t, ok && sub == "alice", "unexpected subject")
+
+				scopes, ok := ScopesFromContext(ctx)
+				testx.AssertTrue(t, ok && strings.Join(scopes, " ") == "a b", "unexpected scopes")
+
+				rc, ok := ClaimsAs[*jwt.RegisteredClaims](ctx)
+				testx.AssertTrue(t, ok && rc.Subject == "alice" && rc.Issuer == "crossid.io", "unexpected registered claims")
+
+				sc, ok := ClaimsAs[claimsWithScopes](ctx)
+				testx.AssertTrue(t, ok && len(sc.Scopes) == 2, "unexpected typed claims")
+			}
+			NewJWT(&JwtMiddlewareOpts{KeyFunc: validKeyFuncHS256}, tc.opts).Handler(http.HandlerFunc(h)).ServeHTTP(httptest.NewRecorder(), r)
+			testx.AssertTrue(t, called, "expected handler to be called")
+		})
+	}
+}
+
+func TestContextAccessors_Missing(t *testing.T) {
+	ctx := context.WithValue(context.Background(), "crossidTokenKey", "not a token")
+	_, ok := TokenFromContext(ctx)
+	testx.AssertTrue(t, !ok, "expected no token")
+	_, ok = ClaimsFromContext(ctx)
+	testx.AssertTrue(t, !ok, "expected no claims")
+	_, ok = SubjectFromContext(ctx)
+	testx.AssertTrue(t, !ok, "expected no subject")
+	_, ok = ScopesFromContext(ctx)
+	testx.AssertTrue(t, !ok, "expected no scopes")
+	_, ok = ClaimsAs[jwt.MapClaims](ctx)
+	testx.AssertTrue(t, !ok, "expected no claims")
+
+	// a value of another type under the key is reported as a missing token rather a panic
+	ctx = context.WithValue(context.Background(), TokenCtxKey, "not a token")
+	_, err := newWithScopesOpts(nil).TokenFromContext(ctx)
+	testx.AssertTrue(t, err == ErrMissingToken, "expected ErrMissingToken")
+}
+
+func TestScopesFromClaims(t *testing.T) {
+	scopes, ok := scopesFromClaims(jwt.MapClaims{"scope": "openid profile"})
+	testx.AssertTrue(t, ok && strings.Join(scopes, " ") == "openid profile", "expected scopes from the scope claim")
+}
@@ -0,0 +1,58 @@
+package jwtmw
+
+import (
+	"context"
+	"fmt"
+	"github.com/crossid/crossid-go/pkg/oidc"
+	"github.com/crossid/crossid-go/pkg/x/stringslice"
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// NewJWTFromIssuer discovers the OpenID provider of issuer (e.g., https://<tenant>.crossid.io/oauth2/)
+// and returns a middleware whose keys, allowed algorithms and expected issuer are derived from
+// the provider configuration document, which is re-read periodically.
+// Close should be called once the middleware is no longer needed.
+func NewJWTFromIssuer(ctx context.Context, issuer string, opts ...*JwtMiddlewareOpts) (*JWT, error) {
+	p, err := oidc.NewProvider(ctx, issuer)
+	if err != nil {
+		return nil, err
+	}
+
+	j := NewJWTFromProvider(p, opts...)
+	j.closers = append(j.closers, p.Close)
+	return j, nil
+}
+
+// NewJWTFromProvider is like NewJWTFromIssuer but with a provider that is owned by the caller.
+// the KeyFunc and KeySetVersion options are derived from p and cannot be overridden.
+func NewJWTFromProvider(p *oidc.Provider, opts ...*JwtMiddlewareOpts) *JWT {
+	return NewJWT(append(opts, &JwtMiddlewareOpts{
+		KeyFunc: providerKeyFunc(p),
+		KeySetVersion: func() uint64 {
+			return p.KeySet().Version()
+		},
+	})...)
+}
+
+// providerKeyFunc returns a Keyfunc that rejects tokens that were not issued by p
+// or signed by an algorithm p does not advertise, before looking up a key.
+func providerKeyFunc(p *oidc.Provider) Keyfunc {
+	return func(ctx context.Context, t *jwt.Token) (interface{}, error) {
+		md := p.Metadata()
+
+		alg, _ := t.Header["alg"].(string)
+		if stringslice.IndexOf(md.SigningAlgs(), alg) == -1 {
+			return nil, newValidationError(ReasonAlgorithm, "", fmt.Errorf("signing algorithm '%s' is not supported by the provider", alg))
+		}
+
+		c, err := rawClaims(t)
+		if err != nil {
+			return nil, newValidationError(ReasonMalformed, "", err)
+		}
+		if !c.VerifyIssuer(md.Issuer, true) {
+			return nil, newValidationError(ReasonIssuer, "iss", fmt.Errorf("token was not issued by '%s'", md.Issuer))
+		}
+
+		return p.KeyFunc(ctx, t)
+	}
+}
@@ -0,0 +1,111 @@
+package jwtmw
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"github.com/crossid/crossid-go/pkg/x/testx"
+	"github.com/golang-jwt/jwt/v4"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+const testKid = "k1"
+
+// newFakeIssuer serves a discovery document and a key set with the public key of prkey.
+func newFakeIssuer(t *testing.T, algs ...string) *httptest.Server {
+	mux := http.NewServeMux()
+	srv := httptest.NewServer(mux)
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		testx.AssertNoError(t, json.NewEncoder(w).Encode(map[string]interface{}{
+			"issuer":                                srv.URL,
+			"jwks_uri":                              srv.URL + "/.well-known/jwks.json",
+			"id_token_signing_alg_values_supported": algs,
+		}))
+	})
+	mux.HandleFunc("/.well-known/jwks.json", func(w http.ResponseWriter, r *http.Request) {
+		testx.AssertNoError(t, json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []map[string]string{{
+				"kty": "RSA",
+				"kid": testKid,
+				"n":   base64.RawURLEncoding.EncodeToString(prkey.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(prkey.E)).Bytes()),
+			}},
+		}))
+	})
+
+	return srv
+}
+
+func signRS256WithKid(t *testing.T, c jwt.Claims) string {
+	tok := jwt.NewWithClaims(jwt.SigningMethodRS256, c)
+	tok.Header["kid"] = testKid
+	b, err := tok.SignedString(prkey)
+	testx.AssertNoError(t, err)
+	return fmt.Sprintf("%s %s", BearerPrefix, b)
+}
+
+func TestNewJWTFromIssuer(t *testing.T) {
+	srv := newFakeIssuer(t, "RS256", "HS256")
+	defer srv.Close()
+
+	j, err := NewJWTFromIssuer(context.Background(), srv.URL)
+	testx.AssertNoError(t, err)
+	defer j.Close()
+
+	for k, tc := range []struct {
+		name  string
+		jwt   string
+		valid bool
+	}{
+		{
+			name:  "issued by provider",
+			jwt:   signRS256WithKid(t, jwt.StandardClaims{Issuer: srv.URL}),
+			valid: true,
+		},
+		{
+			name: "custom claims issued by provider",
+			jwt: signRS256WithKid(t, claimsWithScopes{
+				StandardClaims: jwt.StandardClaims{Issuer: srv.URL},
+				Scopes:         []string{"foo"},
+			}),
+			valid: true,
+		},
+		{
+			name: "issued by another issuer",
+			jwt:  signRS256WithKid(t, jwt.StandardClaims{Issuer: "https://evil.io"}),
+		},
+		{
+			name: "missing issuer",
+			jwt:  signRS256WithKid(t, jwt.StandardClaims{}),
+		},
+		{
+			name: "symmetric algorithms are never accepted",
+			jwt:  signHS256JWT(t, jwt.StandardClaims{Issuer: srv.URL}),
+		},
+	} {
+		t.Run(fmt.Sprintf("case=%d", k), func(t *testing.T) {
+			r, err := http.NewRequest(http.MethodGet, "/", nil)
+			testx.AssertNoError(t, err)
+			r.Header.Set(BearerHeaderKey, tc.jwt)
+
+			_, err = j.Validate(r)
+			if tc.valid {
+				testx.AssertNoError(t, err)
+			} else {
+				testx.AssertError(t, err)
+			}
+		})
+	}
+}
+
+func TestNewJWTFromIssuer_IssuerMismatch(t *testing.T) {
+	srv := newFakeIssuer(t)
+	defer srv.Close()
+
+	_, err := NewJWTFromIssuer(context.Background(), srv.URL+"/other")
+	testx.AssertError(t, err)
+}
@@ -0,0 +1,270 @@
+package jwtmw
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"github.com/crossid/crossid-go/pkg/jwks"
+	"github.com/crossid/crossid-go/pkg/x/claimsx"
+	"github.com/golang-jwt/jwt/v4"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DPoPProofType is the "typ" header of DPoP proofs
+const DPoPProofType = "dpop+jwt"
+
+// DPoPOpts describes the options of DPoP (RFC 9449) proof of possession validation.
+//
+// A token bound to a key, per its "cnf.jkt" claim, must be sent with the DPoP scheme
+// along with a DPoP header that carries a proof signed by that key.
+type DPoPOpts struct {
+	// Required rejects tokens that are not bound to a key.
+	// by default unbound tokens may still be sent with the Bearer scheme.
+	Required bool
+	// Algorithms are the allowed signing algorithms of proofs, defaults to all asymmetric algorithms.
+	Algorithms []string
+	// ProofMaxAge is the maximal age of a proof per its "iat" claim, defaults to 1 minute.
+	ProofMaxAge time.Duration
+	// Leeway is the tolerated clock skew of the proof's "iat" claim, defaults to 5 seconds.
+	Leeway time.Duration
+	// ReplayCache detects reused proofs, defaults to a MemoryDPoPReplayCache.
+	ReplayCache DPoPReplayCache
+	// RequestURL returns the URL the request was sent to, it is compared with the proof's "htu" claim.
+	// defaults to the scheme, host and path of the request, override it when behind a reverse proxy.
+	RequestURL func(r *http.Request) string
+}
+
+func mergeDPoPOpts(opts ...*DPoPOpts) *DPoPOpts {
+	opt := DPoPOpts{
+		Algorithms:  []string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512", "EdDSA"},
+		ProofMaxAge: time.Minute,
+		Leeway:      5 * time.Second,
+		RequestURL:  requestURL,
+	}
+
+	for _, o := range opts {
+		if o == nil {
+			continue
+		}
+		if o.Required {
+			opt.Required = o.Required
+		}
+		if o.Algorithms != nil {
+			opt.Algorithms = o.Algorithms
+		}
+		if o.ProofMaxAge != 0 {
+			opt.ProofMaxAge = o.ProofMaxAge
+		}
+		if o.Leeway != 0 {
+			opt.Leeway = o.Leeway
+		}
+		if o.ReplayCache != nil {
+			opt.ReplayCache = o.ReplayCache
+		}
+		if o.RequestURL != nil {
+			opt.RequestURL = o.RequestURL
+		}
+	}
+
+	if opt.ReplayCache == nil {
+		opt.ReplayCache = NewMemoryDPoPReplayCache()
+	}
+
+	return &opt
+}
+
+// DPoPReplayCache detects proofs that are used more than once.
+// implementations must be safe for concurrent use.
+type DPoPReplayCache interface {
+	// Use records the id of a proof until expiresAt, it returns false if id was already used.
+	Use(ctx context.Context, id string, expiresAt time.Time) (bool, error)
+}
+
+// MemoryDPoPReplayCache is an in-memory DPoPReplayCache.
+type MemoryDPoPReplayCache struct {
+	mu        sync.Mutex
+	ids       map[string]time.Time
+	lastSweep time.Time
+}
+
+// NewMemoryDPoPReplayCache returns a new MemoryDPoPReplayCache.
+func NewMemoryDPoPReplayCache() *MemoryDPoPReplayCache {
+	return &MemoryDPoPReplayCache{ids: map[string]time.Time{}, lastSweep: time.Now()}
+}
+
+// Use implements DPoPReplayCache.
+func (c *MemoryDPoPReplayCache) Use(_ context.Context, id string, expiresAt time.Time) (bool, error) {
+	now := time.Now()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if now.Sub(c.lastSweep) > time.Minute {
+		for k, exp := range c.ids {
+			if !now.Before(exp) {
+				delete(c.ids, k)
+			}
+		}
+		c.lastSweep = now
+	}
+
+	if exp, ok := c.ids[id]; ok && now.Before(exp) {
+		return false, nil
+	}
+	c.ids[id] = expiresAt
+
+	return true, nil
+}
+
+// dpopVerifier verifies DPoP proofs and the binding of tokens to them.
+type dpopVerifier struct {
+	opts DPoPOpts
+}
+
+func newDPoPVerifier(opts *DPoPOpts) *dpopVerifier {
+	if opts == nil {
+		return nil
+	}
+	return &dpopVerifier{opts: *mergeDPoPOpts(opts)}
+}
+
+// verify verifies that t, sent by r, is used by the holder of the key it is bound to.
+func (d *dpopVerifier) verify(r *http.Request, t *jwt.Token) error {
+	mc, err := rawClaims(t)
+	if err != nil {
+		return newValidationError(ReasonClaims, "", err)
+	}
+
+	var jkt string
+	if cnf, ok := mc["cnf"].(map[string]interface{}); ok {
+		jkt, _ = cnf["jkt"].(string)
+	}
+
+	if scheme := authorizationScheme(r); !strings.EqualFold(scheme, DPoPPrefix) {
+		if jkt != "" {
+			return newValidationError(ReasonDPoPBinding, "cnf", fmt.Errorf("DPoP bound token was sent with the '%s' scheme", scheme))
+		}
+		if d.opts.Required {
+			return newValidationError(ReasonDPoPBinding, "cnf", fmt.Errorf("token is not DPoP bound"))
+		}
+		return nil
+	}
+
+	tp, err := d.verifyProof(r, t.Raw)
+	if err != nil {
+		return newValidationError(ReasonDPoPProof, "", err)
+	}
+
+	if jkt == "" || jkt != tp {
+		return newValidationError(ReasonDPoPBinding, "cnf", fmt.Errorf("token is not bound to the key of the DPoP proof"))
+	}
+
+	return nil
+}
+
+// verifyProof verifies the DPoP proof of r, sent along with the access token, and returns the thumbprint of its key.
+func (d *dpopVerifier) verifyProof(r *http.Request, token string) (string, error) {
+	proofs := r.Header.Values(DPoPHeaderKey)
+	if len(proofs) != 1 {
+		return "", fmt.Errorf("expected a single DPoP proof but got %d", len(proofs))
+	}
+
+	var jwk *jwks.JSONWebKey
+	p := &jwt.Parser{ValidMethods: d.opts.Algorithms, SkipClaimsValidation: true}
+	pt, err := p.Parse(proofs[0], func(t *jwt.Token) (interface{}, error) {
+		if typ, _ := t.Header["typ"].(string); typ != DPoPProofType {
+			return nil, fmt.Errorf("invalid proof type '%s'", typ)
+		}
+		raw, ok := t.Header["jwk"].(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("proof is missing a jwk header")
+		}
+		if _, ok := raw["d"]; ok {
+			return nil, fmt.Errorf("proof jwk must be a public key")
+		}
+		b, err := json.Marshal(raw)
+		if err != nil {
+			return nil, err
+		}
+		if jwk, err = jwks.ParseKey(b); err != nil {
+			return nil, fmt.Errorf("invalid proof jwk: %w", err)
+		}
+		if err := verifyKeyType(t.Method.Alg(), jwk.Key); err != nil || jwk.Kty == jwks.KeyTypeOct {
+			return nil, fmt.Errorf("proof jwk cannot be used with algorithm '%s'", t.Method.Alg())
+		}
+		return jwk.Key, nil
+	})
+	if err != nil {
+		return "", fmt.Errorf("invalid proof: %w", err)
+	}
+
+	mc := pt.Claims.(jwt.MapClaims)
+	jti, _ := mc["jti"].(string)
+	if jti == "" {
+		return "", fmt.Errorf("proof is missing the jti claim")
+	}
+	if htm, _ := mc["htm"].(string); htm != r.Method {
+		return "", fmt.Errorf("proof htm '%s' does not match the request method", htm)
+	}
+	if htu, _ := mc["htu"].(string); !sameURL(htu, d.opts.RequestURL(r)) {
+		return "", fmt.Errorf("proof htu '%s' does not match the request url", htu)
+	}
+
+	now := time.Now()
+	iat, ok := claimsx.NumericDate(mc["iat"])
+	if !ok {
+		return "", fmt.Errorf("proof is missing the iat claim")
+	}
+	if iat.After(now.Add(d.opts.Leeway)) || iat.Before(now.Add(-d.opts.ProofMaxAge-d.opts.Leeway)) {
+		return "", fmt.Errorf("proof iat is not within the acceptable window")
+	}
+
+	sum := sha256.Sum256([]byte(token))
+	if ath, _ := mc["ath"].(string); ath != base64.RawURLEncoding.EncodeToString(sum[:]) {
+		return "", fmt.Errorf("proof ath does not match the access token")
+	}
+
+	tp, err := jwk.Thumbprint()
+	if err != nil {
+		return "", err
+	}
+
+	fresh, err := d.opts.ReplayCache.Use(r.Context(), tp+":"+jti, iat.Add(d.opts.ProofMaxAge+d.opts.Leeway))
+	if err != nil {
+		return "", fmt.Errorf("error checking proof replay: %w", err)
+	}
+	if !fresh {
+		return "", fmt.Errorf("proof was already used")
+	}
+
+	return tp, nil
+}
+
+// requestURL returns the scheme, host and path of r
+func requestURL(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host + r.URL.EscapedPath()
+}
+
+// sameURL compares the "htu" claim with the request url, ignoring query and fragment (RFC 9449 section 4.3).
+func sameURL(htu, u string) bool {
+	a, err := url.Parse(htu)
+	if err != nil {
+		return false
+	}
+	b, err := url.Parse(u)
+	if err != nil {
+		return false
+	}
+
+	return strings.EqualFold(a.Scheme, b.Scheme) && strings.EqualFold(a.Host, b.Host) && a.EscapedPath() == b.EscapedPath()
+}
@@ -0,0 +1,233 @@
+package jwtmw
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/crossid/crossid-go/pkg/jwks"
+	"github.com/crossid/crossid-go/pkg/x/testx"
+	"github.com/golang-jwt/jwt/v4"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+var dpopKey, _ = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+
+func dpopJWK(k *ecdsa.PublicKey) map[string]interface{} {
+	b64 := base64.RawURLEncoding.EncodeToString
+	return map[string]interface{}{
+		"kty": jwks.KeyTypeEC,
+		"crv": "P-256",
+		"x":   b64(k.X.FillBytes(make([]byte, 32))),
+		"y":   b64(k.Y.FillBytes(make([]byte, 32))),
+	}
+}
+
+func dpopThumbprint(t *testing.T, k *ecdsa.PublicKey) string {
+	b, err := json.Marshal(dpopJWK(k))
+	testx.AssertNoError(t, err)
+	jwk, err := jwks.ParseKey(b)
+	testx.AssertNoError(t, err)
+	tp, err := jwk.Thumbprint()
+	testx.AssertNoError(t, err)
+	return tp
+}
+
+// signDPoPProof returns a proof of token, claims override the defaults.
+func signDPoPProof(t *testing.T, k *ecdsa.PrivateKey, token string, claims jwt.MapClaims) string {
+	sum := sha256.Sum256([]byte(token))
+	c := jwt.MapClaims{
+		"jti": fmt.Sprintf("%d", time.Now().UnixNano()),
+		"htm": http.MethodGet,
+		"htu": "http://api.example.com/orders",
+		"iat": time.Now().Unix(),
+		"ath": base64.RawURLEncoding.EncodeToString(sum[:]),
+	}
+	for k, v := range claims {
+		c[k] = v
+	}
+
+	tok := jwt.NewWithClaims(jwt.SigningMethodES256, c)
+	tok.Header["typ"] = DPoPProofType
+	tok.Header["jwk"] = dpopJWK(&k.PublicKey)
+	raw, err := tok.SignedString(k)
+	testx.AssertNoError(t, err)
+	return raw
+}
+
+func TestJWT_ValidateDPoP(t *testing.T) {
+	otherKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	testx.AssertNoError(t, err)
+
+	bound := strings.TrimPrefix(signHS256JWT(t, jwt.MapClaims{"cnf": map[string]string{"jkt": dpopThumbprint(t, &dpopKey.PublicKey)}}), "Bearer ")
+	unbound := strings.TrimPrefix(signHS256JWT(t, jwt.MapClaims{}), "Bearer ")
+	replayed := signDPoPProof(t, dpopKey, bound, nil)
+
+	for k, tc := range []struct {
+		name   string
+		opts   *DPoPOpts
+		auth   string
+		proofs []string
+		reason Reason
+	}{
+		{
+			name:   "valid proof",
+			auth:   "DPoP " + bound,
+			proofs: []string{signDPoPProof(t, dpopKey, bound, nil)},
+		},
+		{
+			name:   "first use of a proof",
+			auth:   "DPoP " + bound,
+			proofs: []string{replayed},
+		},
+		{
+			name:   "replayed proof",
+			auth:   "DPoP " + bound,
+			proofs: []string{replayed},
+			reason: ReasonDPoPProof,
+		},
+		{
+			name:   "missing proof",
+			auth:   "DPoP " + bound,
+			reason: ReasonDPoPProof,
+		},
+		{
+			name:   "multiple proofs",
+			auth:   "DPoP " + bound,
+			proofs: []string{signDPoPProof(t, dpopKey, bound, nil), signDPoPProof(t, dpopKey, bound, nil)},
+			reason: ReasonDPoPProof,
+		},
+		{
+			name:   "method mismatch",
+			auth:   "DPoP " + bound,
+			proofs: []string{signDPoPProof(t, dpopKey, bound, jwt.MapClaims{"htm": http.MethodPost})},
+			reason: ReasonDPoPProof,
+		},
+		{
+			name:   "url mismatch",
+			auth:   "DPoP " + bound,
+			proofs: []string{signDPoPProof(t, dpopKey, bound, jwt.MapClaims{"htu": "http://api.example.com/users"})},
+			reason: ReasonDPoPProof,
+		},
+		{
+			name:   "url query is ignored",
+			auth:   "DPoP " + bound,
+			proofs: []string{signDPoPProof(t, dpopKey, bound, jwt.MapClaims{"htu": "HTTP://API.example.com/orders?page=2"})},
+		},
+		{
+			name:   "stale proof",
+			auth:   "DPoP " + bound,
+			proofs: []string{signDPoPProof(t, dpopKey, bound, jwt.MapClaims{"iat": time.Now().Add(-time.Hour).Unix()})},
+			reason: ReasonDPoPProof,
+		},
+		{
+			name:   "access token hash mismatch",
+			auth:   "DPoP " + bound,
+			proofs: []string{signDPoPProof(t, dpopKey, unbound, nil)},
+			reason: ReasonDPoPProof,
+		},
+		{
+			name:   "proof signed by another key",
+			auth:   "DPoP " + bound,
+			proofs: []string{signDPoPProof(t, otherKey, bound, nil)},
+			reason: ReasonDPoPBinding,
+		},
+		{
+			name:   "unbound token with the DPoP scheme",
+			auth:   "DPoP " + unbound,
+			proofs: []string{signDPoPProof(t, dpopKey, unbound, nil)},
+			reason: ReasonDPoPBinding,
+		},
+		{
+			name:   "bound token with the Bearer scheme",
+			auth:   "Bearer " + bound,
+			reason: ReasonDPoPBinding,
+		},
+		{
+			name: "unbound token with the Bearer scheme",
+			auth: "Bearer " + unbound,
+		},
+		{
+			name:   "unbound token with the Bearer scheme when required",
+			opts:   &DPoPOpts{Required: true},
+			auth:   "Bearer " + unbound,
+			reason: ReasonDPoPBinding,
+		},
+	} {
+		t.Run(fmt.Sprintf("case=%d", k), func(t *testing.T) {
+			r, err := http.NewRequest(http.MethodGet, "http://api.example.com/orders?page=1", nil)
+			testx.AssertNoError(t, err)
+			r.Header.Set(BearerHeaderKey, tc.auth)
+			for _, p := range tc.proofs {
+				r.Header.Add(DPoPHeaderKey, p)
+			}
+
+			opts := tc.opts
+			if opts == nil {
+				opts = &DPoPOpts{}
+			}
+			opts.ReplayCache = dpopReplayCache
+			_, err = NewJWT(&JwtMiddlewareOpts{KeyFunc: validKeyFuncHS256, DPoP: opts}).Validate(r)
+			if tc.reason == "" {
+				testx.AssertNoError(t, err)
+				return
+			}
+
+			var ve *ValidationError
+			testx.AssertTrue(t, errors.Is(err, ErrInvalidToken), "expected error to be ErrInvalidToken")
+			testx.AssertTrue(t, errors.As(err, &ve) && ve.Reason == tc.reason, fmt.Sprintf("expected reason %s but got %v", tc.reason, err))
+		})
+	}
+}
+
+// dpopReplayCache is shared by all cases so replays across middlewares are detected.
+var dpopReplayCache = NewMemoryDPoPReplayCache()
+
+func TestBearerErrorWriter_DPoPProof(t *testing.T) {
+	code, status := bearerErrorCode(newValidationError(ReasonDPoPProof, "", nil))
+	testx.AssertTrue(t, code == BearerErrorInvalidDPoPProof && status == http.StatusUnauthorized, "unexpected error code")
+
+	bound := strings.TrimPrefix(signHS256JWT(t, jwt.MapClaims{"cnf": map[string]string{"jkt": dpopThumbprint(t, &dpopKey.PublicKey)}}), "Bearer ")
+	h := NewJWT(&JwtMiddlewareOpts{
+		KeyFunc:     validKeyFuncHS256,
+		DPoP:        &DPoPOpts{Algorithms: []string{"ES256", "PS256"}, ReplayCache: dpopReplayCache},
+		ErrorWriter: NewBearerErrorWriter(&BearerErrorWriterOpts{DPoPAlgorithms: []string{"ES256", "PS256"}}),
+	}).Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	for k, tc := range []struct {
+		auth   string
+		header string
+	}{
+		// a request without a proof
+		{
+			auth:   "DPoP " + bound,
+			header: `DPoP error="invalid_dpop_proof", error_description="invalid token: invalid_dpop_proof", algs="ES256 PS256"`,
+		},
+		// a request of the DPoP scheme is challenged by the DPoP scheme
+		{
+			auth:   "DPoP invalid",
+			header: `DPoP error="invalid_token", error_description="invalid token: malformed", algs="ES256 PS256"`,
+		},
+		{
+			auth:   "Bearer invalid",
+			header: `Bearer error="invalid_token", error_description="invalid token: malformed"`,
+		},
+	} {
+		t.Run(fmt.Sprintf("case=%d", k), func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "http://api.example.com/orders", nil)
+			r.Header.Set(BearerHeaderKey, tc.auth)
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+			testx.AssertTrue(t, w.Code == http.StatusUnauthorized, fmt.Sprintf("expected code 401 but got %d", w.Code))
+			testx.AssertTrue(t, w.Header().Get("WWW-Authenticate") == tc.header, fmt.Sprintf("unexpected header: %s", w.Header().Get("WWW-Authenticate")))
+		})
+	}
+}
@@ -1,6 +1,11 @@
 package jwtmw
 
-import "fmt"
+import (
+	"errors"
+	"fmt"
+	"github.com/golang-jwt/jwt/v4"
+	"strings"
+)
 
 var (
 	ErrExtractingToken  = fmt.Errorf("error extracting token")
@@ -8,4 +13,141 @@ var (
 	ErrInvalidToken     = fmt.Errorf("invalid token")
 	ErrExtractingClaims = fmt.Errorf("error extracting claims")
 	ErrMissingClaim     = fmt.Errorf("insufficient privileges")
+	ErrUnknownIssuer    = fmt.Errorf("unknown issuer")
+	// ErrAuthorizationFailed is returned when an access could not be decided, e.g., the decision point is unavailable,
+	// as opposed to ErrMissingClaim which is returned when an access is denied.
+	ErrAuthorizationFailed = fmt.Errorf("authorization failed")
 )
+
+// Reason is a machine-readable code of why a token was rejected.
+type Reason string
+
+const (
+	ReasonMalformed          Reason = "malformed"
+	ReasonAlgorithm          Reason = "invalid_algorithm"
+	ReasonUnknownKey         Reason = "unknown_key"
+	ReasonSignature          Reason = "invalid_signature"
+	ReasonExpired            Reason = "expired"
+	ReasonNotValidYet        Reason = "not_valid_yet"
+	ReasonIssuedAt           Reason = "invalid_issued_at"
+	ReasonMaxAge             Reason = "max_age_exceeded"
+	ReasonIssuer             Reason = "invalid_issuer"
+	ReasonUnknownIssuer      Reason = "unknown_issuer"
+	ReasonAudience           Reason = "invalid_audience"
+	ReasonAuthorizedParty    Reason = "invalid_authorized_party"
+	ReasonClaims             Reason = "invalid_claims"
+	ReasonCustom             Reason = "custom_validation"
+	ReasonInsufficientScope  Reason = "insufficient_scope"
+	ReasonInsufficientClaims Reason = "insufficient_claims"
+	ReasonAccessDenied       Reason = "access_denied"
+	ReasonAuthorization      Reason = "authorization_failed"
+	ReasonInactive           Reason = "inactive"
+	ReasonIntrospection      Reason = "introspection_failed"
+	ReasonRevoked            Reason = "revoked"
+	ReasonRevocationCheck    Reason = "revocation_check_failed"
+	ReasonDPoPProof          Reason = "invalid_dpop_proof"
+	ReasonDPoPBinding        Reason = "invalid_dpop_binding"
+	ReasonCertificateBinding Reason = "invalid_certificate_binding"
+)
+
+// ValidationError describes why a token was rejected.
+// It matches its sentinel error (e.g., ErrInvalidToken) when tested by errors.Is
+// and unwraps to the underlying cause.
+type ValidationError struct {
+	// Reason is a machine-readable code of the failure.
+	Reason Reason
+	// Claim is the offending claim (e.g., "exp"), if any.
+	Claim string
+	// Err is the underlying error.
+	Err error
+	// sentinel is one of the package errors, ErrInvalidToken by default.
+	sentinel error
+}
+
+func newValidationError(reason Reason, claim string, err error) *ValidationError {
+	return &ValidationError{Reason: reason, Claim: claim, Err: err, sentinel: ErrInvalidToken}
+}
+
+// Sentinel returns the package error e is classified as (e.g., ErrInvalidToken).
+func (e *ValidationError) Sentinel() error {
+	if e.sentinel == nil {
+		return ErrInvalidToken
+	}
+	return e.sentinel
+}
+
+// Error returns the sentinel message followed by the reason, the underlying error is
+// omitted as it may contain details that should not be exposed to clients.
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Sentinel(), e.Reason)
+}
+
+func (e *ValidationError) Is(target error) bool {
+	return target == e.Sentinel()
+}
+
+func (e *ValidationError) Unwrap() error {
+	return e.Err
+}
+
+// ScopeError is the cause of an insufficient_scope ValidationError.
+type ScopeError struct {
+	// Scopes are the scopes required to access the resource,
+	// empty if no single set of scopes is required, e.g., by a scope expression of alternatives.
+	Scopes []string
+	// Err is the error returned by the scopes checker.
+	Err error
+}
+
+func (e *ScopeError) Error() string {
+	msg := "required scopes are not granted"
+	if len(e.Scopes) > 0 {
+		msg = fmt.Sprintf("required scopes '%s' are not granted", strings.Join(e.Scopes, " "))
+	}
+	if e.Err != nil {
+		msg += ": " + e.Err.Error()
+	}
+	return msg
+}
+
+func (e *ScopeError) Is(target error) bool {
+	return target == ErrMissingClaim
+}
+
+func (e *ScopeError) Unwrap() error {
+	return e.Err
+}
+
+// asValidationError classifies an error returned while parsing or validating a token.
+func asValidationError(err error) *ValidationError {
+	var ve *ValidationError
+	if errors.As(err, &ve) {
+		return ve
+	}
+
+	var jve *jwt.ValidationError
+	if !errors.As(err, &jve) {
+		return newValidationError(ReasonClaims, "", err)
+	}
+
+	switch {
+	case jve.Errors&jwt.ValidationErrorMalformed != 0:
+		return newValidationError(ReasonMalformed, "", err)
+	case jve.Errors&jwt.ValidationErrorUnverifiable != 0:
+		return newValidationError(ReasonUnknownKey, "", err)
+	case jve.Errors&jwt.ValidationErrorSignatureInvalid != 0:
+		return newValidationError(ReasonSignature, "", err)
+	case jve.Errors&jwt.ValidationErrorExpired != 0:
+		return newValidationError(ReasonExpired, "exp", err)
+	case jve.Errors&jwt.ValidationErrorNotValidYet != 0:
+		return newValidationError(ReasonNotValidYet, "nbf", err)
+	case jve.Errors&jwt.ValidationErrorIssuedAt != 0:
+		return newValidationError(ReasonIssuedAt, "iat", err)
+	case jve.Errors&jwt.ValidationErrorIssuer != 0:
+		return newValidationError(ReasonIssuer, "iss", err)
+	case jve.Errors&jwt.ValidationErrorAudience != 0:
+		return newValidationError(ReasonAudience, "aud", err)
+	}
+
+	return newValidationError(ReasonClaims, "", err)
+}
@@ -0,0 +1,131 @@
+package jwtmw
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/crossid/crossid-go/pkg/x/testx"
+	"github.com/golang-jwt/jwt/v4"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestJWT_ValidateReasons(t *testing.T) {
+	errCustom := fmt.Errorf("custom")
+	for k, tc := range []struct {
+		name   string
+		opts   *JwtMiddlewareOpts
+		jwt    string
+		reason Reason
+		claim  string
+		cause  error
+	}{
+		{
+			name:   "malformed",
+			jwt:    "Bearer not.a.jwt",
+			reason: ReasonMalformed,
+		},
+		{
+			name:   "expired",
+			jwt:    signHS256JWT(t, jwt.MapClaims{"exp": time.Now().Add(-time.Minute).Unix()}),
+			reason: ReasonExpired,
+			claim:  "exp",
+			cause:  jwt.ErrTokenExpired,
+		},
+		{
+			name:   "not valid yet",
+			jwt:    signHS256JWT(t, jwt.MapClaims{"nbf": time.Now().Add(time.Minute).Unix()}),
+			reason: ReasonNotValidYet,
+			claim:  "nbf",
+		},
+		{
+			name:   "bad signature",
+			jwt:    signHS256JWT(t, jwt.MapClaims{}) + "x",
+			reason: ReasonSignature,
+		},
+		{
+			name:   "algorithm not allowed",
+			opts:   &JwtMiddlewareOpts{Algorithms: []string{"RS256"}},
+			jwt:    signHS256JWT(t, jwt.MapClaims{}),
+			reason: ReasonAlgorithm,
+		},
+		{
+			name: "unknown key",
+			opts: &JwtMiddlewareOpts{KeyFunc: func(ctx context.Context, t *jwt.Token) (interface{}, error) {
+				return nil, errCustom
+			}},
+			jwt:    signHS256JWT(t, jwt.MapClaims{}),
+			reason: ReasonUnknownKey,
+			cause:  errCustom,
+		},
+		{
+			name:   "issuer mismatch",
+			opts:   &JwtMiddlewareOpts{Issuers: []string{"crossid.io"}},
+			jwt:    signHS256JWT(t, jwt.MapClaims{"iss": "evil.io"}),
+			reason: ReasonIssuer,
+			claim:  "iss",
+		},
+		{
+			name:   "audience mismatch",
+			opts:   &JwtMiddlewareOpts{Audiences: []string{"api"}},
+			jwt:    signHS256JWT(t, jwt.MapClaims{"aud": "web"}),
+			reason: ReasonAudience,
+			claim:  "aud",
+		},
+		{
+			name:   "invalid custom claims",
+			opts:   &JwtMiddlewareOpts{Claims: &fooClaim{}},
+			jwt:    signHS256JWT(t, jwt.MapClaims{}),
+			reason: ReasonClaims,
+		},
+		{
+			name: "custom validation",
+			opts: &JwtMiddlewareOpts{Validate: func(r *http.Request, t *jwt.Token, c jwt.Claims) error {
+				return errCustom
+			}},
+			jwt:    signHS256JWT(t, jwt.MapClaims{}),
+			reason: ReasonCustom,
+			cause:  errCustom,
+		},
+	} {
+		t.Run(fmt.Sprintf("case=%d", k), func(t *testing.T) {
+			r, err := http.NewRequest(http.MethodGet, "/", nil)
+			testx.AssertNoError(t, err)
+			r.Header.Set(BearerHeaderKey, tc.jwt)
+
+			_, err = NewJWT(&JwtMiddlewareOpts{KeyFunc: validKeyFuncHS256}, tc.opts).Validate(r)
+			testx.AssertTrue(t, errors.Is(err, ErrInvalidToken), "expected error to be ErrInvalidToken")
+
+			var ve *ValidationError
+			testx.AssertTrue(t, errors.As(err, &ve), "expected a *ValidationError")
+			testx.AssertTrue(t, ve.Reason == tc.reason, fmt.Sprintf("expected reason %s but got %s", tc.reason, ve.Reason))
+			testx.AssertTrue(t, ve.Claim == tc.claim, fmt.Sprintf("expected claim %s but got %s", tc.claim, ve.Claim))
+			if tc.cause != nil {
+				testx.AssertTrue(t, errors.Is(err, tc.cause), fmt.Sprintf("expected cause %s but got %s", tc.cause, ve.Err))
+			}
+		})
+	}
+}
+
+func TestWithScopesCustom_ValidationError(t *testing.T) {
+	var got error
+	smw := WithScopesCustom([]string{"foo", "bar"}, WithErrorWriter(func(w http.ResponseWriter, r *http.Request, err error) {
+		got = err
+		w.WriteHeader(http.StatusForbidden)
+	}))
+
+	r, err := http.NewRequest(http.MethodGet, "/", nil)
+	testx.AssertNoError(t, err)
+	r.Header.Set(BearerHeaderKey, signHS256JWT(t, jwt.MapClaims{"scp": []string{"foo"}}))
+	NewJWT(&JwtMiddlewareOpts{KeyFunc: validKeyFuncHS256}).
+		Handler(smw(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))).
+		ServeHTTP(httptest.NewRecorder(), r)
+
+	testx.AssertTrue(t, errors.Is(got, ErrMissingClaim), "expected error to be ErrMissingClaim")
+	var ve *ValidationError
+	testx.AssertTrue(t, errors.As(got, &ve) && ve.Reason == ReasonInsufficientScope && ve.Claim == ScopesClaim, "unexpected validation error")
+	var se *ScopeError
+	testx.AssertTrue(t, errors.As(got, &se) && len(se.Scopes) == 2, "expected a *ScopeError with the required scopes")
+}
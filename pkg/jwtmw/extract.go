@@ -22,7 +22,7 @@ const (
 
 // BearerTokenFromRequest extracts a bearer token from r
 // This is a naive implementation that tries to extract a token from request header.
-// More advanced extractors may try to extract tokens from forms, cookies, etc.
+// tokens sent by forms, cookies, etc. are extracted by the extractors of extractors.go, which may be combined by FirstOf or OneOf.
 func BearerTokenFromRequest(r *http.Request) (string, error) {
 	if r.Header.Get(BearerHeaderKey) == "" {
 		return "", nil
@@ -36,3 +36,32 @@ func BearerTokenFromRequest(r *http.Request) (string, error) {
 
 	return "", fmt.Errorf("missing or invalid token")
 }
+
+const (
+	// DPoPPrefix is the authorization scheme of DPoP bound tokens (RFC 9449)
+	DPoPPrefix = "DPoP"
+	// DPoPHeaderKey is the http header name of the DPoP proof
+	DPoPHeaderKey = "DPoP"
+)
+
+// DPoPTokenFromRequest extracts a token sent with either the Bearer or the DPoP scheme from r.
+// It is the default extractor when DPoP validation is enabled, see JwtMiddlewareOpts.DPoP.
+func DPoPTokenFromRequest(r *http.Request) (string, error) {
+	if r.Header.Get(BearerHeaderKey) == "" {
+		return "", nil
+	}
+
+	p := strings.Split(r.Header.Get(BearerHeaderKey), " ")
+
+	if len(p) == 2 && (strings.EqualFold(p[0], BearerPrefix) || strings.EqualFold(p[0], DPoPPrefix)) {
+		return p[1], nil
+	}
+
+	return "", fmt.Errorf("missing or invalid token")
+}
+
+// authorizationScheme returns the scheme of the authorization header of r (e.g., "Bearer")
+func authorizationScheme(r *http.Request) string {
+	p := strings.SplitN(r.Header.Get(BearerHeaderKey), " ", 2)
+	return p[0]
+}
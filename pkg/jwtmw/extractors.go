@@ -0,0 +1,164 @@
+package jwtmw
+
+import (
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+const (
+	// AccessTokenParam is the form and query parameter name of the token (RFC 6750 sections 2.2 and 2.3)
+	AccessTokenParam = "access_token"
+	// WebSocketProtocolHeaderKey is the http header name of the WebSocket subprotocols (RFC 6455 section 11.3.4)
+	WebSocketProtocolHeaderKey = "Sec-WebSocket-Protocol"
+)
+
+// ErrMultipleTokens is returned by OneOf when a request presents more than a single token.
+var ErrMultipleTokens = errors.New("token is presented by more than one method")
+
+// HeaderTokenFromRequest returns an extractor of a token sent in the header key (e.g., "X-Access-Token").
+// if prefix is set (e.g., "Bearer") the value must be of the form "<prefix> <token>", otherwise the whole value is the token.
+func HeaderTokenFromRequest(key, prefix string) tokenFromRequest {
+	return func(r *http.Request) (string, error) {
+		v := r.Header.Get(key)
+		if v == "" || prefix == "" {
+			return v, nil
+		}
+
+		p := strings.Split(v, " ")
+		if len(p) == 2 && strings.EqualFold(p[0], prefix) {
+			return p[1], nil
+		}
+
+		return "", fmt.Errorf("missing or invalid token in header %s", key)
+	}
+}
+
+// CookieTokenFromRequest returns an extractor of a token sent in the cookie of the given name.
+// note that cookies are sent by browsers automatically, endpoints that accept them must be protected against CSRF.
+func CookieTokenFromRequest(name string) tokenFromRequest {
+	return func(r *http.Request) (string, error) {
+		c, err := r.Cookie(name)
+		if errors.Is(err, http.ErrNoCookie) {
+			return "", nil
+		}
+		if err != nil {
+			return "", err
+		}
+
+		return c.Value, nil
+	}
+}
+
+// QueryTokenFromRequest returns an extractor of a token sent in the URI query parameter (e.g., AccessTokenParam).
+// per RFC 6750 section 2.3 it should be used only when the token cannot be sent otherwise,
+// as URIs are likely to be logged.
+func QueryTokenFromRequest(param string) tokenFromRequest {
+	return func(r *http.Request) (string, error) {
+		v, ok := r.URL.Query()[param]
+		if !ok {
+			return "", nil
+		}
+		if len(v) != 1 {
+			return "", fmt.Errorf("query parameter %s must be set once", param)
+		}
+
+		return v[0], nil
+	}
+}
+
+// FormTokenFromRequest returns an extractor of a token sent in the form-encoded body parameter (e.g., AccessTokenParam).
+// per RFC 6750 section 2.2 only single-part application/x-www-form-urlencoded bodies of methods other than GET are considered.
+// the body is consumed, the parsed form remains available to next handlers by r.PostForm.
+func FormTokenFromRequest(param string) tokenFromRequest {
+	return func(r *http.Request) (string, error) {
+		if r.Method == http.MethodGet || r.Body == nil {
+			return "", nil
+		}
+		if ct, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); ct != "application/x-www-form-urlencoded" {
+			return "", nil
+		}
+
+		if err := r.ParseForm(); err != nil {
+			return "", err
+		}
+
+		v, ok := r.PostForm[param]
+		if !ok {
+			return "", nil
+		}
+		if len(v) != 1 {
+			return "", fmt.Errorf("form parameter %s must be set once", param)
+		}
+
+		return v[0], nil
+	}
+}
+
+// WebSocketTokenFromRequest returns an extractor of a token sent as a WebSocket subprotocol of the form "<prefix><token>"
+// (e.g., prefix "access_token." and subprotocol "access_token.eyJhbGciOi..."), as browsers cannot set headers of WebSocket handshakes.
+// note that the server must then select one of the other requested subprotocols, never the one carrying the token.
+func WebSocketTokenFromRequest(prefix string) tokenFromRequest {
+	if prefix == "" {
+		panic("prefix must be set.")
+	}
+
+	return func(r *http.Request) (string, error) {
+		var tok string
+		for _, h := range r.Header.Values(WebSocketProtocolHeaderKey) {
+			for _, p := range strings.Split(h, ",") {
+				p = strings.TrimSpace(p)
+				if !strings.HasPrefix(p, prefix) {
+					continue
+				}
+				if tok != "" {
+					return "", fmt.Errorf("more than one subprotocol is prefixed with %s", prefix)
+				}
+				tok = strings.TrimPrefix(p, prefix)
+			}
+		}
+
+		return tok, nil
+	}
+}
+
+// FirstOf returns an extractor that tries extractors in order and returns the first token found.
+// an error of an extractor is returned as is, rather trying the next extractors.
+func FirstOf(extractors ...tokenFromRequest) tokenFromRequest {
+	return func(r *http.Request) (string, error) {
+		for _, e := range extractors {
+			tok, err := e(r)
+			if err != nil || tok != "" {
+				return tok, err
+			}
+		}
+
+		return "", nil
+	}
+}
+
+// OneOf returns an extractor that tries all extractors and returns the token found,
+// a request that presents a token by more than one extractor is rejected with ErrMultipleTokens,
+// as clients must not use more than one method to transmit the token (RFC 6750 section 2).
+func OneOf(extractors ...tokenFromRequest) tokenFromRequest {
+	return func(r *http.Request) (string, error) {
+		var found string
+		for _, e := range extractors {
+			tok, err := e(r)
+			if err != nil {
+				return "", err
+			}
+			if tok == "" {
+				continue
+			}
+			if found != "" {
+				return "", ErrMultipleTokens
+			}
+			found = tok
+		}
+
+		return found, nil
+	}
+}
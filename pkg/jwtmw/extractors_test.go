@@ -0,0 +1,94 @@
+package jwtmw
+
+import (
+	"fmt"
+	"github.com/crossid/crossid-go/pkg/x/testx"
+	"github.com/golang-jwt/jwt/v4"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestExtractors(t *testing.T) {
+	form := func(body string) *http.Request {
+		r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		return r
+	}
+	withHeader := func(r *http.Request, k, v string) *http.Request {
+		r.Header.Add(k, v)
+		return r
+	}
+	withCookie := func(r *http.Request, name, v string) *http.Request {
+		r.AddCookie(&http.Cookie{Name: name, Value: v})
+		return r
+	}
+	get := func(target string) *http.Request {
+		return httptest.NewRequest(http.MethodGet, target, nil)
+	}
+
+	for k, tc := range []struct {
+		e   tokenFromRequest
+		r   *http.Request
+		tok string
+		err bool
+	}{
+		{e: HeaderTokenFromRequest("X-Access-Token", ""), r: withHeader(get("/"), "X-Access-Token", "jwt"), tok: "jwt"},
+		{e: HeaderTokenFromRequest("X-Access-Token", BearerPrefix), r: withHeader(get("/"), "X-Access-Token", "bearer jwt"), tok: "jwt"},
+		{e: HeaderTokenFromRequest("X-Access-Token", BearerPrefix), r: withHeader(get("/"), "X-Access-Token", "jwt"), err: true},
+		{e: HeaderTokenFromRequest("X-Access-Token", BearerPrefix), r: get("/")},
+		{e: CookieTokenFromRequest("at"), r: withCookie(get("/"), "at", "jwt"), tok: "jwt"},
+		{e: CookieTokenFromRequest("at"), r: withCookie(get("/"), "other", "jwt")},
+		{e: QueryTokenFromRequest(AccessTokenParam), r: get("/?access_token=jwt"), tok: "jwt"},
+		{e: QueryTokenFromRequest(AccessTokenParam), r: get("/?access_token=a&access_token=b"), err: true},
+		{e: QueryTokenFromRequest(AccessTokenParam), r: get("/")},
+		{e: FormTokenFromRequest(AccessTokenParam), r: form("access_token=jwt&foo=bar"), tok: "jwt"},
+		// the query is not part of the form body
+		{e: FormTokenFromRequest(AccessTokenParam), r: withHeader(httptest.NewRequest(http.MethodPost, "/?access_token=jwt", nil), "Content-Type", "application/x-www-form-urlencoded")},
+		{e: FormTokenFromRequest(AccessTokenParam), r: withHeader(httptest.NewRequest(http.MethodPost, "/", strings.NewReader("access_token=jwt")), "Content-Type", "multipart/form-data")},
+		{e: WebSocketTokenFromRequest("access_token."), r: withHeader(get("/"), WebSocketProtocolHeaderKey, "graphql-ws, access_token.jwt"), tok: "jwt"},
+		{e: WebSocketTokenFromRequest("access_token."), r: withHeader(get("/"), WebSocketProtocolHeaderKey, "access_token.a, access_token.b"), err: true},
+		{e: WebSocketTokenFromRequest("access_token."), r: withHeader(get("/"), WebSocketProtocolHeaderKey, "graphql-ws")},
+		// precedence is the order of the extractors
+		{
+			e:   FirstOf(BearerTokenFromRequest, CookieTokenFromRequest("at")),
+			r:   withCookie(withHeader(get("/"), BearerHeaderKey, "Bearer header"), "at", "cookie"),
+			tok: "header",
+		},
+		{e: FirstOf(BearerTokenFromRequest, CookieTokenFromRequest("at")), r: withCookie(get("/"), "at", "cookie"), tok: "cookie"},
+		{e: FirstOf(BearerTokenFromRequest, CookieTokenFromRequest("at")), r: withCookie(withHeader(get("/"), BearerHeaderKey, "invalid"), "at", "cookie"), err: true},
+		{e: FirstOf(BearerTokenFromRequest, CookieTokenFromRequest("at")), r: get("/")},
+		{e: OneOf(BearerTokenFromRequest, QueryTokenFromRequest(AccessTokenParam)), r: get("/?access_token=jwt"), tok: "jwt"},
+		{e: OneOf(BearerTokenFromRequest, QueryTokenFromRequest(AccessTokenParam)), r: withHeader(get("/?access_token=jwt"), BearerHeaderKey, "Bearer jwt"), err: true},
+	} {
+		t.Run(fmt.Sprintf("case=%d", k), func(t *testing.T) {
+			tok, err := tc.e(tc.r)
+			if tc.err {
+				testx.AssertError(t, err)
+				return
+			}
+			testx.AssertNoError(t, err)
+			testx.AssertTrue(t, tok == tc.tok, fmt.Sprintf("expected token '%s' but got '%s'", tc.tok, tok))
+		})
+	}
+}
+
+func TestOneOf_Handler(t *testing.T) {
+	h := NewJWT(&JwtMiddlewareOpts{
+		KeyFunc:          validKeyFuncHS256,
+		TokenFromRequest: OneOf(BearerTokenFromRequest, QueryTokenFromRequest(AccessTokenParam)),
+		ErrorWriter:      NewBearerErrorWriter(),
+	}).Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	tok := signHS256JWT(t, jwt.MapClaims{})
+	r := httptest.NewRequest(http.MethodGet, "/?access_token="+strings.TrimPrefix(tok, BearerPrefix+" "), nil)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	testx.AssertTrue(t, w.Code == http.StatusOK, fmt.Sprintf("expected status 200 but got %d", w.Code))
+
+	r.Header.Set(BearerHeaderKey, tok)
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	testx.AssertTrue(t, w.Code == http.StatusBadRequest, fmt.Sprintf("expected status 400 but got %d", w.Code))
+}
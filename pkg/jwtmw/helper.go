@@ -2,16 +2,38 @@ package jwtmw
 
 import (
 	"context"
-	"fmt"
+	"errors"
 	"github.com/crossid/crossid-go/pkg/x/stringslice"
 )
 
 func ScopesCheckerOR(_ context.Context, required, candidates []string) error {
+	return scopesCheckerOR(nil, required, candidates)
+}
+
+func scopesCheckerAND(_ context.Context, required, candidates []string) error {
+	return scopesCheckerANDWith(nil, required, candidates)
+}
+
+// ScopesCheckerORWith returns a checker that requires any of the required scopes to be satisfied according to m.
+func ScopesCheckerORWith(m *ScopeMatcher) ScopesCheckerFunc {
+	return func(_ context.Context, required, candidates []string) error {
+		return scopesCheckerOR(m, required, candidates)
+	}
+}
+
+// ScopesCheckerANDWith returns a checker that requires all of the required scopes to be satisfied according to m.
+func ScopesCheckerANDWith(m *ScopeMatcher) ScopesCheckerFunc {
+	return func(_ context.Context, required, candidates []string) error {
+		return scopesCheckerANDWith(m, required, candidates)
+	}
+}
+
+func scopesCheckerOR(m *ScopeMatcher, required, candidates []string) error {
 	if len(required) == 0 {
 		return nil
 	}
 	for _, r := range required {
-		if stringslice.IndexOf(candidates, r) > -1 {
+		if containsScope(m, candidates, r) {
 			return nil
 		}
 	}
@@ -19,16 +41,96 @@ func ScopesCheckerOR(_ context.Context, required, candidates []string) error {
 	return ErrMissingClaim
 }
 
-func scopesCheckerAND(_ context.Context, required, candidates []string) error {
+// errScopesNotFound is returned when not all the required scopes are granted.
+var errScopesNotFound = errors.New("not found")
+
+func scopesCheckerANDWith(m *ScopeMatcher, required, candidates []string) error {
 	for _, r := range required {
-		f := false
-		if stringslice.IndexOf(candidates, r) > -1 {
-			f = true
-		}
-		if !f {
-			return fmt.Errorf("not found")
+		if !containsScope(m, candidates, r) {
+			return errScopesNotFound
 		}
 	}
 
 	return nil
 }
+
+// containsScope returns true if candidates satisfy r, exact match is used if m is nil.
+func containsScope(m *ScopeMatcher, candidates []string, r string) bool {
+	if m == nil {
+		return stringslice.IndexOf(candidates, r) > -1
+	}
+	return m.Contains(candidates, r)
+}
+
+// scopeSet is a pre-indexed set of required scopes.
+type scopeSet struct {
+	index map[string]uint
+	// full has a bit set for each of the scopes, if there are no more than 64 scopes.
+	full uint64
+}
+
+func newScopeSet(scopes []string) *scopeSet {
+	s := &scopeSet{index: make(map[string]uint, len(scopes))}
+	for _, sc := range scopes {
+		if _, ok := s.index[sc]; !ok {
+			s.index[sc] = uint(len(s.index))
+		}
+	}
+	if len(s.index) <= 64 {
+		for _, i := range s.index {
+			s.full |= 1 << i
+		}
+	}
+
+	return s
+}
+
+// all returns true if candidates contain all the scopes of s, it does not allocate.
+func (s *scopeSet) all(candidates []string) bool {
+	if len(s.index) > 64 {
+		found := 0
+		for sc := range s.index {
+			if stringslice.IndexOf(candidates, sc) > -1 {
+				found++
+			}
+		}
+		return found == len(s.index)
+	}
+
+	var seen uint64
+	for _, c := range candidates {
+		if i, ok := s.index[c]; ok {
+			seen |= 1 << i
+		}
+	}
+
+	return seen == s.full
+}
+
+// allValues returns true if candidates, as decoded from a JSON array, contain all the scopes of s.
+// elements that are not strings are ignored, it does not allocate.
+func (s *scopeSet) allValues(candidates []interface{}) bool {
+	if len(s.index) > 64 {
+		found := 0
+		for sc := range s.index {
+			for _, c := range candidates {
+				if v, ok := c.(string); ok && v == sc {
+					found++
+					break
+				}
+			}
+		}
+		return found == len(s.index)
+	}
+
+	var seen uint64
+	for _, c := range candidates {
+		if v, ok := c.(string); ok {
+			if i, ok := s.index[v]; ok {
+				seen |= 1 << i
+			}
+		}
+	}
+
+	return seen == s.full
+}
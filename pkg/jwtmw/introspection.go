@@ -0,0 +1,251 @@
+package jwtmw
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"github.com/crossid/crossid-go/pkg/x/claimsx"
+	"github.com/golang-jwt/jwt/v4"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// IntrospectorOpts describes the options of an Introspector
+type IntrospectorOpts struct {
+	// Endpoint is the token introspection endpoint (e.g., https://<tenant>.crossid.io/oauth2/introspect).
+	Endpoint string
+	// ClientID and ClientSecret authenticate the resource server against the introspection endpoint.
+	ClientID     string
+	ClientSecret string
+	// HTTPClient is used to call the endpoint, defaults to an http.Client with a 10s timeout.
+	HTTPClient *http.Client
+	// CacheTTL is the maximal duration an active token is cached, it is further bounded by the token's "exp".
+	// defaults to 1 minute, a negative value disables caching of active tokens.
+	CacheTTL time.Duration
+	// NegativeCacheTTL is the duration an inactive token is cached, defaults to 1 minute.
+	// a negative value disables caching of inactive tokens.
+	NegativeCacheTTL time.Duration
+	// MaxCacheEntries bounds the number of cached tokens, the least recently used token is evicted first, defaults to 10000.
+	MaxCacheEntries int
+}
+
+func mergeIntrospectorOpts(opts ...*IntrospectorOpts) *IntrospectorOpts {
+	opt := IntrospectorOpts{
+		HTTPClient:       &http.Client{Timeout: 10 * time.Second},
+		CacheTTL:         time.Minute,
+		NegativeCacheTTL: time.Minute,
+		MaxCacheEntries:  10000,
+	}
+
+	for _, o := range opts {
+		if o == nil {
+			continue
+		}
+		if o.Endpoint != "" {
+			opt.Endpoint = o.Endpoint
+		}
+		if o.ClientID != "" {
+			opt.ClientID = o.ClientID
+		}
+		if o.ClientSecret != "" {
+			opt.ClientSecret = o.ClientSecret
+		}
+		if o.HTTPClient != nil {
+			opt.HTTPClient = o.HTTPClient
+		}
+		if o.CacheTTL != 0 {
+			opt.CacheTTL = o.CacheTTL
+		}
+		if o.NegativeCacheTTL != 0 {
+			opt.NegativeCacheTTL = o.NegativeCacheTTL
+		}
+		if o.MaxCacheEntries != 0 {
+			opt.MaxCacheEntries = o.MaxCacheEntries
+		}
+	}
+
+	return &opt
+}
+
+// Introspector validates opaque tokens using an OAuth2 token introspection endpoint (RFC 7662).
+// It is safe for concurrent use.
+type Introspector struct {
+	opts IntrospectorOpts
+
+	mu    sync.Mutex
+	ll    *list.List
+	cache map[[sha256.Size]byte]*list.Element
+}
+
+type introspection struct {
+	key [sha256.Size]byte
+	// claims is nil if the token is inactive
+	claims    jwt.MapClaims
+	expiresAt time.Time
+}
+
+// NewIntrospector returns a new Introspector.
+// set it as JwtMiddlewareOpts.Introspector to validate opaque tokens by the JWT middleware.
+func NewIntrospector(opts ...*IntrospectorOpts) *Introspector {
+	return &Introspector{
+		opts:  *mergeIntrospectorOpts(opts...),
+		ll:    list.New(),
+		cache: map[[sha256.Size]byte]*list.Element{},
+	}
+}
+
+// Introspect returns a token whose claims are the introspection response of bearer.
+// the space delimited "scope" is also mapped into the ScopesClaim array so the token can be used with WithScopes.
+// an inactive token results in a *ValidationError.
+func (i *Introspector) Introspect(ctx context.Context, bearer string) (*jwt.Token, error) {
+	key := sha256.Sum256([]byte(bearer))
+	now := time.Now()
+
+	res, ok := i.load(key, now)
+	if !ok {
+		claims, err := i.introspect(ctx, bearer)
+		if err != nil {
+			return nil, newValidationError(ReasonIntrospection, "", err)
+		}
+		res = &introspection{key: key, claims: claims}
+		i.store(res, now)
+	}
+
+	if res.claims == nil {
+		return nil, newValidationError(ReasonInactive, "active", fmt.Errorf("token is not active"))
+	}
+
+	// cached claims are shared by concurrent requests, each request gets its own copy
+	return &jwt.Token{
+		Raw:    bearer,
+		Header: map[string]interface{}{},
+		Claims: copyClaims(res.claims),
+		Valid:  true,
+	}, nil
+}
+
+// load returns the cached introspection of key, if not expired at now.
+func (i *Introspector) load(key [sha256.Size]byte, now time.Time) (*introspection, bool) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	el, ok := i.cache[key]
+	if !ok {
+		return nil, false
+	}
+	res := el.Value.(*introspection)
+	if !now.Before(res.expiresAt) {
+		i.remove(el)
+		return nil, false
+	}
+	i.ll.MoveToFront(el)
+
+	return res, true
+}
+
+// store caches res, bounded by the ttl options and the "exp" claim.
+// the least recently used tokens are evicted once MaxCacheEntries is reached.
+func (i *Introspector) store(res *introspection, now time.Time) {
+	ttl := i.opts.NegativeCacheTTL
+	if res.claims != nil {
+		ttl = i.opts.CacheTTL
+	}
+	if ttl <= 0 {
+		return
+	}
+
+	res.expiresAt = now.Add(ttl)
+	if res.claims != nil {
+		if exp, ok := claimsx.NumericDate(res.claims["exp"]); ok && exp.Before(res.expiresAt) {
+			res.expiresAt = exp
+		}
+	}
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	if el, ok := i.cache[res.key]; ok {
+		el.Value = res
+		i.ll.MoveToFront(el)
+		return
+	}
+
+	i.cache[res.key] = i.ll.PushFront(res)
+	for i.ll.Len() > i.opts.MaxCacheEntries {
+		i.remove(i.ll.Back())
+	}
+}
+
+// purge removes all the cached tokens.
+func (i *Introspector) purge() {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.ll.Init()
+	i.cache = map[[sha256.Size]byte]*list.Element{}
+}
+
+// remove removes el from the cache, i.mu must be held.
+func (i *Introspector) remove(el *list.Element) {
+	i.ll.Remove(el)
+	delete(i.cache, el.Value.(*introspection).key)
+}
+
+// introspect calls the introspection endpoint, it returns nil claims if the token is inactive.
+func (i *Introspector) introspect(ctx context.Context, bearer string) (jwt.MapClaims, error) {
+	form := url.Values{"token": {bearer}, "token_type_hint": {"access_token"}}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, i.opts.Endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if i.opts.ClientID != "" {
+		req.SetBasicAuth(url.QueryEscape(i.opts.ClientID), url.QueryEscape(i.opts.ClientSecret))
+	}
+
+	resp, err := i.opts.HTTPClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error calling introspection endpoint: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("error calling introspection endpoint: unexpected status code %d", resp.StatusCode)
+	}
+
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("error reading introspection response: %w", err)
+	}
+
+	claims := jwt.MapClaims{}
+	if err := json.Unmarshal(b, &claims); err != nil {
+		return nil, fmt.Errorf("error decoding introspection response: %w", err)
+	}
+
+	if active, _ := claims["active"].(bool); !active {
+		return nil, nil
+	}
+
+	if scope, ok := claims["scope"].(string); ok {
+		fields := strings.Fields(scope)
+		scp := make([]interface{}, len(fields))
+		for k, s := range fields {
+			scp[k] = s
+		}
+		claims[ScopesClaim] = scp
+	}
+
+	return claims, nil
+}
+
+// isJWS returns true if bearer looks like a compact serialized JWS (header.payload.signature).
+func isJWS(bearer string) bool {
+	return strings.Count(bearer, ".") == 2
+}
@@ -0,0 +1,183 @@
+package jwtmw
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/crossid/crossid-go/pkg/x/testx"
+	"github.com/golang-jwt/jwt/v4"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newFakeIntrospectionEndpoint serves introspection responses of the tokens in active, any other token is inactive.
+func newFakeIntrospectionEndpoint(t *testing.T, hits *int32, active map[string]map[string]interface{}) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(hits, 1)
+		testx.AssertTrue(t, r.Method == http.MethodPost, "expected POST")
+		id, secret, ok := r.BasicAuth()
+		if !ok || id != "rs" || secret != "s3cr3t" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		resp, ok := active[r.PostFormValue("token")]
+		if !ok {
+			resp = map[string]interface{}{"active": false}
+		}
+		testx.AssertNoError(t, json.NewEncoder(w).Encode(resp))
+	}))
+}
+
+func TestIntrospector_Introspect(t *testing.T) {
+	var hits int32
+	srv := newFakeIntrospectionEndpoint(t, &hits, map[string]map[string]interface{}{
+		"opaque": {"active": true, "sub": "alice", "client_id": "app", "scope": "orders:read orders:write"},
+		// "exp" is truncated to seconds so the cache entry is already expired when stored
+		"short": {"active": true, "exp": float64(time.Now().Unix())},
+	})
+	defer srv.Close()
+
+	i := NewIntrospector(&IntrospectorOpts{Endpoint: srv.URL, ClientID: "rs", ClientSecret: "s3cr3t"})
+
+	for n := 0; n < 3; n++ {
+		tok, err := i.Introspect(context.Background(), "opaque")
+		testx.AssertNoError(t, err)
+		c := tok.Claims.(jwt.MapClaims)
+		testx.AssertTrue(t, c["sub"] == "alice" && c["client_id"] == "app", "claims mismatch")
+		scp, err := DefaultClaimsFromToken(context.Background(), tok)
+		testx.AssertNoError(t, err)
+		testx.AssertTrue(t, len(scp) == 2 && scp[0] == "orders:read", "scopes mismatch")
+	}
+	testx.AssertTrue(t, atomic.LoadInt32(&hits) == 1, "expected active token to be cached")
+
+	for n := 0; n < 3; n++ {
+		_, err := i.Introspect(context.Background(), "revoked")
+		testx.AssertTrue(t, errors.Is(err, ErrInvalidToken), "expected ErrInvalidToken")
+		var ve *ValidationError
+		testx.AssertTrue(t, errors.As(err, &ve) && ve.Reason == ReasonInactive, "expected inactive reason")
+	}
+	testx.AssertTrue(t, atomic.LoadInt32(&hits) == 2, "expected inactive token to be cached")
+
+	// cache is bounded by exp
+	_, err := i.Introspect(context.Background(), "short")
+	testx.AssertNoError(t, err)
+	_, err = i.Introspect(context.Background(), "short")
+	testx.AssertNoError(t, err)
+	testx.AssertTrue(t, atomic.LoadInt32(&hits) == 4, "expected cache entry to expire with the token")
+
+	_, err = NewIntrospector(&IntrospectorOpts{Endpoint: srv.URL, ClientID: "rs", ClientSecret: "wrong"}).Introspect(context.Background(), "opaque")
+	var ve *ValidationError
+	testx.AssertTrue(t, errors.As(err, &ve) && ve.Reason == ReasonIntrospection, "expected introspection failure")
+}
+
+func TestJWT_HandlerWithIntrospector(t *testing.T) {
+	var hits int32
+	srv := newFakeIntrospectionEndpoint(t, &hits, map[string]map[string]interface{}{
+		"opaque": {"active": true, "sub": "alice", "scope": "foo bar"},
+	})
+	defer srv.Close()
+	i := NewIntrospector(&IntrospectorOpts{Endpoint: srv.URL, ClientID: "rs", ClientSecret: "s3cr3t"})
+
+	for k, tc := range []struct {
+		name        string
+		opts        *JwtMiddlewareOpts
+		jwt         string
+		code        int
+		introspects bool
+	}{
+		{
+			name:        "standalone",
+			opts:        &JwtMiddlewareOpts{Introspector: i},
+			jwt:         "Bearer opaque",
+			code:        http.StatusOK,
+			introspects: true,
+		},
+		{
+			name:        "standalone - jws tokens are introspected",
+			opts:        &JwtMiddlewareOpts{Introspector: i},
+			jwt:         signHS256JWT(t, jwt.MapClaims{"scp": []string{"foo"}}),
+			code:        http.StatusUnauthorized,
+			introspects: true,
+		},
+		{
+			name:        "fallback - opaque token",
+			opts:        &JwtMiddlewareOpts{Introspector: i, KeyFunc: validKeyFuncHS256},
+			jwt:         "Bearer opaque",
+			code:        http.StatusOK,
+			introspects: true,
+		},
+		{
+			name: "fallback - jws token is validated locally",
+			opts: &JwtMiddlewareOpts{Introspector: i, KeyFunc: validKeyFuncHS256},
+			jwt:  signHS256JWT(t, jwt.MapClaims{"scp": []string{"foo"}}),
+			code: http.StatusOK,
+		},
+		{
+			name:        "inactive token",
+			opts:        &JwtMiddlewareOpts{Introspector: i, KeyFunc: validKeyFuncHS256},
+			jwt:         "Bearer inactive",
+			code:        http.StatusUnauthorized,
+			introspects: true,
+		},
+		{
+			name:        "registered claims validation applies",
+			opts:        &JwtMiddlewareOpts{Introspector: i, Audiences: []string{"api"}},
+			jwt:         "Bearer opaque",
+			code:        http.StatusUnauthorized,
+			introspects: true,
+		},
+	} {
+		t.Run(fmt.Sprintf("case=%d", k), func(t *testing.T) {
+			i.purge()
+			before := atomic.LoadInt32(&hits)
+
+			r, err := http.NewRequest(http.MethodGet, "/", nil)
+			testx.AssertNoError(t, err)
+			r.Header.Set(BearerHeaderKey, tc.jwt)
+			w := httptest.NewRecorder()
+			NewJWT(tc.opts).Handler(WithScopes("foo")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))).ServeHTTP(w, r)
+
+			testx.AssertTrue(t, w.Code == tc.code, fmt.Sprintf("expected code %d but got %d", tc.code, w.Code))
+			testx.AssertTrue(t, (atomic.LoadInt32(&hits) > before) == tc.introspects, "unexpected introspection")
+		})
+	}
+}
+
+func TestIntrospector_Cache(t *testing.T) {
+	var hits int32
+	srv := newFakeIntrospectionEndpoint(t, &hits, map[string]map[string]interface{}{
+		"a": {"active": true, "sub": "alice", "scope": "orders:read"},
+		"b": {"active": true, "sub": "bob"},
+		"c": {"active": true, "sub": "carol"},
+	})
+	defer srv.Close()
+
+	i := NewIntrospector(&IntrospectorOpts{Endpoint: srv.URL, ClientID: "rs", ClientSecret: "s3cr3t", MaxCacheEntries: 2})
+	introspect := func(bearer string) jwt.MapClaims {
+		tok, err := i.Introspect(context.Background(), bearer)
+		testx.AssertNoError(t, err)
+		return tok.Claims.(jwt.MapClaims)
+	}
+
+	// modifying the claims of a request does not affect the cached claims
+	c := introspect("a")
+	c["sub"] = "mallory"
+	c[ScopesClaim].([]interface{})[0] = "admin"
+	c = introspect("a")
+	testx.AssertTrue(t, c["sub"] == "alice" && c[ScopesClaim].([]interface{})[0] == "orders:read", "expected cached claims to be unchanged")
+
+	// "a" is the least recently used token once "b" and "c" are cached
+	introspect("b")
+	introspect("c")
+	before := atomic.LoadInt32(&hits)
+	introspect("b")
+	introspect("c")
+	testx.AssertTrue(t, atomic.LoadInt32(&hits) == before, "expected recently used tokens to be cached")
+	introspect("a")
+	testx.AssertTrue(t, atomic.LoadInt32(&hits) == before+1, "expected the least recently used token to be evicted")
+}
@@ -0,0 +1,122 @@
+package jwtmw
+
+import (
+	"context"
+	"github.com/golang-jwt/jwt/v4"
+	"sync"
+)
+
+// IssuerConfig describes how tokens of a specific issuer are validated.
+// unset fields fall back to the corresponding JwtMiddlewareOpts.
+type IssuerConfig struct {
+	// Tenant optionally identifies the tenant of the issuer, it is put in the request's context.
+	Tenant string
+	// KeyFunc receives the parsed token and should return the key for validating.
+	KeyFunc Keyfunc
+	// KeySetVersion identifies the keys returned by KeyFunc (e.g., jwks.RemoteKeySet.Version),
+	// cached tokens of the issuer are verified again whenever it changes, see JwtMiddlewareOpts.TokenCache.
+	KeySetVersion func() uint64
+	// Algorithms is the list of allowed signing algorithms (e.g., "RS256", "ES256").
+	Algorithms []string
+	// Audiences, if set, requires the "aud" claim to contain the given audiences.
+	Audiences []string
+	// AudienceMatch defines whether any (default) or all of the Audiences must be present.
+	AudienceMatch AudienceMatch
+	// Claims is a prototype of the claims the JWT claims are decoded into, see JwtMiddlewareOpts.Claims.
+	Claims jwt.Claims
+	// ClaimsFactory, if set, returns the claims the JWT claims are decoded into, it takes precedence over Claims.
+	ClaimsFactory ClaimsFactory
+}
+
+// IssuerResolver returns the configuration of the issuer iss.
+// iss is read from the token before it is verified, an unknown issuer must result in an error.
+type IssuerResolver func(ctx context.Context, iss string) (*IssuerConfig, error)
+
+// StaticIssuers returns an IssuerResolver of a fixed set of issuers keyed by their "iss".
+func StaticIssuers(issuers map[string]*IssuerConfig) IssuerResolver {
+	return func(_ context.Context, iss string) (*IssuerConfig, error) {
+		if c, ok := issuers[iss]; ok {
+			return c, nil
+		}
+		return nil, ErrUnknownIssuer
+	}
+}
+
+// CachedIssuers returns an IssuerResolver that resolves an issuer by resolve once, and caches the configuration.
+// this is useful when resolving is expensive such as discovering the issuer (see oidc.NewProvider).
+// concurrent lookups of an issuer share a single call to resolve, errors are not cached.
+//
+// the cache is not bounded and iss is read from the token before it is verified, hence resolve must
+// reject unknown issuers (e.g., by an allow-list of issuers) rather than resolve any issuer of a token.
+func CachedIssuers(resolve IssuerResolver) IssuerResolver {
+	var mu sync.RWMutex
+	cache := map[string]*IssuerConfig{}
+	// calls are the lookups in progress, keyed by issuer
+	calls := map[string]*issuerCall{}
+
+	return func(ctx context.Context, iss string) (*IssuerConfig, error) {
+		mu.RLock()
+		c, ok := cache[iss]
+		mu.RUnlock()
+		if ok {
+			return c, nil
+		}
+
+		mu.Lock()
+		if c, ok := cache[iss]; ok {
+			mu.Unlock()
+			return c, nil
+		}
+		call, ok := calls[iss]
+		if !ok {
+			call = &issuerCall{done: make(chan struct{})}
+			calls[iss] = call
+		}
+		mu.Unlock()
+
+		if ok {
+			select {
+			case <-call.done:
+				return call.c, call.err
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+		}
+
+		defer func() {
+			mu.Lock()
+			if call.err == nil {
+				cache[iss] = call.c
+			}
+			delete(calls, iss)
+			mu.Unlock()
+			close(call.done)
+		}()
+		call.c, call.err = resolve(ctx, iss)
+
+		return call.c, call.err
+	}
+}
+
+// issuerCall is a lookup of an issuer by CachedIssuers, shared by concurrent lookups of the issuer.
+type issuerCall struct {
+	done chan struct{}
+	c    *IssuerConfig
+	err  error
+}
+
+// Tenant describes the issuer a token was validated against.
+type Tenant struct {
+	// Issuer is the "iss" claim of the token.
+	Issuer string
+	// ID is the Tenant of the IssuerConfig of the issuer.
+	ID string
+}
+
+type tenantCtxKey struct{}
+
+// TenantFromContext returns the tenant put in context by the JWT middleware, if it runs with an IssuerResolver.
+func TenantFromContext(ctx context.Context) (*Tenant, bool) {
+	t, ok := ctx.Value(tenantCtxKey{}).(*Tenant)
+	return t, ok
+}
@@ -0,0 +1,161 @@
+package jwtmw
+
+import (
+	"context"
+	"fmt"
+	"github.com/crossid/crossid-go/pkg/x/testx"
+	"github.com/golang-jwt/jwt/v4"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestJWT_HandlerMultiIssuer(t *testing.T) {
+	issuers := StaticIssuers(map[string]*IssuerConfig{
+		"https://acme.crossid.io": {
+			Tenant:  "acme",
+			KeyFunc: validKeyFuncHS256,
+		},
+		"https://globex.crossid.io": {
+			Tenant:     "globex",
+			KeyFunc:    newValidKeyFuncRS256(&prkey.PublicKey),
+			Algorithms: []string{jwt.SigningMethodRS256.Alg()},
+			Audiences:  []string{"api"},
+		},
+	})
+
+	for k, tc := range []struct {
+		name   string
+		jwt    string
+		tenant string
+	}{
+		{
+			name:   "first tenant",
+			jwt:    signHS256JWT(t, jwt.MapClaims{"iss": "https://acme.crossid.io"}),
+			tenant: "acme",
+		},
+		{
+			name:   "second tenant",
+			jwt:    signRS256JWT(t, prkey, jwt.MapClaims{"iss": "https://globex.crossid.io", "aud": "api"}),
+			tenant: "globex",
+		},
+		{
+			name: "second tenant with wrong audience",
+			jwt:  signRS256JWT(t, prkey, jwt.MapClaims{"iss": "https://globex.crossid.io", "aud": "web"}),
+		},
+		{
+			name: "token of one tenant signed with the key of another",
+			jwt:  signHS256JWT(t, jwt.MapClaims{"iss": "https://globex.crossid.io", "aud": "api"}),
+		},
+		{
+			name: "unknown issuer",
+			jwt:  signHS256JWT(t, jwt.MapClaims{"iss": "https://evil.io"}),
+		},
+		{
+			name: "missing issuer",
+			jwt:  signHS256JWT(t, jwt.MapClaims{}),
+		},
+	} {
+		t.Run(fmt.Sprintf("case=%d", k), func(t *testing.T) {
+			var tenant *Tenant
+			h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				tenant, _ = TenantFromContext(r.Context())
+			})
+
+			r, err := http.NewRequest(http.MethodGet, "/", nil)
+			testx.AssertNoError(t, err)
+			r.Header.Set(BearerHeaderKey, tc.jwt)
+			w := httptest.NewRecorder()
+			NewJWT(&JwtMiddlewareOpts{IssuerResolver: issuers}).Handler(h).ServeHTTP(w, r)
+
+			if tc.tenant == "" {
+				testx.AssertTrue(t, w.Code == http.StatusUnauthorized, fmt.Sprintf("expected code 401 but got %d", w.Code))
+				return
+			}
+
+			testx.AssertTrue(t, w.Code == http.StatusOK, fmt.Sprintf("expected code 200 but got %d", w.Code))
+			testx.AssertTrue(t, tenant != nil && tenant.ID == tc.tenant, "tenant mismatch")
+		})
+	}
+}
+
+func TestCachedIssuers(t *testing.T) {
+	calls := 0
+	resolve := CachedIssuers(func(ctx context.Context, iss string) (*IssuerConfig, error) {
+		calls++
+		if iss != "https://acme.crossid.io" {
+			return nil, ErrUnknownIssuer
+		}
+		return &IssuerConfig{Tenant: "acme"}, nil
+	})
+
+	for i := 0; i < 3; i++ {
+		c, err := resolve(context.Background(), "https://acme.crossid.io")
+		testx.AssertNoError(t, err)
+		testx.AssertTrue(t, c.Tenant == "acme", "tenant mismatch")
+	}
+	testx.AssertTrue(t, calls == 1, "expected a single resolve")
+
+	_, err := resolve(context.Background(), "https://evil.io")
+	testx.AssertError(t, err)
+	_, err = resolve(context.Background(), "https://evil.io")
+	testx.AssertError(t, err)
+	testx.AssertTrue(t, calls == 3, "errors should not be cached")
+}
+
+func TestCachedIssuers_Concurrent(t *testing.T) {
+	var calls int32
+	release := make(chan struct{})
+	resolve := CachedIssuers(func(ctx context.Context, iss string) (*IssuerConfig, error) {
+		atomic.AddInt32(&calls, 1)
+		<-release
+		return &IssuerConfig{Tenant: "acme"}, nil
+	})
+
+	const n = 16
+	var wg sync.WaitGroup
+	configs := make(chan *IssuerConfig, n)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c, err := resolve(context.Background(), "https://acme.crossid.io")
+			if err == nil {
+				configs <- c
+			}
+		}()
+	}
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	close(configs)
+
+	var first *IssuerConfig
+	got := 0
+	for c := range configs {
+		if first == nil {
+			first = c
+		}
+		testx.AssertTrue(t, c == first, "expected concurrent lookups to share a configuration")
+		got++
+	}
+	testx.AssertTrue(t, got == n, fmt.Sprintf("expected %d configurations but got %d", n, got))
+	testx.AssertTrue(t, atomic.LoadInt32(&calls) == 1, fmt.Sprintf("expected a single resolve but got %d", calls))
+
+	// a lookup waiting for another one stops with its context
+	block := make(chan struct{})
+	defer close(block)
+	resolve = CachedIssuers(func(ctx context.Context, iss string) (*IssuerConfig, error) {
+		<-block
+		return &IssuerConfig{}, nil
+	})
+	go func() { _, _ = resolve(context.Background(), "https://acme.crossid.io") }()
+	time.Sleep(10 * time.Millisecond)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err := resolve(ctx, "https://acme.crossid.io")
+	testx.AssertTrue(t, err == context.DeadlineExceeded, fmt.Sprintf("expected a deadline error but got %v", err))
+}
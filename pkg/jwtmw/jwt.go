@@ -6,72 +6,289 @@ package jwtmw
 import (
 	"context"
 	"errors"
+	"fmt"
+	"github.com/crossid/crossid-go/pkg/x/stringslice"
 	"github.com/golang-jwt/jwt/v4"
 	"net/http"
+	"time"
 )
 
 // JWT returns a new middleware that performs JWT validations.
 type JWT struct {
-	opts JwtMiddlewareOpts
+	opts     JwtMiddlewareOpts
+	verifier *verifier
+	dpop     *dpopVerifier
+	mtls     *mtlsVerifier
+	// closers release resources owned by the middleware (e.g., a discovered provider)
+	closers []func()
+}
+
+// verifier verifies tokens of a single issuer.
+type verifier struct {
+	keyFunc Keyfunc
+	// algorithms are the allowed algorithms, nil allows any
+	algorithms []string
+	// newClaims returns the claims a single token is decoded into
+	newClaims ClaimsFactory
+	policy    *claimsPolicy
+	// keySetVersion identifies the keys returned by keyFunc, nil if unknown
+	keySetVersion func() uint64
 }
 
 func NewJWT(opts ...*JwtMiddlewareOpts) *JWT {
+	o := mergeOpts(opts...)
 	return &JWT{
-		opts: *mergeOpts(opts...),
+		opts: *o,
+		verifier: &verifier{
+			keyFunc:       o.KeyFunc,
+			algorithms:    algorithms(o),
+			newClaims:     claimsFactory(o.ClaimsFactory, o.Claims),
+			policy:        newClaimsPolicy(o),
+			keySetVersion: o.KeySetVersion,
+		},
+		dpop: newDPoPVerifier(o.DPoP),
+		mtls: newMTLSVerifier(o.MTLS),
+	}
+}
+
+// Close releases resources owned by the middleware such as background refreshes of keys.
+func (j *JWT) Close() {
+	for _, c := range j.closers {
+		c()
 	}
 }
 
 func (j *JWT) Validate(r *http.Request) (*jwt.Token, error) {
+	pt, _, err := j.validate(r)
+	return pt, err
+}
+
+func (j *JWT) validate(r *http.Request) (*jwt.Token, *Tenant, error) {
 	bearer, err := j.opts.TokenFromRequest(r)
 	if err != nil {
 		j.opts.Logger(Info, "error extracting token: %s", err)
-		return nil, ErrExtractingToken
+		return nil, nil, ErrExtractingToken
 	}
 
 	if bearer == "" {
 		j.opts.Logger(Info, "missing token")
-		return nil, ErrMissingToken
+		return nil, nil, ErrMissingToken
+	}
+
+	if j.opts.Introspector != nil && (!isJWS(bearer) || j.verifier.keyFunc == nil && j.opts.IssuerResolver == nil) {
+		pt, err := j.introspect(r, bearer)
+		return pt, nil, err
 	}
 
-	// validates and return a token
-	var c = j.opts.Claims
-	if c == nil {
-		c = jwt.MapClaims{}
+	v := j.verifier
+	var tenant *Tenant
+	if j.opts.IssuerResolver != nil {
+		if v, tenant, err = j.issuerVerifier(r.Context(), bearer); err != nil {
+			j.opts.Logger(Info, "error resolving issuer: %s", err)
+			return nil, nil, newValidationError(ReasonUnknownIssuer, "iss", err)
+		}
 	}
 
-	pt, err := jwt.ParseWithClaims(bearer, c, func(t *jwt.Token) (interface{}, error) {
-		return j.opts.KeyFunc(r.Context(), t)
+	var version uint64
+	if j.opts.TokenCache != nil {
+		if v.keySetVersion != nil {
+			version = v.keySetVersion()
+		}
+		if j.opts.TokenCache.get(bearer, version, time.Now()) {
+			pt, err := j.validateCached(r, v, bearer)
+			return pt, tenant, err
+		}
+	}
+
+	// validates and return a token, claims are decoded into a value of this request only
+	c := v.newClaims()
+
+	// algorithms that are not allowed are rejected before looking up a key.
+	// claims are validated below, with leeway.
+	p := &jwt.Parser{SkipClaimsValidation: true}
+	pt, err := p.ParseWithClaims(bearer, c, func(t *jwt.Token) (interface{}, error) {
+		alg := t.Method.Alg()
+		if v.algorithms != nil && stringslice.IndexOf(v.algorithms, alg) == -1 {
+			return nil, newValidationError(ReasonAlgorithm, "", fmt.Errorf("signing algorithm '%s' is not allowed", alg))
+		}
+		key, err := v.keyFunc(r.Context(), t)
+		if err != nil {
+			return nil, err
+		}
+		if err := verifyKeyType(alg, key); err != nil {
+			return nil, newValidationError(ReasonAlgorithm, "", err)
+		}
+		return key, nil
 	})
 	if err != nil {
 		j.opts.Logger(Info, "error parsing token: %v", err)
-		return nil, ErrInvalidToken
-	}
-
-	if j.opts.SigningMethod != nil && j.opts.SigningMethod.Alg() != pt.Header["alg"] {
-		j.opts.Logger(Info, "invalid signing algorithm, expected '%s' but got '%s'", j.opts.SigningMethod.Alg(), pt.Header["alg"])
-		return nil, ErrInvalidToken
+		return nil, nil, asValidationError(err)
 	}
 
 	if !pt.Valid {
 		j.opts.Logger(Info, "invalid token (typically due to claims invalidation)")
-		return nil, ErrInvalidToken
+		return nil, nil, newValidationError(ReasonSignature, "", nil)
+	}
+
+	now := time.Now()
+	if err := v.policy.verify(pt, now); err != nil {
+		j.opts.Logger(Info, "invalid claims: %s", err)
+		return nil, nil, err
+	}
+
+	if j.opts.TokenCache != nil {
+		j.opts.TokenCache.add(bearer, version, now, pt)
+	}
+
+	if err := j.verifyRequest(r, pt, c); err != nil {
+		return nil, nil, err
+	}
+
+	return pt, tenant, nil
+}
+
+// validateCached validates a bearer found in the TokenCache by v, its signature was verified when cached.
+// claims are decoded into a value of this request only, as a token that was not cached.
+func (j *JWT) validateCached(r *http.Request, v *verifier, bearer string) (*jwt.Token, error) {
+	c := v.newClaims()
+	pt, _, err := new(jwt.Parser).ParseUnverified(bearer, c)
+	if err != nil {
+		j.opts.Logger(Info, "error parsing token: %v", err)
+		return nil, asValidationError(err)
+	}
+	pt.Valid = true
+
+	if err := v.policy.verify(pt, time.Now()); err != nil {
+		j.opts.Logger(Info, "invalid claims: %s", err)
+		return nil, err
+	}
+
+	if err := j.verifyRequest(r, pt, c); err != nil {
+		return nil, err
+	}
+
+	return pt, nil
+}
+
+// introspect validates an opaque bearer using the introspector.
+func (j *JWT) introspect(r *http.Request, bearer string) (*jwt.Token, error) {
+	pt, err := j.opts.Introspector.Introspect(r.Context(), bearer)
+	if err != nil {
+		j.opts.Logger(Info, "error introspecting token: %s", err)
+		return nil, err
+	}
+
+	if err := j.verifier.policy.verify(pt, time.Now()); err != nil {
+		j.opts.Logger(Info, "invalid claims: %s", err)
+		return nil, err
+	}
+
+	if err := j.verifyRequest(r, pt, pt.Claims); err != nil {
+		return nil, err
+	}
+
+	return pt, nil
+}
+
+// verifyRequest runs the validations of a valid token that depend on the request r.
+func (j *JWT) verifyRequest(r *http.Request, pt *jwt.Token, c jwt.Claims) error {
+	if j.dpop != nil {
+		if err := j.dpop.verify(r, pt); err != nil {
+			j.opts.Logger(Info, "invalid DPoP: %s", err)
+			return err
+		}
+	}
+
+	if j.mtls != nil {
+		if err := j.mtls.verify(r, pt); err != nil {
+			j.opts.Logger(Info, "invalid certificate binding: %s", err)
+			return err
+		}
+	}
+
+	if j.opts.RevocationStore != nil {
+		if err := j.verifyRevocation(r.Context(), pt); err != nil {
+			j.opts.Logger(Info, "revoked token: %s", err)
+			return err
+		}
 	}
 
 	if j.opts.Validate != nil {
 		if err := j.opts.Validate(r, pt, c); err != nil {
 			j.opts.Logger(Info, "custom validation failed: %s", err)
-			return nil, ErrInvalidToken
+			return newValidationError(ReasonCustom, "", err)
 		}
 	}
 
-	return pt, nil
+	return nil
+}
+
+// issuerVerifier resolves the issuer of the unverified bearer and returns a verifier of that issuer.
+func (j *JWT) issuerVerifier(ctx context.Context, bearer string) (*verifier, *Tenant, error) {
+	ut, _, err := new(jwt.Parser).ParseUnverified(bearer, jwt.MapClaims{})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	iss, _ := ut.Claims.(jwt.MapClaims)["iss"].(string)
+	if iss == "" {
+		return nil, nil, ErrUnknownIssuer
+	}
+
+	ic, err := j.opts.IssuerResolver(ctx, iss)
+	if err != nil {
+		return nil, nil, err
+	}
+	if ic == nil {
+		return nil, nil, ErrUnknownIssuer
+	}
+
+	v := &verifier{
+		keyFunc:       j.verifier.keyFunc,
+		algorithms:    j.verifier.algorithms,
+		newClaims:     j.verifier.newClaims,
+		keySetVersion: j.verifier.keySetVersion,
+	}
+	if ic.KeyFunc != nil {
+		v.keyFunc = ic.KeyFunc
+		v.keySetVersion = ic.KeySetVersion
+	}
+	if len(ic.Algorithms) > 0 {
+		v.algorithms = ic.Algorithms
+	}
+	if ic.ClaimsFactory != nil || ic.Claims != nil {
+		v.newClaims = claimsFactory(ic.ClaimsFactory, ic.Claims)
+	}
+
+	policy := *j.verifier.policy
+	// the token must be issued by the issuer it was resolved by, regardless of Issuers
+	policy.issuers = []string{iss}
+	if ic.Audiences != nil {
+		policy.audiences = ic.Audiences
+		policy.audienceMatch = ic.AudienceMatch
+	}
+	v.policy = &policy
+
+	if v.keyFunc == nil {
+		return nil, nil, ErrUnknownIssuer
+	}
+
+	return v, &Tenant{Issuer: iss, ID: ic.Tenant}, nil
 }
 
 func (j *JWT) Handler(h http.Handler) http.Handler {
+	skip := MatchAny(j.opts.Skip...)
+	optional := MatchAny(j.opts.OptionalAuth...)
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		tok, err := j.Validate(r)
+		if skip(r) {
+			j.opts.Logger(Debug, "skipping %s %s", r.Method, r.URL.Path)
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		tok, tenant, err := j.validate(r)
 		if err != nil {
-			if j.opts.Optional && errors.Is(err, ErrMissingToken) {
+			if (j.opts.Optional || optional(r)) && errors.Is(err, ErrMissingToken) {
 				j.opts.Logger(Debug, "token is missing")
 				h.ServeHTTP(w, r)
 				return
@@ -81,7 +298,23 @@ func (j *JWT) Handler(h http.Handler) http.Handler {
 			return
 		}
 
-		ctx, err := j.opts.WithContext(context.WithValue(r.Context(), j.opts.TokenCtxKey, tok))
+		ctx := context.WithValue(r.Context(), j.opts.TokenCtxKey, tok)
+		if tenant != nil {
+			ctx = context.WithValue(ctx, tenantCtxKey{}, tenant)
+		}
+
+		p, err := j.opts.PrincipalMapper(ctx, tok)
+		if err != nil {
+			j.opts.Logger(Info, "error mapping principal: %s", err)
+			j.opts.ErrorWriter(w, r, newValidationError(ReasonClaims, "", err))
+			return
+		}
+		if p != nil {
+			p.token = tok
+			ctx = context.WithValue(ctx, principalCtxKey, p)
+		}
+
+		ctx, err = j.opts.WithContext(ctx)
 		if err != nil {
 			j.opts.Logger(Debug, "WithContext returned error: %s", err)
 			j.opts.ErrorWriter(w, r, err)
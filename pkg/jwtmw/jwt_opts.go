@@ -4,10 +4,7 @@ import (
 	"context"
 	"github.com/golang-jwt/jwt/v4"
 	"net/http"
-)
-
-const (
-	TokenCtxKey = "crossidTokenKey"
+	"time"
 )
 
 // tokenValidator validates that t and c are valid.
@@ -17,30 +14,82 @@ type Keyfunc = func(ctx context.Context, t *jwt.Token) (interface{}, error)
 
 // JwtMiddlewareOpts describes the options of the JWTMiddleware
 type JwtMiddlewareOpts struct {
-	// TokenFromRequest extracts the bearer token from r
+	// TokenFromRequest extracts the bearer token from r.
+	// defaults to BearerTokenFromRequest, or DPoPTokenFromRequest if DPoP is set.
 	TokenFromRequest tokenFromRequest
 	// KeyFunc receives the parsed token and should return the key for validating.
 	// this can be a secret or a key
 	KeyFunc Keyfunc
+	// Algorithms is the list of allowed signing algorithms (e.g., "RS256", "ES256", "PS256").
+	// tokens signed by other algorithms are rejected before KeyFunc is called.
+	// it is strongly advised to set it to avoid algorithm confusion, multiple algorithms allow rolling over between them.
+	Algorithms []string
 	// SigningMethod defines the algorithm that should be used when verifying tokens.
+	// Deprecated: use Algorithms, SigningMethod is ignored if Algorithms is set.
 	SigningMethod jwt.SigningMethod
 	// Validate validates that the parsed token and claims are valid
 	Validate tokenValidator
-	// Claims will contain the JWT claims, decoded into the provided struct by reference.
+	// Claims is a prototype of the claims the JWT claims are decoded into, each request decodes into a new value
+	// of the same type (see ClaimsFactoryOf), hence the prototype itself is never written to.
 	// It is advised to use jwt.MapClaims for dynamic map or jwt.StandardClaims for standard claims with type safety.
 	// Where those implementations already implements the Valid() method to verify standard claims such as exp, iat, nbf.
 	// and also provides convenience tools to perform extra validations such `VerifyAudience
 	Claims jwt.Claims
+	// ClaimsFactory, if set, returns the claims the JWT claims of a single request are decoded into,
+	// it takes precedence over Claims.
+	ClaimsFactory ClaimsFactory
+	// Introspector, if set, validates opaque (non JWS) tokens using a token introspection endpoint.
+	// if KeyFunc and IssuerResolver are not set, all tokens are introspected.
+	Introspector *Introspector
+	// TokenCache, if set, caches verified tokens so repeated validations of a token skip the signature verification.
+	// the validations that depend on the request or time (e.g., DPoP, revocation, exp) still run on every request.
+	TokenCache *TokenCache
+	// KeySetVersion identifies the keys returned by KeyFunc (e.g., jwks.RemoteKeySet.Version),
+	// cached tokens are verified again whenever it changes. an issuer of IssuerResolver with its own KeyFunc
+	// is identified by IssuerConfig.KeySetVersion instead.
+	KeySetVersion func() uint64
+	// RevocationStore, if set, rejects revoked tokens, checked after the claims are validated.
+	RevocationStore RevocationStore
+	// DPoP, if set, enables validation of DPoP (RFC 9449) bound tokens and their proofs.
+	DPoP *DPoPOpts
+	// MTLS, if set, enables validation of mutual-TLS certificate bound tokens (RFC 8705).
+	MTLS *MTLSOpts
+	// IssuerResolver, if set, enables multi issuer validation where the configuration
+	// used to validate a token is selected by its "iss" claim, tokens of unknown issuers are rejected.
+	// the resolved issuer is put in the request's context, see TenantFromContext.
+	IssuerResolver IssuerResolver
+	// Issuers, if set, requires the "iss" claim to be one of the given issuers.
+	Issuers []string
+	// Audiences, if set, requires the "aud" claim, either a string or an array, to contain the given audiences.
+	Audiences []string
+	// AudienceMatch defines whether any (default) or all of the Audiences must be present.
+	AudienceMatch AudienceMatch
+	// AuthorizedParty, if set, requires the "azp" claim to be the given client id.
+	AuthorizedParty string
+	// MaxAge, if set, rejects tokens issued, per the "iat" claim, more than MaxAge ago.
+	// tokens without an "iat" claim are rejected.
+	MaxAge time.Duration
+	// Leeway is the tolerated clock skew when validating the "exp", "nbf" and "iat" claims.
+	Leeway time.Duration
 	// Optional is true if no error should be returned in case token was not specified
 	// If true and no token given, the middleware will continue the chain but no token will be put in request context.
 	// If false and no token was given, the this middleware will render an error and stop the chain.
 	Optional bool
+	// Skip lists matchers of requests that are passed to the next handler as is, without extracting or validating a token
+	// (e.g., MatchPath("/") or MatchMethods(http.MethodOptions)).
+	Skip []RequestMatcher
+	// OptionalAuth lists matchers of requests that are treated as if Optional is true,
+	// a token is validated and put in context if present, but not required.
+	OptionalAuth []RequestMatcher
 	// ErrorWriter writes an error into w
 	ErrorWriter errorWriter
 	// Logger logs various messages
 	Logger logger
 	// TokenCtxKey is the context key of a valid token that is put in the request's context.
 	TokenCtxKey interface{}
+	// PrincipalMapper maps a valid token into the Principal put in the request's context, see PrincipalFromContext.
+	// defaults to DefaultPrincipalMapper.
+	PrincipalMapper PrincipalMapper
 	// WithContext is a way to put another context in request chain.
 	// this let consumer enhances context with a key such as user loaded from db, etc.
 	WithContext func(ctx context.Context) (context.Context, error)
@@ -53,17 +102,23 @@ func mergeOpts(opts ...*JwtMiddlewareOpts) *JwtMiddlewareOpts {
 		ErrorWriter: func(w http.ResponseWriter, r *http.Request, err error) {
 			http.Error(w, err.Error(), http.StatusUnauthorized)
 		},
-		Logger:      func(level Level, format string, args ...interface{}) {},
-		TokenCtxKey: TokenCtxKey,
-		WithContext: func(c context.Context) (context.Context, error) { return c, nil },
+		Logger:          func(level Level, format string, args ...interface{}) {},
+		TokenCtxKey:     TokenCtxKey,
+		PrincipalMapper: DefaultPrincipalMapper,
+		WithContext:     func(c context.Context) (context.Context, error) { return c, nil },
 	}
 
+	var customExtractor bool
 	for _, o := range opts {
 		if o == nil {
 			continue
 		}
 		if o.TokenFromRequest != nil {
 			opt.TokenFromRequest = o.TokenFromRequest
+			customExtractor = true
+		}
+		if o.Algorithms != nil {
+			opt.Algorithms = o.Algorithms
 		}
 		if o.SigningMethod != nil {
 			opt.SigningMethod = o.SigningMethod
@@ -77,9 +132,57 @@ func mergeOpts(opts ...*JwtMiddlewareOpts) *JwtMiddlewareOpts {
 		if o.Claims != nil {
 			opt.Claims = o.Claims
 		}
+		if o.ClaimsFactory != nil {
+			opt.ClaimsFactory = o.ClaimsFactory
+		}
+		if o.Introspector != nil {
+			opt.Introspector = o.Introspector
+		}
+		if o.TokenCache != nil {
+			opt.TokenCache = o.TokenCache
+		}
+		if o.KeySetVersion != nil {
+			opt.KeySetVersion = o.KeySetVersion
+		}
+		if o.RevocationStore != nil {
+			opt.RevocationStore = o.RevocationStore
+		}
+		if o.DPoP != nil {
+			opt.DPoP = o.DPoP
+		}
+		if o.MTLS != nil {
+			opt.MTLS = o.MTLS
+		}
+		if o.IssuerResolver != nil {
+			opt.IssuerResolver = o.IssuerResolver
+		}
+		if o.Issuers != nil {
+			opt.Issuers = o.Issuers
+		}
+		if o.Audiences != nil {
+			opt.Audiences = o.Audiences
+		}
+		if o.AudienceMatch != AudienceMatchAny {
+			opt.AudienceMatch = o.AudienceMatch
+		}
+		if o.AuthorizedParty != "" {
+			opt.AuthorizedParty = o.AuthorizedParty
+		}
+		if o.MaxAge != 0 {
+			opt.MaxAge = o.MaxAge
+		}
+		if o.Leeway != 0 {
+			opt.Leeway = o.Leeway
+		}
 		if o.Optional {
 			opt.Optional = o.Optional
 		}
+		if o.Skip != nil {
+			opt.Skip = o.Skip
+		}
+		if o.OptionalAuth != nil {
+			opt.OptionalAuth = o.OptionalAuth
+		}
 		if o.ErrorWriter != nil {
 			opt.ErrorWriter = o.ErrorWriter
 		}
@@ -89,10 +192,17 @@ func mergeOpts(opts ...*JwtMiddlewareOpts) *JwtMiddlewareOpts {
 		if o.TokenCtxKey != nil {
 			opt.TokenCtxKey = o.TokenCtxKey
 		}
+		if o.PrincipalMapper != nil {
+			opt.PrincipalMapper = o.PrincipalMapper
+		}
 		if o.WithContext != nil {
 			opt.WithContext = o.WithContext
 		}
 	}
 
+	if opt.DPoP != nil && !customExtractor {
+		opt.TokenFromRequest = DPoPTokenFromRequest
+	}
+
 	return &opt
 }
@@ -0,0 +1,99 @@
+package jwtmw
+
+import (
+	"net/http"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// RequestMatcher reports whether r matches, e.g., to skip authentication of public routes.
+// any predicate of the request may be used as a matcher.
+// the path matchers match the cleaned URL path (see path.Clean), as routers that normalize paths serve it,
+// so "/public/../admin" is matched as "/admin".
+type RequestMatcher func(r *http.Request) bool
+
+// cleanPath returns the URL path of r without dot segments and duplicate slashes, keeping a trailing slash.
+func cleanPath(r *http.Request) string {
+	p := r.URL.Path
+	if p == "" {
+		return "/"
+	}
+	cp := path.Clean(p)
+	if strings.HasSuffix(p, "/") && cp != "/" {
+		cp += "/"
+	}
+	return cp
+}
+
+// MatchPathPrefix matches requests whose URL path starts with prefix (e.g., "/public/").
+func MatchPathPrefix(prefix string) RequestMatcher {
+	return func(r *http.Request) bool {
+		return strings.HasPrefix(cleanPath(r), prefix)
+	}
+}
+
+// MatchPath matches requests whose URL path is exactly p (e.g., "/").
+func MatchPath(p string) RequestMatcher {
+	return func(r *http.Request) bool {
+		return cleanPath(r) == p
+	}
+}
+
+// MatchPathGlob matches requests whose URL path matches the shell pattern (e.g., "/users/*/avatar"), see path.Match.
+// it panics if pattern is malformed.
+func MatchPathGlob(pattern string) RequestMatcher {
+	if _, err := path.Match(pattern, ""); err != nil {
+		panic("invalid glob pattern: " + err.Error())
+	}
+
+	return func(r *http.Request) bool {
+		ok, _ := path.Match(pattern, cleanPath(r))
+		return ok
+	}
+}
+
+// MatchPathRegexp matches requests whose URL path matches the regular expression expr (e.g., "^/v[0-9]+/health$").
+// it panics if expr is invalid.
+func MatchPathRegexp(expr string) RequestMatcher {
+	re := regexp.MustCompile(expr)
+	return func(r *http.Request) bool {
+		return re.MatchString(cleanPath(r))
+	}
+}
+
+// MatchMethods matches requests of any of the HTTP methods (e.g., http.MethodOptions for CORS preflight requests).
+func MatchMethods(methods ...string) RequestMatcher {
+	return func(r *http.Request) bool {
+		for _, m := range methods {
+			if strings.EqualFold(r.Method, m) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// MatchAll matches requests that are matched by all matchers, e.g., MatchAll(MatchMethods(http.MethodGet), MatchPathPrefix("/docs/")).
+func MatchAll(matchers ...RequestMatcher) RequestMatcher {
+	return func(r *http.Request) bool {
+		for _, m := range matchers {
+			if !m(r) {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+// MatchAny matches requests that are matched by any of matchers.
+func MatchAny(matchers ...RequestMatcher) RequestMatcher {
+	return func(r *http.Request) bool {
+		for _, m := range matchers {
+			if m(r) {
+				return true
+			}
+		}
+		return false
+	}
+}
@@ -0,0 +1,109 @@
+package jwtmw
+
+import (
+	"fmt"
+	"github.com/crossid/crossid-go/pkg/x/testx"
+	"github.com/golang-jwt/jwt/v4"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRequestMatchers(t *testing.T) {
+	for k, tc := range []struct {
+		m      RequestMatcher
+		method string
+		target string
+		match  bool
+	}{
+		{m: MatchPath("/"), target: "/", match: true},
+		{m: MatchPath("/"), target: "/foo"},
+		{m: MatchPathPrefix("/public/"), target: "/public/logo.png", match: true},
+		{m: MatchPathPrefix("/public/"), target: "/publication"},
+		// paths are matched once cleaned, as served by routers that normalize them
+		{m: MatchPathPrefix("/public/"), target: "/public/../admin"},
+		{m: MatchPathPrefix("/public/"), target: "/public/./../admin/"},
+		{m: MatchPathPrefix("/public/"), target: "/admin/../public/logo.png", match: true},
+		{m: MatchPathPrefix("/public/"), target: "//public//logo.png", match: true},
+		{m: MatchPath("/public"), target: "/public/.."},
+		{m: MatchPath("/"), target: "/public/..", match: true},
+		{m: MatchPathGlob("/users/*/avatar"), target: "/users/alice/../bob/avatar", match: true},
+		{m: MatchPathGlob("/users/*/avatar"), target: "/users/alice/avatar/../../../admin"},
+		{m: MatchPathRegexp("^/v[0-9]+/health$"), target: "/v2/health/../../admin"},
+		// a trailing slash is kept
+		{m: MatchPathPrefix("/public/"), target: "/public/./", match: true},
+		{m: MatchPath("/docs/"), target: "/docs/./", match: true},
+		{m: MatchPath("/docs"), target: "/docs/./"},
+		{m: MatchPathGlob("/users/*/avatar"), target: "/users/alice/avatar", match: true},
+		{m: MatchPathGlob("/users/*/avatar"), target: "/users/alice/profile/avatar"},
+		{m: MatchPathRegexp("^/v[0-9]+/health$"), target: "/v2/health", match: true},
+		{m: MatchPathRegexp("^/v[0-9]+/health$"), target: "/v2/health/deep"},
+		{m: MatchMethods(http.MethodOptions, http.MethodHead), method: http.MethodOptions, target: "/", match: true},
+		{m: MatchMethods(http.MethodOptions), target: "/"},
+		{m: MatchAll(MatchMethods(http.MethodGet), MatchPathPrefix("/docs/")), target: "/docs/api", match: true},
+		{m: MatchAll(MatchMethods(http.MethodGet), MatchPathPrefix("/docs/")), method: http.MethodPost, target: "/docs/api"},
+		{m: MatchAny(MatchPath("/a"), MatchPath("/b")), target: "/b", match: true},
+		{m: MatchAny(), target: "/"},
+		{m: func(r *http.Request) bool { return r.Header.Get("X-Internal") == "" }, target: "/", match: true},
+	} {
+		t.Run(fmt.Sprintf("case=%d", k), func(t *testing.T) {
+			method := tc.method
+			if method == "" {
+				method = http.MethodGet
+			}
+			got := tc.m(httptest.NewRequest(method, tc.target, nil))
+			testx.AssertTrue(t, got == tc.match, fmt.Sprintf("expected match to be %v", tc.match))
+		})
+	}
+}
+
+func TestJWT_Handler_SkipAndOptionalAuth(t *testing.T) {
+	mw := NewJWT(&JwtMiddlewareOpts{
+		KeyFunc:      validKeyFuncHS256,
+		Skip:         []RequestMatcher{MatchPath("/"), MatchPathPrefix("/public/"), MatchMethods(http.MethodOptions)},
+		OptionalAuth: []RequestMatcher{MatchPathPrefix("/feed")},
+	})
+	valid := signHS256JWT(t, jwt.MapClaims{"sub": "alice"})
+
+	for k, tc := range []struct {
+		method   string
+		target   string
+		tok      string
+		status   int
+		hasToken bool
+	}{
+		{target: "/", status: http.StatusOK},
+		// skipped requests are not validated, even if they present an invalid token
+		{target: "/", tok: "Bearer invalid", status: http.StatusOK},
+		{method: http.MethodOptions, target: "/admin", status: http.StatusOK},
+		{target: "/admin", status: http.StatusUnauthorized},
+		{target: "/public/logo.png", status: http.StatusOK},
+		// a path escaping a skipped prefix by dot segments is authenticated
+		{target: "/public/../admin", status: http.StatusUnauthorized},
+		{target: "/admin", tok: valid, status: http.StatusOK, hasToken: true},
+		{target: "/feed", status: http.StatusOK},
+		{target: "/feed", tok: valid, status: http.StatusOK, hasToken: true},
+		// a token presented to an optional route must be valid
+		{target: "/feed", tok: "Bearer invalid", status: http.StatusUnauthorized},
+	} {
+		t.Run(fmt.Sprintf("case=%d", k), func(t *testing.T) {
+			method := tc.method
+			if method == "" {
+				method = http.MethodGet
+			}
+			r := httptest.NewRequest(method, tc.target, nil)
+			if tc.tok != "" {
+				r.Header.Set(BearerHeaderKey, tc.tok)
+			}
+
+			var hasToken bool
+			w := httptest.NewRecorder()
+			mw.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				_, hasToken = TokenFromContext(r.Context())
+			})).ServeHTTP(w, r)
+
+			testx.AssertTrue(t, w.Code == tc.status, fmt.Sprintf("expected status %d but got %d", tc.status, w.Code))
+			testx.AssertTrue(t, hasToken == tc.hasToken, fmt.Sprintf("expected token in context to be %v", tc.hasToken))
+		})
+	}
+}
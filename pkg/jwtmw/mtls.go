@@ -0,0 +1,141 @@
+package jwtmw
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"github.com/golang-jwt/jwt/v4"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// MTLSOpts describes the options of mutual-TLS certificate bound tokens (RFC 8705).
+//
+// A token bound to a certificate, per its "cnf.x5t#S256" claim, is accepted only if sent
+// over a connection authenticated by that client certificate.
+type MTLSOpts struct {
+	// Required rejects tokens that are not bound to a certificate.
+	Required bool
+	// AllowSelfSigned uses the client certificate even if it was not verified by the TLS server,
+	// as in the self-signed certificate mode of RFC 8705 section 2.2.
+	AllowSelfSigned bool
+	// CertificateHeader, if set, is the name of a header (e.g., "X-Client-Cert") that contains the client
+	// certificate when TLS is terminated by a reverse proxy, either as a PEM, optionally URL encoded, or a base64 DER.
+	// the header must be set only by a trusted proxy that verified the certificate, it is read only from requests
+	// of a plain HTTP connection (r.TLS is nil), or requests TrustedProxy approves, since client certificates are public.
+	CertificateHeader string
+	// TrustedProxy, if set, reports whether r was sent by a trusted proxy (e.g., by its r.RemoteAddr),
+	// only then the CertificateHeader is read, over TLS or not.
+	TrustedProxy func(r *http.Request) bool
+}
+
+// mtlsVerifier verifies the binding of tokens to the client certificate.
+type mtlsVerifier struct {
+	opts MTLSOpts
+}
+
+func newMTLSVerifier(opts *MTLSOpts) *mtlsVerifier {
+	if opts == nil {
+		return nil
+	}
+	return &mtlsVerifier{opts: *opts}
+}
+
+// verify verifies that t, sent by r, is bound to the client certificate of r.
+func (m *mtlsVerifier) verify(r *http.Request, t *jwt.Token) error {
+	mc, err := rawClaims(t)
+	if err != nil {
+		return newValidationError(ReasonClaims, "", err)
+	}
+
+	var x5t string
+	if cnf, ok := mc["cnf"].(map[string]interface{}); ok {
+		x5t, _ = cnf["x5t#S256"].(string)
+	}
+
+	if x5t == "" {
+		if m.opts.Required {
+			return newValidationError(ReasonCertificateBinding, "cnf", fmt.Errorf("token is not certificate bound"))
+		}
+		return nil
+	}
+
+	cert, err := m.clientCertificate(r)
+	if err != nil {
+		return newValidationError(ReasonCertificateBinding, "cnf", err)
+	}
+
+	if CertificateThumbprint(cert) != x5t {
+		return newValidationError(ReasonCertificateBinding, "cnf", fmt.Errorf("token is not bound to the client certificate"))
+	}
+
+	return nil
+}
+
+// clientCertificate returns the client certificate of r
+func (m *mtlsVerifier) clientCertificate(r *http.Request) (*x509.Certificate, error) {
+	if r.TLS != nil {
+		if len(r.TLS.VerifiedChains) > 0 && len(r.TLS.VerifiedChains[0]) > 0 {
+			return r.TLS.VerifiedChains[0][0], nil
+		}
+		if m.opts.AllowSelfSigned && len(r.TLS.PeerCertificates) > 0 {
+			return r.TLS.PeerCertificates[0], nil
+		}
+	}
+
+	if m.opts.CertificateHeader != "" && m.trustedProxy(r) {
+		if v := r.Header.Get(m.opts.CertificateHeader); v != "" {
+			return parseCertificateHeader(v)
+		}
+	}
+
+	return nil, fmt.Errorf("missing client certificate")
+}
+
+// trustedProxy reports whether the CertificateHeader of r may be read.
+// a direct TLS connection is never trusted unless approved by TrustedProxy, as anyone may forward a public certificate.
+func (m *mtlsVerifier) trustedProxy(r *http.Request) bool {
+	if m.opts.TrustedProxy != nil {
+		return m.opts.TrustedProxy(r)
+	}
+	return r.TLS == nil
+}
+
+// parseCertificateHeader parses a certificate forwarded by a proxy
+func parseCertificateHeader(v string) (*x509.Certificate, error) {
+	// PEM is typically URL encoded since headers cannot contain new lines, '+' is kept as is.
+	if s, err := url.PathUnescape(v); err == nil {
+		v = s
+	}
+
+	var der []byte
+	if strings.HasPrefix(v, "-----BEGIN") {
+		b, _ := pem.Decode([]byte(v))
+		if b == nil {
+			return nil, fmt.Errorf("invalid forwarded certificate PEM")
+		}
+		der = b.Bytes
+	} else {
+		var err error
+		if der, err = base64.StdEncoding.DecodeString(v); err != nil {
+			return nil, fmt.Errorf("invalid forwarded certificate: %w", err)
+		}
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, fmt.Errorf("invalid forwarded certificate: %w", err)
+	}
+
+	return cert, nil
+}
+
+// CertificateThumbprint returns the base64url encoded SHA-256 thumbprint of cert,
+// as used by the "cnf.x5t#S256" confirmation claim.
+func CertificateThumbprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
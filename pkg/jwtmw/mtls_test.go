@@ -0,0 +1,185 @@
+package jwtmw
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"github.com/crossid/crossid-go/pkg/x/testx"
+	"github.com/golang-jwt/jwt/v4"
+	"math/big"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func newClientCertificate(t *testing.T, cn string) *x509.Certificate {
+	k, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	testx.AssertNoError(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &k.PublicKey, k)
+	testx.AssertNoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	testx.AssertNoError(t, err)
+	return cert
+}
+
+func TestJWT_ValidateMTLS(t *testing.T) {
+	cert := newClientCertificate(t, "svc-a")
+	other := newClientCertificate(t, "svc-b")
+	bound := signHS256JWT(t, jwt.MapClaims{"cnf": map[string]string{"x5t#S256": CertificateThumbprint(cert)}})
+	unbound := signHS256JWT(t, jwt.MapClaims{})
+	pemCert := string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw}))
+
+	verified := func(c *x509.Certificate) *tls.ConnectionState {
+		return &tls.ConnectionState{PeerCertificates: []*x509.Certificate{c}, VerifiedChains: [][]*x509.Certificate{{c}}}
+	}
+
+	for k, tc := range []struct {
+		name    string
+		opts    *MTLSOpts
+		jwt     string
+		tls     *tls.ConnectionState
+		headers map[string]string
+		fails   bool
+	}{
+		{
+			name: "verified certificate",
+			jwt:  bound,
+			tls:  verified(cert),
+		},
+		{
+			name:  "another certificate",
+			jwt:   bound,
+			tls:   verified(other),
+			fails: true,
+		},
+		{
+			name:  "no client certificate",
+			jwt:   bound,
+			fails: true,
+		},
+		{
+			name:  "unverified certificate",
+			jwt:   bound,
+			tls:   &tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}},
+			fails: true,
+		},
+		{
+			name: "unverified certificate with self signed mode",
+			opts: &MTLSOpts{AllowSelfSigned: true},
+			jwt:  bound,
+			tls:  &tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}},
+		},
+		{
+			name: "unbound token",
+			jwt:  unbound,
+			tls:  verified(cert),
+		},
+		{
+			name:  "unbound token when required",
+			opts:  &MTLSOpts{Required: true},
+			jwt:   unbound,
+			tls:   verified(cert),
+			fails: true,
+		},
+		{
+			name:    "url encoded PEM header",
+			opts:    &MTLSOpts{CertificateHeader: "X-Client-Cert"},
+			jwt:     bound,
+			headers: map[string]string{"X-Client-Cert": url.PathEscape(pemCert)},
+		},
+		{
+			name:    "base64 DER header",
+			opts:    &MTLSOpts{CertificateHeader: "X-Client-Cert"},
+			jwt:     bound,
+			headers: map[string]string{"X-Client-Cert": base64.StdEncoding.EncodeToString(cert.Raw)},
+		},
+		{
+			name:    "header is ignored unless configured",
+			jwt:     bound,
+			headers: map[string]string{"X-Client-Cert": url.PathEscape(pemCert)},
+			fails:   true,
+		},
+		{
+			name:    "header is ignored over a direct TLS connection",
+			opts:    &MTLSOpts{CertificateHeader: "X-Client-Cert"},
+			jwt:     bound,
+			tls:     &tls.ConnectionState{},
+			headers: map[string]string{"X-Client-Cert": url.PathEscape(pemCert)},
+			fails:   true,
+		},
+		{
+			name:    "header is ignored over a TLS connection with another certificate",
+			opts:    &MTLSOpts{CertificateHeader: "X-Client-Cert"},
+			jwt:     bound,
+			tls:     verified(other),
+			headers: map[string]string{"X-Client-Cert": url.PathEscape(pemCert)},
+			fails:   true,
+		},
+		{
+			name: "header of a trusted proxy over TLS",
+			opts: &MTLSOpts{CertificateHeader: "X-Client-Cert", TrustedProxy: func(r *http.Request) bool {
+				return r.RemoteAddr == "10.0.0.1:443"
+			}},
+			jwt:     bound,
+			tls:     &tls.ConnectionState{},
+			headers: map[string]string{"X-Client-Cert": url.PathEscape(pemCert)},
+		},
+		{
+			name: "header of an untrusted proxy",
+			opts: &MTLSOpts{CertificateHeader: "X-Client-Cert", TrustedProxy: func(r *http.Request) bool {
+				return r.RemoteAddr == "10.0.0.2:443"
+			}},
+			jwt:     bound,
+			headers: map[string]string{"X-Client-Cert": url.PathEscape(pemCert)},
+			fails:   true,
+		},
+		{
+			name:    "invalid header",
+			opts:    &MTLSOpts{CertificateHeader: "X-Client-Cert"},
+			jwt:     bound,
+			headers: map[string]string{"X-Client-Cert": "not a certificate"},
+			fails:   true,
+		},
+	} {
+		t.Run(fmt.Sprintf("case=%d", k), func(t *testing.T) {
+			r, err := http.NewRequest(http.MethodGet, "/", nil)
+			testx.AssertNoError(t, err)
+			r.Header.Set(BearerHeaderKey, tc.jwt)
+			r.TLS = tc.tls
+			r.RemoteAddr = "10.0.0.1:443"
+			for k, v := range tc.headers {
+				r.Header.Set(k, v)
+			}
+
+			opts := tc.opts
+			if opts == nil {
+				opts = &MTLSOpts{}
+			}
+			_, err = NewJWT(&JwtMiddlewareOpts{KeyFunc: validKeyFuncHS256, MTLS: opts}).Validate(r)
+			if !tc.fails {
+				testx.AssertNoError(t, err)
+				return
+			}
+
+			var ve *ValidationError
+			testx.AssertTrue(t, errors.As(err, &ve) && ve.Reason == ReasonCertificateBinding, fmt.Sprintf("expected reason %s but got %v", ReasonCertificateBinding, err))
+			code, status := bearerErrorCode(err)
+			testx.AssertTrue(t, code == BearerErrorInvalidToken && status == http.StatusUnauthorized, "expected invalid_token")
+		})
+	}
+}
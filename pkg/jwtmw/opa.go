@@ -0,0 +1,119 @@
+package jwtmw
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/golang-jwt/jwt/v4"
+	"net/http"
+	"time"
+)
+
+// OPAAuthorizerOpts describes the options of an OPAAuthorizer
+type OPAAuthorizerOpts struct {
+	// URL is the decision endpoint of the policy (e.g., http://localhost:8181/v1/data/httpapi/authz).
+	URL string
+	// HTTPClient is used to call the endpoint, defaults to an http.Client with a 5s timeout.
+	HTTPClient *http.Client
+}
+
+// OPAAuthorizer is an Authorizer that queries an OPA compatible decision endpoint.
+//
+// The endpoint receives {"input": {"claims": {...}, "request": {"method", "path", "host"}, "resource": {...}}}
+// and must respond with a boolean result, {"result": true}, or an object, {"result": {"allow": true, "reason": "..."}}.
+// An undefined result denies the request.
+type OPAAuthorizer struct {
+	opts OPAAuthorizerOpts
+}
+
+// NewOPAAuthorizer returns a new OPAAuthorizer.
+func NewOPAAuthorizer(opts ...*OPAAuthorizerOpts) *OPAAuthorizer {
+	o := OPAAuthorizerOpts{
+		HTTPClient: &http.Client{Timeout: 5 * time.Second},
+	}
+	for _, oo := range opts {
+		if oo == nil {
+			continue
+		}
+		if oo.URL != "" {
+			o.URL = oo.URL
+		}
+		if oo.HTTPClient != nil {
+			o.HTTPClient = oo.HTTPClient
+		}
+	}
+
+	return &OPAAuthorizer{opts: o}
+}
+
+type opaRequest struct {
+	Method string `json:"method"`
+	Path   string `json:"path"`
+	Host   string `json:"host"`
+}
+
+type opaInput struct {
+	Claims   jwt.MapClaims `json:"claims"`
+	Request  opaRequest    `json:"request"`
+	Resource *Resource     `json:"resource"`
+}
+
+// Authorize implements Authorizer.
+func (a *OPAAuthorizer) Authorize(ctx context.Context, t *jwt.Token, r *http.Request, res *Resource) (*Decision, error) {
+	claims, err := rawClaims(t)
+	if err != nil {
+		return nil, err
+	}
+
+	b, err := json.Marshal(map[string]interface{}{"input": &opaInput{
+		Claims:   claims,
+		Request:  opaRequest{Method: r.Method, Path: r.URL.Path, Host: r.Host},
+		Resource: res,
+	}})
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, a.opts.URL, bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := a.opts.HTTPClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error calling decision endpoint: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("error calling decision endpoint: unexpected status code %d", resp.StatusCode)
+	}
+
+	var out struct {
+		Result json.RawMessage `json:"result"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return nil, fmt.Errorf("error decoding decision: %w", err)
+	}
+
+	if len(out.Result) == 0 {
+		return &Decision{Allowed: false, Reason: "undefined decision"}, nil
+	}
+
+	var allowed bool
+	if err := json.Unmarshal(out.Result, &allowed); err == nil {
+		return &Decision{Allowed: allowed}, nil
+	}
+
+	var d struct {
+		Allow  bool   `json:"allow"`
+		Reason string `json:"reason"`
+	}
+	if err := json.Unmarshal(out.Result, &d); err != nil {
+		return nil, fmt.Errorf("error decoding decision: %w", err)
+	}
+
+	return &Decision{Allowed: d.Allow, Reason: d.Reason}, nil
+}
@@ -0,0 +1,240 @@
+package jwtmw
+
+import (
+	"context"
+	"fmt"
+	"github.com/crossid/crossid-go/pkg/x/claimsx"
+	"github.com/crossid/crossid-go/pkg/x/stringslice"
+	"github.com/golang-jwt/jwt/v4"
+	"time"
+)
+
+// PrincipalKind is the kind of the subject a token was issued to.
+type PrincipalKind int
+
+const (
+	// PrincipalUser is a token issued to an end user.
+	PrincipalUser PrincipalKind = iota
+	// PrincipalMachine is a token issued to a client acting on its own behalf (e.g., by the client credentials grant).
+	PrincipalMachine
+)
+
+func (k PrincipalKind) String() string {
+	switch k {
+	case PrincipalUser:
+		return "user"
+	case PrincipalMachine:
+		return "machine"
+	}
+	return fmt.Sprintf("PrincipalKind(%d)", int(k))
+}
+
+// Principal describes who is calling, as asserted by a verified token.
+type Principal struct {
+	// Subject is the "sub" claim.
+	Subject string
+	// ClientID is the client the token was issued to.
+	ClientID string
+	// Tenant is the tenant the token belongs to.
+	Tenant string
+	// Scopes are the granted scopes.
+	Scopes []string
+	// Roles are the roles of the subject.
+	Roles []string
+	// Email is the "email" claim.
+	Email string
+	// Name is the "name" claim.
+	Name string
+	// AuthTime is the time the end user authenticated, per the "auth_time" claim.
+	AuthTime time.Time
+	// AMR are the authentication methods, per the "amr" claim (e.g., "pwd", "mfa").
+	AMR []string
+	// ACR is the authentication context class reference, per the "acr" claim.
+	ACR string
+	// Kind is the kind of the subject.
+	Kind PrincipalKind
+	// Claims are the claims of the token, for claims that are not mapped.
+	Claims jwt.MapClaims
+
+	// token is the token the principal was mapped from
+	token *jwt.Token
+}
+
+// HasScope returns true if scope is granted.
+func (p *Principal) HasScope(scope string) bool {
+	return stringslice.IndexOf(p.Scopes, scope) > -1
+}
+
+// HasRole returns true if the subject has role.
+func (p *Principal) HasRole(role string) bool {
+	return stringslice.IndexOf(p.Roles, role) > -1
+}
+
+// IsMachine returns true if the token was issued to a client acting on its own behalf.
+func (p *Principal) IsMachine() bool {
+	return p.Kind == PrincipalMachine
+}
+
+// PrincipalMapper maps a verified token into a Principal.
+// ctx is the request's context, it holds the tenant if the JWT middleware runs with an IssuerResolver.
+// a mapper may return a nil principal, in which case no principal is put in context.
+type PrincipalMapper func(ctx context.Context, t *jwt.Token) (*Principal, error)
+
+// PrincipalMapperOpts describes the options of the mapper returned by NewPrincipalMapper
+type PrincipalMapperOpts struct {
+	// ClientIDClaims are the claims the client id is read from, the first present wins.
+	// defaults to "client_id", "azp" and "cid".
+	ClientIDClaims []string
+	// TenantClaims are the claims the tenant is read from when no tenant is in context, the first present wins.
+	// defaults to "tid" and "tenant".
+	TenantClaims []string
+	// RolesClaims are the claims the roles are collected from (e.g., "realm_access.roles", see ClaimValue).
+	// defaults to "roles".
+	RolesClaims []string
+	// ScopeFallback also reads the scopes from a space delimited ScopesClaim string, or from the space delimited
+	// "scope" claim if there is no ScopesClaim. by default scopes are read only from a ScopesClaim array,
+	// as DefaultClaimsFromToken does.
+	ScopeFallback bool
+	// Kind decides the kind of p, mapped from claims.
+	// defaults to PrincipalMachine if the token has no subject, its subject is the client id
+	// or it was issued by the client credentials grant ("gty" claim), otherwise PrincipalUser.
+	Kind func(p *Principal, claims jwt.MapClaims) PrincipalKind
+}
+
+func mergePrincipalMapperOpts(opts ...*PrincipalMapperOpts) *PrincipalMapperOpts {
+	opt := PrincipalMapperOpts{
+		ClientIDClaims: []string{"client_id", "azp", "cid"},
+		TenantClaims:   []string{"tid", "tenant"},
+		RolesClaims:    []string{"roles"},
+		Kind:           principalKind,
+	}
+
+	for _, o := range opts {
+		if o == nil {
+			continue
+		}
+		if o.ClientIDClaims != nil {
+			opt.ClientIDClaims = o.ClientIDClaims
+		}
+		if o.TenantClaims != nil {
+			opt.TenantClaims = o.TenantClaims
+		}
+		if o.RolesClaims != nil {
+			opt.RolesClaims = o.RolesClaims
+		}
+		if o.ScopeFallback {
+			opt.ScopeFallback = o.ScopeFallback
+		}
+		if o.Kind != nil {
+			opt.Kind = o.Kind
+		}
+	}
+
+	return &opt
+}
+
+// DefaultPrincipalMapper maps tokens using the defaults of PrincipalMapperOpts.
+var DefaultPrincipalMapper = NewPrincipalMapper()
+
+// NewPrincipalMapper returns a PrincipalMapper that maps the standard claims of a token.
+func NewPrincipalMapper(opts ...*PrincipalMapperOpts) PrincipalMapper {
+	o := mergePrincipalMapperOpts(opts...)
+	return func(ctx context.Context, t *jwt.Token) (*Principal, error) {
+		mc, err := rawClaims(t)
+		if err != nil {
+			return nil, err
+		}
+
+		p := &Principal{Claims: mc, token: t}
+		p.Subject, _ = mc["sub"].(string)
+		p.Email, _ = mc["email"].(string)
+		p.Name, _ = mc["name"].(string)
+		p.ACR, _ = mc["acr"].(string)
+		p.ClientID = firstStringClaim(mc, o.ClientIDClaims)
+		if o.ScopeFallback {
+			p.Scopes, _ = scopesFromClaims(mc)
+		} else {
+			p.Scopes = scopesArray(mc)
+		}
+		p.AMR = stringClaims(mc["amr"])
+		if at, ok := claimsx.NumericDate(mc["auth_time"]); ok {
+			p.AuthTime = at
+		}
+
+		if tenant, ok := TenantFromContext(ctx); ok && tenant.ID != "" {
+			p.Tenant = tenant.ID
+		} else {
+			p.Tenant = firstStringClaim(mc, o.TenantClaims)
+		}
+
+		for _, path := range o.RolesClaims {
+			if v, ok := ClaimValue(mc, path); ok {
+				p.Roles = append(p.Roles, stringClaims(v)...)
+			}
+		}
+
+		p.Kind = o.Kind(p, mc)
+
+		return p, nil
+	}
+}
+
+// principalKind is the default of PrincipalMapperOpts.Kind
+func principalKind(p *Principal, claims jwt.MapClaims) PrincipalKind {
+	if gty, _ := claims["gty"].(string); gty == "client-credentials" || gty == "client_credentials" {
+		return PrincipalMachine
+	}
+	if p.Subject == "" || p.ClientID != "" && p.Subject == p.ClientID {
+		return PrincipalMachine
+	}
+	return PrincipalUser
+}
+
+// firstStringClaim returns the first non empty string claim at paths.
+func firstStringClaim(mc jwt.MapClaims, paths []string) string {
+	for _, path := range paths {
+		if v, ok := ClaimValue(mc, path); ok {
+			if s, ok := v.(string); ok && s != "" {
+				return s
+			}
+		}
+	}
+	return ""
+}
+
+// scopesArray returns the scopes of the ScopesClaim if it is an array.
+func scopesArray(mc jwt.MapClaims) []string {
+	switch mc[ScopesClaim].(type) {
+	case []string, []interface{}:
+		scopes, _ := scopesFromClaims(mc)
+		return scopes
+	}
+	return nil
+}
+
+// stringClaims returns the strings of a claim that is either an array or a space delimited string.
+func stringClaims(v interface{}) []string {
+	var strs []string
+	for _, e := range claimElements(v) {
+		if s, ok := e.(string); ok {
+			strs = append(strs, s)
+		}
+	}
+	return strs
+}
+
+// PrincipalFromContext returns the principal put in context by the JWT middleware.
+func PrincipalFromContext(ctx context.Context) (*Principal, bool) {
+	p, ok := ctx.Value(principalCtxKey).(*Principal)
+	return p, ok && p != nil
+}
+
+// PrincipalOf returns the principal of t, either the one put in context by the JWT middleware if it was mapped
+// from t or one newly mapped by DefaultPrincipalMapper.
+// unlike PrincipalFromContext, a principal of another token in context is never returned.
+func PrincipalOf(ctx context.Context, t *jwt.Token) (*Principal, error) {
+	if p, ok := PrincipalFromContext(ctx); ok && p.token == t {
+		return p, nil
+	}
+	return DefaultPrincipalMapper(ctx, t)
+}
@@ -0,0 +1,190 @@
+package jwtmw
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/crossid/crossid-go/pkg/x/testx"
+	"github.com/golang-jwt/jwt/v4"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNewPrincipalMapper(t *testing.T) {
+	authTime := time.Unix(1700000000, 0)
+	for k, tc := range []struct {
+		claims jwt.MapClaims
+		opts   *PrincipalMapperOpts
+		ctx    context.Context
+		assert func(p *Principal)
+	}{
+		{
+			claims: jwt.MapClaims{
+				"sub":       "alice",
+				"azp":       "app",
+				"tid":       "acme",
+				"scp":       []interface{}{"read", "write"},
+				"roles":     []interface{}{"admin"},
+				"email":     "alice@acme.io",
+				"name":      "Alice",
+				"auth_time": float64(authTime.Unix()),
+				"amr":       []interface{}{"pwd", "mfa"},
+				"acr":       "urn:acr:2fa",
+			},
+			assert: func(p *Principal) {
+				testx.AssertTrue(t, p.Subject == "alice" && p.ClientID == "app" && p.Tenant == "acme", "unexpected identity")
+				testx.AssertTrue(t, p.HasScope("write") && p.HasRole("admin") && !p.HasRole("read"), "unexpected scopes or roles")
+				testx.AssertTrue(t, p.Email == "alice@acme.io" && p.Name == "Alice", "unexpected profile")
+				testx.AssertTrue(t, p.AuthTime.Equal(authTime), "unexpected auth time")
+				testx.AssertTrue(t, strings.Join(p.AMR, " ") == "pwd mfa" && p.ACR == "urn:acr:2fa", "unexpected amr or acr")
+				testx.AssertTrue(t, p.Kind == PrincipalUser && !p.IsMachine(), "expected a user")
+			},
+		},
+		{
+			claims: jwt.MapClaims{"sub": "svc", "client_id": "svc", "scope": "read write"},
+			assert: func(p *Principal) {
+				testx.AssertTrue(t, p.IsMachine(), "expected a machine when subject is the client")
+				testx.AssertTrue(t, len(p.Scopes) == 0, "expected the scope claim to be ignored by default")
+			},
+		},
+		{
+			claims: jwt.MapClaims{"sub": "svc", "scope": "read write"},
+			opts:   &PrincipalMapperOpts{ScopeFallback: true},
+			assert: func(p *Principal) {
+				testx.AssertTrue(t, strings.Join(p.Scopes, " ") == "read write", "expected scopes of the scope claim")
+			},
+		},
+		{
+			claims: jwt.MapClaims{"sub": "svc", "scp": "read write"},
+			assert: func(p *Principal) {
+				testx.AssertTrue(t, len(p.Scopes) == 0, "expected a space delimited scp to be ignored by default")
+			},
+		},
+		{
+			claims: jwt.MapClaims{"sub": "svc@clients", "gty": "client-credentials"},
+			assert: func(p *Principal) {
+				testx.AssertTrue(t, p.IsMachine(), "expected a machine when issued by client credentials")
+			},
+		},
+		{
+			claims: jwt.MapClaims{"sub": "alice", "tid": "acme"},
+			ctx:    context.WithValue(context.Background(), tenantCtxKey{}, &Tenant{Issuer: "https://other.io", ID: "other"}),
+			assert: func(p *Principal) {
+				testx.AssertTrue(t, p.Tenant == "other", "expected the resolved tenant to win")
+			},
+		},
+		{
+			claims: jwt.MapClaims{"sub": "alice", "realm_access": map[string]interface{}{"roles": []interface{}{"viewer"}}, "groups": "a b"},
+			opts:   &PrincipalMapperOpts{RolesClaims: []string{"realm_access.roles", "groups"}},
+			assert: func(p *Principal) {
+				testx.AssertTrue(t, strings.Join(p.Roles, " ") == "viewer a b", fmt.Sprintf("unexpected roles %v", p.Roles))
+			},
+		},
+		{
+			claims: jwt.MapClaims{"sub": "alice"},
+			opts: &PrincipalMapperOpts{Kind: func(p *Principal, claims jwt.MapClaims) PrincipalKind {
+				return PrincipalMachine
+			}},
+			assert: func(p *Principal) {
+				testx.AssertTrue(t, p.IsMachine(), "expected a custom kind")
+			},
+		},
+	} {
+		t.Run(fmt.Sprintf("case=%d", k), func(t *testing.T) {
+			ctx := tc.ctx
+			if ctx == nil {
+				ctx = context.Background()
+			}
+			p, err := NewPrincipalMapper(tc.opts)(ctx, &jwt.Token{Claims: tc.claims})
+			testx.AssertNoError(t, err)
+			tc.assert(p)
+		})
+	}
+}
+
+func TestJWT_Handler_Principal(t *testing.T) {
+	tok := signHS256JWT(t, claimsWithScopes{
+		StandardClaims: jwt.StandardClaims{Subject: "alice"},
+		Scopes:         []string{"foo", "bar"},
+	})
+
+	for k, tc := range []struct {
+		opts   *JwtMiddlewareOpts
+		mw     func(next http.Handler) http.Handler
+		status int
+	}{
+		// scopes are taken from the principal, regardless of the claims type
+		{opts: &JwtMiddlewareOpts{Claims: &claimsWithScopes{}}, mw: WithScopesCustom([]string{"foo"}, WithPrincipalScopes()), status: http.StatusOK},
+		{opts: &JwtMiddlewareOpts{Claims: &claimsWithScopes{}}, mw: WithScopesCustom([]string{"baz"}, WithPrincipalScopes()), status: http.StatusForbidden},
+		{
+			opts: &JwtMiddlewareOpts{PrincipalMapper: func(ctx context.Context, t *jwt.Token) (*Principal, error) {
+				return nil, errors.New("no such user")
+			}},
+			status: http.StatusUnauthorized,
+		},
+		{
+			opts: &JwtMiddlewareOpts{PrincipalMapper: func(ctx context.Context, t *jwt.Token) (*Principal, error) {
+				return &Principal{Subject: "bob", Scopes: []string{"baz"}}, nil
+			}},
+			mw:     WithScopesCustom([]string{"baz"}, WithPrincipalScopes()),
+			status: http.StatusOK,
+		},
+	} {
+		t.Run(fmt.Sprintf("case=%d", k), func(t *testing.T) {
+			var got *Principal
+			var h http.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				got, _ = PrincipalFromContext(r.Context())
+			})
+			if tc.mw != nil {
+				h = tc.mw(h)
+			}
+			h = NewJWT(&JwtMiddlewareOpts{KeyFunc: validKeyFuncHS256}, tc.opts).Handler(h)
+
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.Header.Set(BearerHeaderKey, tok)
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+
+			testx.AssertTrue(t, w.Code == tc.status, fmt.Sprintf("expected status %d, got %d", tc.status, w.Code))
+			if tc.status == http.StatusOK {
+				testx.AssertTrue(t, got != nil && got.Subject != "", "expected a principal in context")
+			}
+		})
+	}
+}
+
+func TestWithScopes_ScopeClaim(t *testing.T) {
+	tok := signHS256JWT(t, jwt.MapClaims{"sub": "alice", "scope": "admin"})
+
+	for k, tc := range []struct {
+		opts   *JwtMiddlewareOpts
+		mw     func(next http.Handler) http.Handler
+		status int
+	}{
+		// the "scope" claim does not grant scopes by default
+		{mw: WithScopes("admin"), status: http.StatusForbidden},
+		{mw: WithScopesCustom([]string{"admin"}, WithPrincipalScopes()), status: http.StatusForbidden},
+		{
+			opts:   &JwtMiddlewareOpts{PrincipalMapper: NewPrincipalMapper(&PrincipalMapperOpts{ScopeFallback: true})},
+			mw:     WithScopes("admin"),
+			status: http.StatusForbidden,
+		},
+		{
+			opts:   &JwtMiddlewareOpts{PrincipalMapper: NewPrincipalMapper(&PrincipalMapperOpts{ScopeFallback: true})},
+			mw:     WithScopesCustom([]string{"admin"}, WithPrincipalScopes()),
+			status: http.StatusOK,
+		},
+	} {
+		t.Run(fmt.Sprintf("case=%d", k), func(t *testing.T) {
+			h := NewJWT(&JwtMiddlewareOpts{KeyFunc: validKeyFuncHS256}, tc.opts).Handler(tc.mw(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})))
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.Header.Set(BearerHeaderKey, tok)
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+			testx.AssertTrue(t, w.Code == tc.status, fmt.Sprintf("expected status %d, got %d", tc.status, w.Code))
+		})
+	}
+}
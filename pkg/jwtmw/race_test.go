@@ -0,0 +1,107 @@
+package jwtmw
+
+import (
+	"fmt"
+	"github.com/crossid/crossid-go/pkg/x/testx"
+	"github.com/golang-jwt/jwt/v4"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestClaimsFactoryOf(t *testing.T) {
+	p := &claimsWithScopes{Scopes: []string{"foo"}}
+	f := ClaimsFactoryOf(p)
+	a, b := f().(*claimsWithScopes), f().(*claimsWithScopes)
+	testx.AssertTrue(t, a != p && a != b, "expected a new value per call")
+	testx.AssertTrue(t, a.Scopes == nil, "expected a zero value")
+
+	m := ClaimsFactoryOf(jwt.MapClaims{"foo": "bar"})().(jwt.MapClaims)
+	testx.AssertTrue(t, len(m) == 0, "expected an empty map")
+
+	_, ok := ClaimsFactoryOf(nil)().(jwt.MapClaims)
+	testx.AssertTrue(t, ok, "expected jwt.MapClaims by default")
+}
+
+// TestJWT_Handler_Concurrent serves distinct tokens in parallel, each request must see the claims of its own token.
+// it is meant to run with the race detector, go test -race.
+func TestJWT_Handler_Concurrent(t *testing.T) {
+	for k, tc := range []struct {
+		opts    *JwtMiddlewareOpts
+		subject func(r *http.Request) string
+	}{
+		{
+			opts: &JwtMiddlewareOpts{Claims: &claimsWithScopes{}},
+			subject: func(r *http.Request) string {
+				tok, _ := TokenFromContext(r.Context())
+				return tok.Claims.(*claimsWithScopes).Subject
+			},
+		},
+		{
+			opts: &JwtMiddlewareOpts{ClaimsFactory: func() jwt.Claims { return &jwt.RegisteredClaims{} }},
+			subject: func(r *http.Request) string {
+				tok, _ := TokenFromContext(r.Context())
+				return tok.Claims.(*jwt.RegisteredClaims).Subject
+			},
+		},
+		{
+			opts: &JwtMiddlewareOpts{
+				RevocationStore: NewMemoryRevocationStore(),
+				Leeway:          time.Second,
+				Audiences:       []string{"acme.io"},
+			},
+			subject: func(r *http.Request) string {
+				p, _ := PrincipalFromContext(r.Context())
+				return p.Subject
+			},
+		},
+		{
+			opts: &JwtMiddlewareOpts{TokenCache: NewTokenCache(&TokenCacheOpts{Size: 8})},
+			subject: func(r *http.Request) string {
+				sub, _ := SubjectFromContext(r.Context())
+				return sub
+			},
+		},
+	} {
+		t.Run(fmt.Sprintf("case=%d", k), func(t *testing.T) {
+			mw := NewJWT(&JwtMiddlewareOpts{KeyFunc: validKeyFuncHS256}, tc.opts)
+			h := mw.Handler(WithScopesCustom([]string{"foo"}, WithPrincipalScopes())(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				_, _ = w.Write([]byte(tc.subject(r)))
+			})))
+
+			const n = 32
+			var wg sync.WaitGroup
+			errs := make(chan error, n)
+			for i := 0; i < n; i++ {
+				sub := fmt.Sprintf("user-%d", i)
+				tok := signHS256JWT(t, claimsWithScopes{
+					StandardClaims: jwt.StandardClaims{Subject: sub, Audience: "acme.io", IssuedAt: time.Now().Unix()},
+					Scopes:         []string{"foo"},
+				})
+
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					for j := 0; j < 20; j++ {
+						r := httptest.NewRequest(http.MethodGet, "/", nil)
+						r.Header.Set(BearerHeaderKey, tok)
+						w := httptest.NewRecorder()
+						h.ServeHTTP(w, r)
+						if w.Code != http.StatusOK || w.Body.String() != sub {
+							errs <- fmt.Errorf("expected 200 %s but got %d %s", sub, w.Code, w.Body.String())
+							return
+						}
+					}
+				}()
+			}
+			wg.Wait()
+			close(errs)
+
+			for err := range errs {
+				t.Error(err)
+			}
+		})
+	}
+}
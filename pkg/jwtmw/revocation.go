@@ -0,0 +1,144 @@
+package jwtmw
+
+import (
+	"context"
+	"fmt"
+	"github.com/crossid/crossid-go/pkg/x/claimsx"
+	"github.com/golang-jwt/jwt/v4"
+	"sync"
+	"time"
+)
+
+// RevocationClaims are the claims of a token that a revocation is keyed by.
+type RevocationClaims struct {
+	// ID is the "jti" claim.
+	ID string
+	// SessionID is the "sid" claim.
+	SessionID string
+	// Subject is the "sub" claim.
+	Subject string
+	// IssuedAt is the "iat" claim, zero if missing.
+	IssuedAt time.Time
+}
+
+// RevocationStore tells whether a token was revoked.
+// implementations must be safe for concurrent use.
+type RevocationStore interface {
+	// IsRevoked returns true if a token with the given claims is revoked.
+	// an error rejects the token.
+	IsRevoked(ctx context.Context, c *RevocationClaims) (bool, error)
+}
+
+// MemoryRevocationStore is an in-memory RevocationStore where every revocation expires after a TTL.
+// the TTL should be at least the lifetime of the revoked tokens, after which they are rejected by their "exp" anyway.
+type MemoryRevocationStore struct {
+	mu       sync.RWMutex
+	ids      map[string]time.Time
+	sessions map[string]time.Time
+	subjects map[string]*subjectRevocation
+}
+
+// subjectRevocation revokes tokens of a subject issued at or before cutoff.
+type subjectRevocation struct {
+	cutoff    time.Time
+	expiresAt time.Time
+}
+
+// NewMemoryRevocationStore returns a new MemoryRevocationStore.
+func NewMemoryRevocationStore() *MemoryRevocationStore {
+	return &MemoryRevocationStore{
+		ids:      map[string]time.Time{},
+		sessions: map[string]time.Time{},
+		subjects: map[string]*subjectRevocation{},
+	}
+}
+
+// RevokeID revokes the token whose "jti" claim is id for ttl.
+func (s *MemoryRevocationStore) RevokeID(id string, ttl time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sweep(time.Now())
+	s.ids[id] = time.Now().Add(ttl)
+}
+
+// RevokeSession revokes all tokens whose "sid" claim is sid for ttl.
+func (s *MemoryRevocationStore) RevokeSession(sid string, ttl time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sweep(time.Now())
+	s.sessions[sid] = time.Now().Add(ttl)
+}
+
+// RevokeSubject revokes all tokens of sub issued at or before cutoff for ttl.
+// tokens of sub without an "iat" claim are revoked as well.
+func (s *MemoryRevocationStore) RevokeSubject(sub string, cutoff time.Time, ttl time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sweep(time.Now())
+	s.subjects[sub] = &subjectRevocation{cutoff: cutoff, expiresAt: time.Now().Add(ttl)}
+}
+
+// IsRevoked implements RevocationStore.
+func (s *MemoryRevocationStore) IsRevoked(_ context.Context, c *RevocationClaims) (bool, error) {
+	now := time.Now()
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if exp, ok := s.ids[c.ID]; ok && c.ID != "" && now.Before(exp) {
+		return true, nil
+	}
+	if exp, ok := s.sessions[c.SessionID]; ok && c.SessionID != "" && now.Before(exp) {
+		return true, nil
+	}
+	if sr, ok := s.subjects[c.Subject]; ok && c.Subject != "" && now.Before(sr.expiresAt) {
+		if c.IssuedAt.IsZero() || !c.IssuedAt.After(sr.cutoff) {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// sweep removes expired revocations, s.mu must be held.
+func (s *MemoryRevocationStore) sweep(now time.Time) {
+	for k, exp := range s.ids {
+		if !now.Before(exp) {
+			delete(s.ids, k)
+		}
+	}
+	for k, exp := range s.sessions {
+		if !now.Before(exp) {
+			delete(s.sessions, k)
+		}
+	}
+	for k, sr := range s.subjects {
+		if !now.Before(sr.expiresAt) {
+			delete(s.subjects, k)
+		}
+	}
+}
+
+// verifyRevocation rejects t if it was revoked according to the revocation store.
+func (j *JWT) verifyRevocation(ctx context.Context, t *jwt.Token) error {
+	mc, err := rawClaims(t)
+	if err != nil {
+		return newValidationError(ReasonClaims, "", err)
+	}
+
+	c := &RevocationClaims{}
+	c.ID, _ = mc["jti"].(string)
+	c.SessionID, _ = mc["sid"].(string)
+	c.Subject, _ = mc["sub"].(string)
+	c.IssuedAt, _ = claimsx.NumericDate(mc["iat"])
+
+	revoked, err := j.opts.RevocationStore.IsRevoked(ctx, c)
+	if err != nil {
+		return newValidationError(ReasonRevocationCheck, "", err)
+	}
+	if revoked {
+		return newValidationError(ReasonRevoked, "", fmt.Errorf("token is revoked"))
+	}
+
+	return nil
+}
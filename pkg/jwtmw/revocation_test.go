@@ -0,0 +1,96 @@
+package jwtmw
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/crossid/crossid-go/pkg/x/testx"
+	"github.com/golang-jwt/jwt/v4"
+	"net/http"
+	"testing"
+	"time"
+)
+
+type failingRevocationStore struct{}
+
+func (failingRevocationStore) IsRevoked(context.Context, *RevocationClaims) (bool, error) {
+	return false, fmt.Errorf("store is down")
+}
+
+func TestJWT_ValidateWithRevocationStore(t *testing.T) {
+	now := time.Now()
+	s := NewMemoryRevocationStore()
+	s.RevokeID("revoked-jti", time.Hour)
+	s.RevokeSession("revoked-sid", time.Hour)
+	s.RevokeSubject("alice", now.Add(-time.Minute), time.Hour)
+	s.RevokeID("expired-jti", -time.Second)
+
+	for k, tc := range []struct {
+		name   string
+		store  RevocationStore
+		claims jwt.MapClaims
+		reason Reason
+	}{
+		{
+			name:   "not revoked",
+			store:  s,
+			claims: jwt.MapClaims{"jti": "1", "sid": "2", "sub": "bob"},
+		},
+		{
+			name:   "revoked by jti",
+			store:  s,
+			claims: jwt.MapClaims{"jti": "revoked-jti"},
+			reason: ReasonRevoked,
+		},
+		{
+			name:   "revoked by sid",
+			store:  s,
+			claims: jwt.MapClaims{"jti": "1", "sid": "revoked-sid"},
+			reason: ReasonRevoked,
+		},
+		{
+			name:   "subject issued before cutoff",
+			store:  s,
+			claims: jwt.MapClaims{"sub": "alice", "iat": now.Add(-time.Hour).Unix()},
+			reason: ReasonRevoked,
+		},
+		{
+			name:   "subject without iat",
+			store:  s,
+			claims: jwt.MapClaims{"sub": "alice"},
+			reason: ReasonRevoked,
+		},
+		{
+			name:   "subject issued after cutoff",
+			store:  s,
+			claims: jwt.MapClaims{"sub": "alice", "iat": now.Unix()},
+		},
+		{
+			name:   "expired revocation",
+			store:  s,
+			claims: jwt.MapClaims{"jti": "expired-jti"},
+		},
+		{
+			name:   "store failure",
+			store:  failingRevocationStore{},
+			claims: jwt.MapClaims{},
+			reason: ReasonRevocationCheck,
+		},
+	} {
+		t.Run(fmt.Sprintf("case=%d", k), func(t *testing.T) {
+			r, err := http.NewRequest(http.MethodGet, "/", nil)
+			testx.AssertNoError(t, err)
+			r.Header.Set(BearerHeaderKey, signHS256JWT(t, tc.claims))
+
+			_, err = NewJWT(&JwtMiddlewareOpts{KeyFunc: validKeyFuncHS256, RevocationStore: tc.store}).Validate(r)
+			if tc.reason == "" {
+				testx.AssertNoError(t, err)
+				return
+			}
+
+			var ve *ValidationError
+			testx.AssertTrue(t, errors.Is(err, ErrInvalidToken), "expected error to be ErrInvalidToken")
+			testx.AssertTrue(t, errors.As(err, &ve) && ve.Reason == tc.reason, fmt.Sprintf("expected reason %s but got %v", tc.reason, err))
+		})
+	}
+}
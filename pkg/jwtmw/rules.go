@@ -0,0 +1,114 @@
+package jwtmw
+
+import (
+	"context"
+	"fmt"
+	"github.com/crossid/crossid-go/pkg/x/stringslice"
+	"github.com/golang-jwt/jwt/v4"
+	"net/http"
+)
+
+// Effect is the effect of a matching rule.
+type Effect int
+
+const (
+	Allow Effect = iota
+	Deny
+)
+
+// RuleInput is the input of a rule condition.
+type RuleInput struct {
+	Token     *jwt.Token
+	Claims    jwt.MapClaims
+	Principal *Principal
+	Request   *http.Request
+	Resource  *Resource
+}
+
+// Rule allows or denies the requests it matches.
+type Rule struct {
+	// Name identifies the rule, it is the reason of decisions made by it.
+	Name string
+	// ResourceType, if set, restricts the rule to resources of the given type.
+	ResourceType string
+	// Actions, if set, restrict the rule to the given actions.
+	Actions []string
+	// Condition, if set, must hold for the rule to match.
+	Condition func(in *RuleInput) bool
+	// Effect is the effect of the rule when matched, Allow by default.
+	Effect Effect
+}
+
+func (r *Rule) matches(in *RuleInput) bool {
+	if r.ResourceType != "" && r.ResourceType != in.Resource.Type {
+		return false
+	}
+	if len(r.Actions) > 0 && stringslice.IndexOf(r.Actions, in.Resource.Action) == -1 {
+		return false
+	}
+	return r.Condition == nil || r.Condition(in)
+}
+
+// RuleAuthorizer is an in-process Authorizer that evaluates rules.
+// A request is denied if any matching rule denies it, otherwise allowed if any matching rule allows it,
+// requests that match no rule are denied.
+type RuleAuthorizer struct {
+	rules []Rule
+}
+
+// NewRuleAuthorizer returns a new RuleAuthorizer of rules.
+func NewRuleAuthorizer(rules ...Rule) *RuleAuthorizer {
+	return &RuleAuthorizer{rules: rules}
+}
+
+// Authorize implements Authorizer.
+func (a *RuleAuthorizer) Authorize(ctx context.Context, t *jwt.Token, r *http.Request, res *Resource) (*Decision, error) {
+	p, err := PrincipalOf(ctx, t)
+	if err != nil {
+		return nil, err
+	}
+	if res == nil {
+		res = &Resource{}
+	}
+
+	in := &RuleInput{Token: t, Claims: p.Claims, Principal: p, Request: r, Resource: res}
+	var allowed *Rule
+	for k := range a.rules {
+		rule := &a.rules[k]
+		if !rule.matches(in) {
+			continue
+		}
+		if rule.Effect == Deny {
+			return &Decision{Allowed: false, Reason: fmt.Sprintf("denied by rule '%s'", rule.Name)}, nil
+		}
+		if allowed == nil {
+			allowed = rule
+		}
+	}
+
+	if allowed != nil {
+		return &Decision{Allowed: true, Reason: fmt.Sprintf("allowed by rule '%s'", allowed.Name)}, nil
+	}
+
+	return &Decision{Allowed: false, Reason: "no rule matched"}, nil
+}
+
+// ClaimsCondition is a rule condition that requires the claims to satisfy p.
+func ClaimsCondition(p ClaimPredicate) func(in *RuleInput) bool {
+	return func(in *RuleInput) bool {
+		return p.Check(in.Claims) == nil
+	}
+}
+
+// ClaimEqualsAttribute is a rule condition that requires the claim at path to equal the resource attribute attr,
+// e.g., ClaimEqualsAttribute("sub", "owner") allows users to access only the resources they own.
+func ClaimEqualsAttribute(path, attr string) func(in *RuleInput) bool {
+	return func(in *RuleInput) bool {
+		v, ok := ClaimValue(in.Claims, path)
+		if !ok {
+			return false
+		}
+		a, ok := in.Resource.Attributes[attr]
+		return ok && claimEquals(v, a)
+	}
+}
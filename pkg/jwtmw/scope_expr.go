@@ -0,0 +1,368 @@
+package jwtmw
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// ScopeExpr is a compiled boolean expression of scopes, e.g., "admin or (orders:read and orders:write)".
+//
+// The operators, in ascending precedence, are "or" (also "||"), "and" (also "&&") and "not" (also "!"),
+// parentheses group sub-expressions, any other word is a scope. Keywords are case-insensitive.
+type ScopeExpr struct {
+	src  string
+	root scopeNode
+}
+
+// ParseScopeExpr compiles the expression s.
+func ParseScopeExpr(s string) (*ScopeExpr, error) {
+	p := &scopeParser{tokens: tokenizeScopeExpr(s)}
+	if len(p.tokens) == 0 {
+		return nil, fmt.Errorf("empty scope expression")
+	}
+
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.tokens) {
+		return nil, fmt.Errorf("unexpected '%s' at token %d", p.tokens[p.pos], p.pos+1)
+	}
+
+	return &ScopeExpr{src: s, root: root}, nil
+}
+
+// MustParseScopeExpr is like ParseScopeExpr but panics if s cannot be parsed.
+func MustParseScopeExpr(s string) *ScopeExpr {
+	e, err := ParseScopeExpr(s)
+	if err != nil {
+		panic(fmt.Sprintf("invalid scope expression '%s': %s", s, err))
+	}
+	return e
+}
+
+func (e *ScopeExpr) String() string {
+	return e.src
+}
+
+// Scopes returns the scopes that are required, not negated, by e.
+func (e *ScopeExpr) Scopes() []string {
+	var scopes []string
+	e.root.scopes(&scopes, false)
+	return scopes
+}
+
+// Check returns nil if granted satisfies e, otherwise a *ScopeExprError that reports the unmet sub-expression.
+func (e *ScopeExpr) Check(granted []string) error {
+	set := make(map[string]struct{}, len(granted))
+	for _, s := range granted {
+		set[s] = struct{}{}
+	}
+
+	return e.check(func(s string) bool {
+		_, ok := set[s]
+		return ok
+	})
+}
+
+// CheckWith is like Check but matches scopes using m.
+func (e *ScopeExpr) CheckWith(m *ScopeMatcher, granted []string) error {
+	return e.check(func(s string) bool {
+		return m.Contains(granted, s)
+	})
+}
+
+func (e *ScopeExpr) check(has func(string) bool) error {
+	if unmet := e.root.unmet(has); unmet != nil {
+		return &ScopeExprError{Expr: e.src, Unmet: unmet.String(), Scopes: unmet.grantable()}
+	}
+
+	return nil
+}
+
+// checker adapts e to a ScopesCheckerFunc, required is ignored.
+func (e *ScopeExpr) checker(m *ScopeMatcher) ScopesCheckerFunc {
+	return func(_ context.Context, _ []string, candidates []string) error {
+		if m != nil {
+			return e.CheckWith(m, candidates)
+		}
+		return e.Check(candidates)
+	}
+}
+
+// ScopeExprError is returned when a scope expression is not satisfied.
+type ScopeExprError struct {
+	// Expr is the expression.
+	Expr string
+	// Unmet is the sub-expression that is not satisfied (e.g., "orders:write").
+	Unmet string
+	// Scopes are the scopes that satisfy Unmet once granted, empty if Unmet is not satisfied by a single set of scopes,
+	// e.g., alternatives or a negation.
+	Scopes []string
+}
+
+func (e *ScopeExprError) Error() string {
+	return fmt.Sprintf("scope expression '%s' is not satisfied, unmet '%s'", e.Expr, e.Unmet)
+}
+
+// WithScopesExpr requires the token's scopes to satisfy the scope expression expr (see ScopeExpr).
+// expr is compiled once, it panics if expr is invalid. Scopes are matched by the WithScopeMatcher option, if given.
+// the insufficient scope error reports the scopes of the unmet sub-expression, see ScopeExprError.
+func WithScopesExpr(expr string, opt ...WithScopesOpt) func(next http.Handler) http.Handler {
+	e := MustParseScopeExpr(expr)
+	opts := newWithScopesOpts(opt)
+	return withScopes(nil, e.checker(opts.ScopeMatcher), opts)
+}
+
+// scopeNode is a node of a parsed scope expression
+type scopeNode interface {
+	// unmet returns nil if the node is satisfied, otherwise the unmet (sub) node.
+	unmet(has func(string) bool) scopeNode
+	scopes(dst *[]string, negated bool)
+	// grantable returns the scopes that satisfy the node once granted, nil if there is no single such set.
+	grantable() []string
+	String() string
+}
+
+type scopeLeaf string
+
+func (n scopeLeaf) unmet(has func(string) bool) scopeNode {
+	if has(string(n)) {
+		return nil
+	}
+	return n
+}
+
+func (n scopeLeaf) scopes(dst *[]string, negated bool) {
+	if !negated {
+		*dst = append(*dst, string(n))
+	}
+}
+
+func (n scopeLeaf) grantable() []string {
+	return []string{string(n)}
+}
+
+func (n scopeLeaf) String() string {
+	return string(n)
+}
+
+type scopeNot struct {
+	x scopeNode
+}
+
+func (n *scopeNot) unmet(has func(string) bool) scopeNode {
+	if n.x.unmet(has) == nil {
+		return n
+	}
+	return nil
+}
+
+func (n *scopeNot) scopes(dst *[]string, negated bool) {
+	n.x.scopes(dst, !negated)
+}
+
+func (n *scopeNot) grantable() []string {
+	return nil
+}
+
+func (n *scopeNot) String() string {
+	return "not " + n.x.String()
+}
+
+type scopeAnd struct {
+	x []scopeNode
+}
+
+func (n *scopeAnd) unmet(has func(string) bool) scopeNode {
+	for _, x := range n.x {
+		if u := x.unmet(has); u != nil {
+			return u
+		}
+	}
+	return nil
+}
+
+func (n *scopeAnd) scopes(dst *[]string, negated bool) {
+	for _, x := range n.x {
+		x.scopes(dst, negated)
+	}
+}
+
+func (n *scopeAnd) grantable() []string {
+	var scopes []string
+	for _, x := range n.x {
+		g := x.grantable()
+		if g == nil {
+			return nil
+		}
+		scopes = append(scopes, g...)
+	}
+	return scopes
+}
+
+func (n *scopeAnd) String() string {
+	return "(" + joinScopeNodes(n.x, " and ") + ")"
+}
+
+type scopeOr struct {
+	x []scopeNode
+}
+
+func (n *scopeOr) unmet(has func(string) bool) scopeNode {
+	for _, x := range n.x {
+		if x.unmet(has) == nil {
+			return nil
+		}
+	}
+	return n
+}
+
+func (n *scopeOr) scopes(dst *[]string, negated bool) {
+	for _, x := range n.x {
+		x.scopes(dst, negated)
+	}
+}
+
+// grantable of alternatives is nil, as any of the alternatives would satisfy the node.
+func (n *scopeOr) grantable() []string {
+	return nil
+}
+
+func (n *scopeOr) String() string {
+	return "(" + joinScopeNodes(n.x, " or ") + ")"
+}
+
+func joinScopeNodes(nodes []scopeNode, sep string) string {
+	s := make([]string, len(nodes))
+	for k, n := range nodes {
+		s[k] = n.String()
+	}
+	return strings.Join(s, sep)
+}
+
+// tokenizeScopeExpr splits s into parentheses, operators and words
+func tokenizeScopeExpr(s string) []string {
+	var tokens []string
+	for i := 0; i < len(s); {
+		switch c := s[i]; {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '(' || c == ')' || c == '!':
+			tokens = append(tokens, s[i:i+1])
+			i++
+		case strings.HasPrefix(s[i:], "&&") || strings.HasPrefix(s[i:], "||"):
+			tokens = append(tokens, s[i:i+2])
+			i += 2
+		default:
+			j := i
+			for j < len(s) && !strings.ContainsRune(" \t\n\r()!", rune(s[j])) && !strings.HasPrefix(s[j:], "&&") && !strings.HasPrefix(s[j:], "||") {
+				j++
+			}
+			tokens = append(tokens, s[i:j])
+			i = j
+		}
+	}
+	return tokens
+}
+
+// scopeParser is a recursive descent parser of scope expressions
+type scopeParser struct {
+	tokens []string
+	pos    int
+}
+
+func (p *scopeParser) peek() string {
+	if p.pos < len(p.tokens) {
+		return p.tokens[p.pos]
+	}
+	return ""
+}
+
+func (p *scopeParser) accept(ops ...string) bool {
+	t := p.peek()
+	for _, op := range ops {
+		if strings.EqualFold(t, op) {
+			p.pos++
+			return true
+		}
+	}
+	return false
+}
+
+func (p *scopeParser) parseOr() (scopeNode, error) {
+	x, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+
+	nodes := []scopeNode{x}
+	for p.accept("or", "||") {
+		if x, err = p.parseAnd(); err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, x)
+	}
+
+	if len(nodes) == 1 {
+		return nodes[0], nil
+	}
+	return &scopeOr{x: nodes}, nil
+}
+
+func (p *scopeParser) parseAnd() (scopeNode, error) {
+	x, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+
+	nodes := []scopeNode{x}
+	for p.accept("and", "&&") {
+		if x, err = p.parseUnary(); err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, x)
+	}
+
+	if len(nodes) == 1 {
+		return nodes[0], nil
+	}
+	return &scopeAnd{x: nodes}, nil
+}
+
+func (p *scopeParser) parseUnary() (scopeNode, error) {
+	if p.accept("not", "!") {
+		x, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &scopeNot{x: x}, nil
+	}
+
+	return p.parsePrimary()
+}
+
+func (p *scopeParser) parsePrimary() (scopeNode, error) {
+	t := p.peek()
+	switch {
+	case t == "":
+		return nil, fmt.Errorf("unexpected end of expression")
+	case t == "(":
+		p.pos++
+		x, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if !p.accept(")") {
+			return nil, fmt.Errorf("missing ')' at token %d", p.pos+1)
+		}
+		return x, nil
+	case t == ")" || t == "&&" || t == "||" || strings.EqualFold(t, "and") || strings.EqualFold(t, "or"):
+		return nil, fmt.Errorf("unexpected '%s' at token %d", t, p.pos+1)
+	}
+
+	p.pos++
+	return scopeLeaf(t), nil
+}
@@ -0,0 +1,152 @@
+package jwtmw
+
+import (
+	"errors"
+	"fmt"
+	"github.com/crossid/crossid-go/pkg/x/testx"
+	"github.com/golang-jwt/jwt/v4"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestScopeExpr_Check(t *testing.T) {
+	for k, tc := range []struct {
+		expr    string
+		granted []string
+		unmet   string
+	}{
+		{expr: "a", granted: []string{"a"}},
+		{expr: "a", granted: []string{"b"}, unmet: "a"},
+		{expr: "a and b", granted: []string{"b", "a"}},
+		{expr: "a and b", granted: []string{"a"}, unmet: "b"},
+		{expr: "a && b", granted: []string{"a"}, unmet: "b"},
+		{expr: "a or b", granted: []string{"b"}},
+		{expr: "a || b", granted: []string{"c"}, unmet: "(a or b)"},
+		{expr: "admin OR (orders:read AND orders:write)", granted: []string{"admin"}},
+		{expr: "admin OR (orders:read AND orders:write)", granted: []string{"orders:read", "orders:write"}},
+		{expr: "admin OR (orders:read AND orders:write)", granted: []string{"orders:read"}, unmet: "(admin or (orders:read and orders:write))"},
+		{expr: "orders:read and (orders:write or admin)", granted: []string{"orders:read"}, unmet: "(orders:write or admin)"},
+		{expr: "a and b or c", granted: []string{"c"}},
+		{expr: "a and (b or c)", granted: []string{"c"}, unmet: "a"},
+		{expr: "not guest", granted: []string{"a"}},
+		{expr: "a and !guest", granted: []string{"a", "guest"}, unmet: "not guest"},
+		{expr: "not not a", granted: []string{"a"}},
+		{expr: "((a))", granted: []string{}, unmet: "a"},
+	} {
+		t.Run(fmt.Sprintf("case=%d", k), func(t *testing.T) {
+			e, err := ParseScopeExpr(tc.expr)
+			testx.AssertNoError(t, err)
+
+			err = e.Check(tc.granted)
+			if tc.unmet == "" {
+				testx.AssertNoError(t, err)
+				return
+			}
+
+			var se *ScopeExprError
+			testx.AssertTrue(t, errors.As(err, &se), "expected a *ScopeExprError")
+			testx.AssertTrue(t, se.Unmet == tc.unmet && se.Expr == tc.expr, fmt.Sprintf("expected unmet '%s' but got '%s'", tc.unmet, se.Unmet))
+		})
+	}
+}
+
+func TestParseScopeExpr_Errors(t *testing.T) {
+	for _, expr := range []string{"", "  ", "a and", "or a", "(a", "a)", "a b", "()", "not", "a and or b"} {
+		_, err := ParseScopeExpr(expr)
+		testx.AssertTrue(t, err != nil, fmt.Sprintf("expected '%s' to be invalid", expr))
+	}
+}
+
+func TestScopeExpr_Scopes(t *testing.T) {
+	e := MustParseScopeExpr("admin or (orders:read and not guest)")
+	testx.AssertTrue(t, strings.Join(e.Scopes(), " ") == "admin orders:read", "unexpected scopes")
+}
+
+func TestWithScopesExpr(t *testing.T) {
+	func() {
+		defer func() {
+			testx.AssertTrue(t, recover() != nil, "expected invalid expression to panic at construction")
+		}()
+		WithScopesExpr("a and")
+	}()
+
+	var got error
+	smw := WithScopesExpr("admin or (orders:read and orders:write)", WithErrorWriter(func(w http.ResponseWriter, r *http.Request, err error) {
+		got = err
+		w.WriteHeader(http.StatusForbidden)
+	}))
+	h := NewJWT(&JwtMiddlewareOpts{KeyFunc: validKeyFuncHS256}).Handler(smw(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})))
+
+	for _, tc := range []struct {
+		scopes []string
+		code   int
+	}{
+		{scopes: []string{"admin"}, code: http.StatusOK},
+		{scopes: []string{"orders:write", "orders:read"}, code: http.StatusOK},
+		{scopes: []string{"orders:read"}, code: http.StatusForbidden},
+	} {
+		r, err := http.NewRequest(http.MethodGet, "/", nil)
+		testx.AssertNoError(t, err)
+		r.Header.Set(BearerHeaderKey, signHS256JWT(t, jwt.MapClaims{"scp": tc.scopes}))
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		testx.AssertTrue(t, w.Code == tc.code, fmt.Sprintf("expected code %d but got %d", tc.code, w.Code))
+	}
+
+	testx.AssertTrue(t, errors.Is(got, ErrMissingClaim), "expected error to be ErrMissingClaim")
+	var se *ScopeExprError
+	testx.AssertTrue(t, errors.As(got, &se) && se.Unmet == "(admin or (orders:read and orders:write))", "expected the unmet sub-expression")
+}
+
+func TestWithScopesExpr_BearerChallenge(t *testing.T) {
+	for k, tc := range []struct {
+		expr   string
+		scopes []string
+		header string
+	}{
+		{
+			expr:   "orders:read and orders:write",
+			scopes: []string{"orders:read"},
+			header: `Bearer error="insufficient_scope", error_description="insufficient privileges: insufficient_scope", scope="orders:write"`,
+		},
+		{
+			expr:   "orders:read and (orders:write or admin)",
+			scopes: []string{"guest"},
+			header: `Bearer error="insufficient_scope", error_description="insufficient privileges: insufficient_scope", scope="orders:read"`,
+		},
+		// alternatives are not all required
+		{
+			expr:   "admin or (orders:read and orders:write)",
+			scopes: []string{"orders:read"},
+			header: `Bearer error="insufficient_scope", error_description="insufficient privileges: insufficient_scope"`,
+		},
+		// no scope satisfies a negation
+		{
+			expr:   "not guest",
+			scopes: []string{"guest"},
+			header: `Bearer error="insufficient_scope", error_description="insufficient privileges: insufficient_scope"`,
+		},
+	} {
+		t.Run(fmt.Sprintf("case=%d", k), func(t *testing.T) {
+			var got error
+			ew := NewBearerErrorWriter()
+			smw := WithScopesExpr(tc.expr, WithErrorWriter(func(w http.ResponseWriter, r *http.Request, err error) {
+				got = err
+				ew(w, r, err)
+			}))
+			h := NewJWT(&JwtMiddlewareOpts{KeyFunc: validKeyFuncHS256}).Handler(smw(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})))
+
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.Header.Set(BearerHeaderKey, signHS256JWT(t, jwt.MapClaims{"scp": tc.scopes}))
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+			testx.AssertTrue(t, w.Code == http.StatusForbidden, fmt.Sprintf("expected code 403 but got %d", w.Code))
+			testx.AssertTrue(t, w.Header().Get("WWW-Authenticate") == tc.header, fmt.Sprintf("unexpected header: %s", w.Header().Get("WWW-Authenticate")))
+
+			var se *ScopeError
+			testx.AssertTrue(t, errors.As(got, &se) && !strings.Contains(strings.Join(se.Scopes, " "), tc.expr), "expected the expression not to be reported as a scope")
+		})
+	}
+}
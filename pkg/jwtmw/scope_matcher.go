@@ -0,0 +1,136 @@
+package jwtmw
+
+import (
+	"strings"
+)
+
+// ScopeMatcherOpts describes the options of a ScopeMatcher
+type ScopeMatcherOpts struct {
+	// Separators are the characters that split a scope into segments, defaults to ":".
+	// e.g., ":." treats both "orders:read" and "orders.read" as hierarchical.
+	Separators string
+	// Wildcard is a trailing segment that matches any descendant scope, defaults to "*".
+	// e.g., "orders:*" satisfies "orders:read" and "orders:items:read" but not "orders".
+	// a wildcard alone satisfies any scope.
+	Wildcard string
+	// Hierarchical, if true, lets a scope satisfy its descendants, e.g., "orders" satisfies "orders:read".
+	Hierarchical bool
+	// Implies is a graph of scopes that imply other scopes, e.g., {"admin": {"orders:*"}, "write": {"read"}}.
+	// implications are transitive. A key without separators also applies to the last segment
+	// of hierarchical scopes, so {"write": {"read"}} lets "orders:write" satisfy "orders:read".
+	Implies map[string][]string
+}
+
+// ScopeMatcher tells whether granted scopes satisfy a required scope beyond an exact match,
+// it can be used by the scopes checkers (see ScopesCheckerANDWith, ScopesCheckerORWith) and WithScopeMatcher.
+// It is safe for concurrent use.
+type ScopeMatcher struct {
+	opts ScopeMatcherOpts
+	// implies is the transitive closure of opts.Implies
+	implies map[string][]string
+}
+
+// NewScopeMatcher returns a new ScopeMatcher.
+func NewScopeMatcher(opts ...*ScopeMatcherOpts) *ScopeMatcher {
+	o := ScopeMatcherOpts{
+		Separators: ":",
+		Wildcard:   "*",
+	}
+	for _, oo := range opts {
+		if oo == nil {
+			continue
+		}
+		if oo.Separators != "" {
+			o.Separators = oo.Separators
+		}
+		if oo.Wildcard != "" {
+			o.Wildcard = oo.Wildcard
+		}
+		if oo.Hierarchical {
+			o.Hierarchical = oo.Hierarchical
+		}
+		if oo.Implies != nil {
+			o.Implies = oo.Implies
+		}
+	}
+
+	m := &ScopeMatcher{opts: o, implies: map[string][]string{}}
+	for s := range o.Implies {
+		seen := map[string]bool{s: true}
+		queue := append([]string{}, o.Implies[s]...)
+		for len(queue) > 0 {
+			i := queue[0]
+			queue = queue[1:]
+			if seen[i] {
+				continue
+			}
+			seen[i] = true
+			m.implies[s] = append(m.implies[s], i)
+			queue = append(queue, o.Implies[i]...)
+		}
+	}
+
+	return m
+}
+
+// Contains returns true if any of the granted scopes satisfies required.
+func (m *ScopeMatcher) Contains(granted []string, required string) bool {
+	for _, g := range granted {
+		if m.Match(g, required) {
+			return true
+		}
+	}
+	return false
+}
+
+// Match returns true if the granted scope, or any scope it implies, satisfies required.
+func (m *ScopeMatcher) Match(granted, required string) bool {
+	if m.match(granted, required) {
+		return true
+	}
+
+	for _, i := range m.implies[granted] {
+		if m.match(i, required) {
+			return true
+		}
+	}
+
+	// implications of the last segment, e.g., "orders:write" implies "orders:read"
+	if k := strings.LastIndexAny(granted, m.opts.Separators); k > -1 {
+		prefix, last := granted[:k+1], granted[k+1:]
+		for _, i := range m.implies[last] {
+			if m.match(prefix+i, required) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// match matches a single granted scope, without implications
+func (m *ScopeMatcher) match(granted, required string) bool {
+	if granted == required {
+		return true
+	}
+
+	if granted == m.opts.Wildcard {
+		return true
+	}
+
+	// trailing wildcard, e.g., "orders:*"
+	if strings.HasSuffix(granted, m.opts.Wildcard) {
+		prefix := granted[:len(granted)-len(m.opts.Wildcard)]
+		if prefix != "" && strings.ContainsAny(prefix[len(prefix)-1:], m.opts.Separators) &&
+			len(required) > len(prefix) && strings.HasPrefix(required, prefix) {
+			return true
+		}
+	}
+
+	if m.opts.Hierarchical && len(required) > len(granted) && strings.HasPrefix(required, granted) &&
+		strings.ContainsAny(required[len(granted):len(granted)+1], m.opts.Separators) {
+		return true
+	}
+
+	return false
+}
@@ -0,0 +1,88 @@
+package jwtmw
+
+import (
+	"context"
+	"fmt"
+	"github.com/crossid/crossid-go/pkg/x/testx"
+	"github.com/golang-jwt/jwt/v4"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestScopeMatcher_Match(t *testing.T) {
+	m := NewScopeMatcher(&ScopeMatcherOpts{
+		Implies: map[string][]string{
+			"admin":  {"orders:*", "billing"},
+			"write":  {"read"},
+			"manage": {"write"},
+		},
+	})
+	hm := NewScopeMatcher(&ScopeMatcherOpts{Separators: ":.", Hierarchical: true})
+
+	for k, tc := range []struct {
+		m        *ScopeMatcher
+		granted  string
+		required string
+		v        bool
+	}{
+		{m: m, granted: "orders:read", required: "orders:read", v: true},
+		{m: m, granted: "orders:read", required: "orders:write"},
+		{m: m, granted: "orders:*", required: "orders:read", v: true},
+		{m: m, granted: "orders:*", required: "orders:items:read", v: true},
+		{m: m, granted: "orders:*", required: "orders"},
+		{m: m, granted: "orders:*", required: "ordersx:read"},
+		{m: m, granted: "*", required: "anything", v: true},
+		{m: m, granted: "orders", required: "orders:read"},
+		{m: m, granted: "admin", required: "orders:read", v: true},
+		{m: m, granted: "admin", required: "billing", v: true},
+		{m: m, granted: "admin", required: "users:read"},
+		{m: m, granted: "write", required: "read", v: true},
+		{m: m, granted: "manage", required: "read", v: true},
+		{m: m, granted: "read", required: "write"},
+		{m: m, granted: "orders:write", required: "orders:read", v: true},
+		{m: m, granted: "orders:manage", required: "orders:read", v: true},
+		{m: m, granted: "orders:write", required: "users:read"},
+		{m: hm, granted: "orders", required: "orders:read", v: true},
+		{m: hm, granted: "orders", required: "orders.read", v: true},
+		{m: hm, granted: "orders", required: "ordersx"},
+		{m: hm, granted: "orders.*", required: "orders.read", v: true},
+	} {
+		t.Run(fmt.Sprintf("case=%d", k), func(t *testing.T) {
+			testx.AssertTrue(t, tc.m.Match(tc.granted, tc.required) == tc.v, fmt.Sprintf("expected '%s' matching '%s' to be %v", tc.granted, tc.required, tc.v))
+		})
+	}
+}
+
+func TestScopesCheckerWith(t *testing.T) {
+	m := NewScopeMatcher()
+	testx.AssertNoError(t, ScopesCheckerANDWith(m)(context.Background(), []string{"orders:read", "orders:write"}, []string{"orders:*"}))
+	testx.AssertError(t, ScopesCheckerANDWith(m)(context.Background(), []string{"orders:read", "users:read"}, []string{"orders:*"}))
+	testx.AssertNoError(t, ScopesCheckerORWith(m)(context.Background(), []string{"users:read", "orders:read"}, []string{"orders:*"}))
+	testx.AssertError(t, ScopesCheckerORWith(m)(context.Background(), []string{"users:read"}, []string{"orders:*"}))
+}
+
+func TestWithScopeMatcher(t *testing.T) {
+	m := NewScopeMatcher(&ScopeMatcherOpts{Implies: map[string][]string{"admin": {"*"}}})
+	for k, tc := range []struct {
+		mw     func(http.Handler) http.Handler
+		scopes []string
+		code   int
+	}{
+		{mw: WithScopesCustom([]string{"orders:read"}, WithScopeMatcher(m)), scopes: []string{"orders:*"}, code: http.StatusOK},
+		{mw: WithScopesCustom([]string{"orders:read"}, WithScopeMatcher(m)), scopes: []string{"admin"}, code: http.StatusOK},
+		{mw: WithScopesCustom([]string{"orders:read"}, WithScopeMatcher(m)), scopes: []string{"users:*"}, code: http.StatusForbidden},
+		{mw: WithScopesCustom([]string{"orders:read"}), scopes: []string{"orders:*"}, code: http.StatusForbidden},
+		{mw: WithScopesExpr("orders:read and not users:read", WithScopeMatcher(m)), scopes: []string{"orders:*"}, code: http.StatusOK},
+		{mw: WithScopesExpr("orders:read and not users:read", WithScopeMatcher(m)), scopes: []string{"admin"}, code: http.StatusForbidden},
+	} {
+		t.Run(fmt.Sprintf("case=%d", k), func(t *testing.T) {
+			r, err := http.NewRequest(http.MethodGet, "/", nil)
+			testx.AssertNoError(t, err)
+			r.Header.Set(BearerHeaderKey, signHS256JWT(t, jwt.MapClaims{"scp": tc.scopes}))
+			w := httptest.NewRecorder()
+			NewJWT(&JwtMiddlewareOpts{KeyFunc: validKeyFuncHS256}).Handler(tc.mw(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))).ServeHTTP(w, r)
+			testx.AssertTrue(t, w.Code == tc.code, fmt.Sprintf("expected code %d but got %d", tc.code, w.Code))
+		})
+	}
+}
@@ -0,0 +1,166 @@
+package jwtmw
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"github.com/crossid/crossid-go/pkg/x/claimsx"
+	"github.com/golang-jwt/jwt/v4"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// TokenCacheOpts describes the options of a TokenCache
+type TokenCacheOpts struct {
+	// Size is the maximal number of cached tokens, the least recently used token is evicted first, defaults to 1000.
+	Size int
+	// TTL is the maximal duration a token is cached, a token is never cached beyond its "exp" claim, defaults to 5 minutes.
+	TTL time.Duration
+}
+
+func mergeTokenCacheOpts(opts ...*TokenCacheOpts) *TokenCacheOpts {
+	opt := TokenCacheOpts{
+		Size: 1000,
+		TTL:  5 * time.Minute,
+	}
+
+	for _, o := range opts {
+		if o == nil {
+			continue
+		}
+		if o.Size > 0 {
+			opt.Size = o.Size
+		}
+		if o.TTL > 0 {
+			opt.TTL = o.TTL
+		}
+	}
+
+	return &opt
+}
+
+// TokenCacheStats are the counters of a TokenCache.
+type TokenCacheStats struct {
+	// Hits is the number of tokens found in the cache.
+	Hits uint64
+	// Misses is the number of tokens that were not found, or expired.
+	Misses uint64
+	// Size is the number of cached tokens.
+	Size int
+}
+
+// TokenCache is a bounded LRU cache of verified tokens keyed by a hash of the raw token,
+// which allows the JWT middleware to skip the signature verification of tokens it has already verified.
+// A cache must be used by a single middleware, as tokens are cached according to its configuration.
+// Only the fact a token was verified is cached, the claims of a cached token are decoded per request.
+// It is safe for concurrent use.
+type TokenCache struct {
+	opts TokenCacheOpts
+
+	hits   uint64
+	misses uint64
+
+	mu    sync.Mutex
+	ll    *list.List
+	items map[[sha256.Size]byte]*list.Element
+}
+
+// tokenCacheEntry is a verified token and the version of the key set it was verified by.
+type tokenCacheEntry struct {
+	key       [sha256.Size]byte
+	version   uint64
+	expiresAt time.Time
+}
+
+// NewTokenCache returns a new TokenCache.
+func NewTokenCache(opts ...*TokenCacheOpts) *TokenCache {
+	return &TokenCache{
+		opts:  *mergeTokenCacheOpts(opts...),
+		ll:    list.New(),
+		items: map[[sha256.Size]byte]*list.Element{},
+	}
+}
+
+// Stats returns the counters of the cache.
+func (c *TokenCache) Stats() TokenCacheStats {
+	c.mu.Lock()
+	size := c.ll.Len()
+	c.mu.Unlock()
+
+	return TokenCacheStats{
+		Hits:   atomic.LoadUint64(&c.hits),
+		Misses: atomic.LoadUint64(&c.misses),
+		Size:   size,
+	}
+}
+
+// Purge removes all the cached tokens, e.g., once keys are rotated or tokens are revoked.
+func (c *TokenCache) Purge() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.purge()
+}
+
+func (c *TokenCache) purge() {
+	c.ll.Init()
+	c.items = map[[sha256.Size]byte]*list.Element{}
+}
+
+// get reports whether bearer was verified by the key set of version and is not expired at now.
+// an entry verified by another key set, e.g., before its keys were rotated, is removed.
+func (c *TokenCache) get(bearer string, version uint64, now time.Time) bool {
+	key := sha256.Sum256([]byte(bearer))
+
+	c.mu.Lock()
+	ok := false
+	if el, found := c.items[key]; found {
+		if e := el.Value.(*tokenCacheEntry); e.version == version && now.Before(e.expiresAt) {
+			c.ll.MoveToFront(el)
+			ok = true
+		} else {
+			c.remove(el)
+		}
+	}
+	c.mu.Unlock()
+
+	if !ok {
+		atomic.AddUint64(&c.misses, 1)
+		return false
+	}
+
+	atomic.AddUint64(&c.hits, 1)
+	return true
+}
+
+// add caches the token t of bearer, verified by the key set of version, until min(exp, now + TTL).
+func (c *TokenCache) add(bearer string, version uint64, now time.Time, t *jwt.Token) {
+	e := &tokenCacheEntry{version: version, expiresAt: now.Add(c.opts.TTL)}
+	if mc, err := rawClaims(t); err == nil {
+		if exp, ok := claimsx.NumericDate(mc["exp"]); ok && exp.Before(e.expiresAt) {
+			e.expiresAt = exp
+		}
+	}
+	if !now.Before(e.expiresAt) {
+		return
+	}
+	e.key = sha256.Sum256([]byte(bearer))
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.items[e.key]; ok {
+		el.Value = e
+		c.ll.MoveToFront(el)
+		return
+	}
+
+	c.items[e.key] = c.ll.PushFront(e)
+	for c.ll.Len() > c.opts.Size {
+		c.remove(c.ll.Back())
+	}
+}
+
+func (c *TokenCache) remove(el *list.Element) {
+	c.ll.Remove(el)
+	delete(c.items, el.Value.(*tokenCacheEntry).key)
+}
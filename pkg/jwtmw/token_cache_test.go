@@ -0,0 +1,192 @@
+package jwtmw
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/crossid/crossid-go/pkg/x/testx"
+	"github.com/golang-jwt/jwt/v4"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// countingKeyFunc counts the signature verifications.
+func countingKeyFunc(n *int32) Keyfunc {
+	return func(ctx context.Context, t *jwt.Token) (interface{}, error) {
+		atomic.AddInt32(n, 1)
+		return secret, nil
+	}
+}
+
+func validateWith(t *testing.T, j *JWT, tok string) error {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set(BearerHeaderKey, tok)
+	_, err := j.Validate(r)
+	return err
+}
+
+func TestTokenCache(t *testing.T) {
+	var verified, validated int32
+	var version uint64 = 1
+	c := NewTokenCache()
+	j := NewJWT(&JwtMiddlewareOpts{
+		KeyFunc:       countingKeyFunc(&verified),
+		TokenCache:    c,
+		KeySetVersion: func() uint64 { return atomic.LoadUint64(&version) },
+		Validate: func(r *http.Request, t *jwt.Token, c jwt.Claims) error {
+			atomic.AddInt32(&validated, 1)
+			return nil
+		},
+	})
+
+	tok := signHS256JWT(t, jwt.MapClaims{"sub": "alice", "exp": time.Now().Add(time.Hour).Unix()})
+	for i := 0; i < 3; i++ {
+		testx.AssertNoError(t, validateWith(t, j, tok))
+	}
+	testx.AssertTrue(t, verified == 1, fmt.Sprintf("expected a single verification but got %d", verified))
+	testx.AssertTrue(t, validated == 3, "expected request validations to run on cache hits")
+	s := c.Stats()
+	testx.AssertTrue(t, s.Hits == 2 && s.Misses == 1 && s.Size == 1, fmt.Sprintf("unexpected stats %+v", s))
+
+	// invalid tokens are never cached
+	bad := signHS256JWT(t, jwt.MapClaims{"exp": time.Now().Add(-time.Hour).Unix()})
+	testx.AssertError(t, validateWith(t, j, bad))
+	testx.AssertError(t, validateWith(t, j, bad))
+	testx.AssertTrue(t, c.Stats().Size == 1, "expected an invalid token not to be cached")
+
+	// keys are rotated
+	atomic.AddUint64(&version, 1)
+	testx.AssertNoError(t, validateWith(t, j, tok))
+	testx.AssertTrue(t, verified == 4, fmt.Sprintf("expected rotation to invalidate the cache, got %d verifications", verified))
+
+	c.Purge()
+	testx.AssertTrue(t, c.Stats().Size == 0, "expected an empty cache")
+}
+
+func TestTokenCache_Expiry(t *testing.T) {
+	var verified int32
+	c := NewTokenCache(&TokenCacheOpts{TTL: time.Hour, Size: 2})
+	j := NewJWT(&JwtMiddlewareOpts{KeyFunc: countingKeyFunc(&verified), TokenCache: c})
+
+	// a token is cached until its exp, even if the TTL is longer
+	testx.AssertTrue(t, !c.get("x", 0, time.Now()), "expected a miss")
+	exp := time.Now().Add(time.Minute)
+	tok := signHS256JWT(t, jwt.MapClaims{"exp": exp.Unix()})
+	testx.AssertNoError(t, validateWith(t, j, tok))
+	testx.AssertTrue(t, !c.get(tok[len(BearerPrefix)+1:], 0, exp.Add(time.Second)), "expected the token to expire by exp")
+
+	// the least recently used token is evicted
+	toks := make([]string, 3)
+	for k := range toks {
+		toks[k] = signHS256JWT(t, jwt.MapClaims{"sub": fmt.Sprintf("user-%d", k)})
+		testx.AssertNoError(t, validateWith(t, j, toks[k]))
+	}
+	testx.AssertTrue(t, c.Stats().Size == 2, "expected cache to be bounded")
+	before := verified
+	testx.AssertNoError(t, validateWith(t, j, toks[2]))
+	testx.AssertNoError(t, validateWith(t, j, toks[0]))
+	testx.AssertTrue(t, verified == before+1, "expected only the evicted token to be verified again")
+}
+
+func TestTokenCache_Revocation(t *testing.T) {
+	rs := NewMemoryRevocationStore()
+	j := NewJWT(&JwtMiddlewareOpts{KeyFunc: validKeyFuncHS256, TokenCache: NewTokenCache(), RevocationStore: rs})
+
+	tok := signHS256JWT(t, jwt.MapClaims{"jti": "1"})
+	testx.AssertNoError(t, validateWith(t, j, tok))
+	rs.RevokeID("1", time.Hour)
+	err := validateWith(t, j, tok)
+	var ve *ValidationError
+	testx.AssertTrue(t, errors.As(err, &ve) && ve.Reason == ReasonRevoked, "expected a cached token to be revoked")
+}
+
+func TestTokenCache_Claims(t *testing.T) {
+	for k, tc := range []struct {
+		opts    *JwtMiddlewareOpts
+		subject func(t *jwt.Token) string
+	}{
+		{
+			subject: func(t *jwt.Token) string {
+				mc := t.Claims.(jwt.MapClaims)
+				sub, _ := mc["sub"].(string)
+				// a handler modifying the claims of its request does not affect other requests
+				mc["sub"] = "mallory"
+				return sub
+			},
+		},
+		{
+			opts: &JwtMiddlewareOpts{ClaimsFactory: func() jwt.Claims { return &jwt.RegisteredClaims{} }},
+			subject: func(t *jwt.Token) string {
+				rc := t.Claims.(*jwt.RegisteredClaims)
+				sub := rc.Subject
+				rc.Subject = "mallory"
+				return sub
+			},
+		},
+	} {
+		t.Run(fmt.Sprintf("case=%d", k), func(t *testing.T) {
+			var verified int32
+			c := NewTokenCache()
+			j := NewJWT(&JwtMiddlewareOpts{KeyFunc: countingKeyFunc(&verified), TokenCache: c}, tc.opts)
+
+			tok := signHS256JWT(t, jwt.MapClaims{"sub": "alice"})
+			var prev *jwt.Token
+			for i := 0; i < 3; i++ {
+				r := httptest.NewRequest(http.MethodGet, "/", nil)
+				r.Header.Set(BearerHeaderKey, tok)
+				pt, err := j.Validate(r)
+				testx.AssertNoError(t, err)
+				testx.AssertTrue(t, pt.Valid && pt != prev, "expected a new valid token per request")
+				sub := tc.subject(pt)
+				testx.AssertTrue(t, sub == "alice", fmt.Sprintf("expected the claims of the token but got %s", sub))
+				prev = pt
+			}
+			testx.AssertTrue(t, verified == 1 && c.Stats().Hits == 2, "expected the token to be cached")
+		})
+	}
+}
+
+func TestTokenCache_Issuers(t *testing.T) {
+	var verifiedA, verifiedB int32
+	var versionA, versionB uint64 = 1, 1
+	issuers := map[string]*IssuerConfig{
+		"https://a.io": {Tenant: "a", KeyFunc: countingKeyFunc(&verifiedA), KeySetVersion: func() uint64 { return atomic.LoadUint64(&versionA) }},
+		"https://b.io": {Tenant: "b", KeyFunc: countingKeyFunc(&verifiedB), KeySetVersion: func() uint64 { return atomic.LoadUint64(&versionB) }},
+	}
+	c := NewTokenCache()
+	j := NewJWT(&JwtMiddlewareOpts{IssuerResolver: StaticIssuers(issuers), TokenCache: c})
+
+	tokA := signHS256JWT(t, jwt.MapClaims{"iss": "https://a.io", "sub": "alice"})
+	tokB := signHS256JWT(t, jwt.MapClaims{"iss": "https://b.io", "sub": "bob"})
+	validate := func(tok, tenant string) error {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Set(BearerHeaderKey, tok)
+		_, ten, err := j.validate(r)
+		if err == nil && ten.ID != tenant {
+			return fmt.Errorf("expected tenant %s but got %s", tenant, ten.ID)
+		}
+		return err
+	}
+
+	for i := 0; i < 2; i++ {
+		testx.AssertNoError(t, validate(tokA, "a"))
+		testx.AssertNoError(t, validate(tokB, "b"))
+	}
+	testx.AssertTrue(t, verifiedA == 1 && verifiedB == 1, "expected the tokens to be cached")
+
+	// the keys of a single issuer are rotated
+	atomic.AddUint64(&versionA, 1)
+	testx.AssertNoError(t, validate(tokA, "a"))
+	testx.AssertNoError(t, validate(tokB, "b"))
+	testx.AssertTrue(t, verifiedA == 2, fmt.Sprintf("expected the rotated issuer to verify again, got %d verifications", verifiedA))
+	testx.AssertTrue(t, verifiedB == 1, fmt.Sprintf("expected the other issuer to stay cached, got %d verifications", verifiedB))
+
+	// an issuer that is no longer resolved rejects its cached tokens
+	delete(issuers, "https://b.io")
+	var ve *ValidationError
+	err := validate(tokB, "b")
+	testx.AssertTrue(t, errors.As(err, &ve) && ve.Reason == ReasonUnknownIssuer, fmt.Sprintf("expected an unknown issuer but got %v", err))
+}
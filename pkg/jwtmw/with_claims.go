@@ -0,0 +1,324 @@
+package jwtmw
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"regexp"
+	"strings"
+)
+
+// ClaimPredicate asserts a condition on the claims of a token.
+type ClaimPredicate interface {
+	// Check returns nil if claims satisfy the predicate, otherwise a *ClaimError.
+	Check(claims map[string]interface{}) error
+	String() string
+}
+
+// ClaimError describes an unmet claim predicate.
+type ClaimError struct {
+	// Claim is the path of the claim (e.g., "realm_access.roles"), empty for composed predicates.
+	Claim string
+	// Predicate describes the unmet predicate (e.g., `realm_access.roles contains "billing"`).
+	Predicate string
+}
+
+func (e *ClaimError) Error() string {
+	return fmt.Sprintf("claims predicate '%s' is not satisfied", e.Predicate)
+}
+
+// WithClaims requires the token's claims to satisfy p, e.g.,
+//
+//	WithClaims(AllClaims(ClaimEquals("email_verified", true), ClaimContains("realm_access.roles", "billing")))
+//
+// The claims are read from the token put in context by the JWT middleware, regardless of its claims type.
+func WithClaims(p ClaimPredicate, opt ...WithScopesOpt) func(next http.Handler) http.Handler {
+	if p == nil {
+		panic("claims predicate must be set.")
+	}
+
+	opts := newWithScopesOpts(opt)
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			tok, err := opts.TokenFromContext(r.Context())
+			if err != nil {
+				opts.Logger(Info, "missing token")
+				opts.ErrorWriter(w, r, err)
+				return
+			}
+
+			mc, err := rawClaims(tok)
+			if err != nil {
+				opts.Logger(Info, "error extracting claims: %s", err)
+				opts.ErrorWriter(w, r, &ValidationError{Reason: ReasonClaims, Err: err, sentinel: ErrExtractingClaims})
+				return
+			}
+
+			if err := p.Check(mc); err != nil {
+				opts.Logger(Info, "claims errors: %s", err)
+				ve := &ValidationError{Reason: ReasonInsufficientClaims, Err: err, sentinel: ErrMissingClaim}
+				if ce, ok := err.(*ClaimError); ok {
+					ve.Claim = ce.Claim
+				}
+				opts.ErrorWriter(w, r, ve)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// ClaimValue returns the value of the claim at path, where nested claims are separated by a dot
+// (e.g., "realm_access.roles"), a literal dot in a claim name is escaped by a backslash (e.g., `https://example\.com/roles`).
+func ClaimValue(claims map[string]interface{}, path string) (interface{}, bool) {
+	var v interface{} = claims
+	for _, k := range splitClaimPath(path) {
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if v, ok = m[k]; !ok {
+			return nil, false
+		}
+	}
+	return v, true
+}
+
+func splitClaimPath(path string) []string {
+	var parts []string
+	var b strings.Builder
+	for i := 0; i < len(path); i++ {
+		switch {
+		case path[i] == '\\' && i+1 < len(path) && path[i+1] == '.':
+			b.WriteByte('.')
+			i++
+		case path[i] == '.':
+			parts = append(parts, b.String())
+			b.Reset()
+		default:
+			b.WriteByte(path[i])
+		}
+	}
+	return append(parts, b.String())
+}
+
+// claimPredicate asserts a condition on the value of a single claim
+type claimPredicate struct {
+	path string
+	desc string
+	test func(v interface{}) bool
+}
+
+func (p *claimPredicate) Check(claims map[string]interface{}) error {
+	if v, ok := ClaimValue(claims, p.path); ok && p.test(v) {
+		return nil
+	}
+	return &ClaimError{Claim: p.path, Predicate: p.desc}
+}
+
+func (p *claimPredicate) String() string {
+	return p.desc
+}
+
+// ClaimExists requires the claim at path to be present.
+func ClaimExists(path string) ClaimPredicate {
+	return &claimPredicate{path: path, desc: fmt.Sprintf("%s exists", path), test: func(v interface{}) bool {
+		return true
+	}}
+}
+
+// ClaimEquals requires the claim at path to equal value, numbers are compared by their value regardless of their type.
+func ClaimEquals(path string, value interface{}) ClaimPredicate {
+	return &claimPredicate{path: path, desc: fmt.Sprintf("%s == %s", path, quoteClaim(value)), test: func(v interface{}) bool {
+		return claimEquals(v, value)
+	}}
+}
+
+// ClaimContains requires the claim at path, either an array or a space delimited string (e.g., "scope"), to contain value.
+func ClaimContains(path string, value interface{}) ClaimPredicate {
+	return &claimPredicate{path: path, desc: fmt.Sprintf("%s contains %s", path, quoteClaim(value)), test: func(v interface{}) bool {
+		for _, e := range claimElements(v) {
+			if claimEquals(e, value) {
+				return true
+			}
+		}
+		return false
+	}}
+}
+
+// ClaimMatches requires the claim at path, or any of its elements if an array, to be a string that matches the regular expression expr.
+// it panics if expr cannot be compiled.
+func ClaimMatches(path string, expr string) ClaimPredicate {
+	re := regexp.MustCompile(expr)
+	return &claimPredicate{path: path, desc: fmt.Sprintf("%s matches %s", path, quoteClaim(expr)), test: func(v interface{}) bool {
+		elems := []interface{}{v}
+		if arr, ok := v.([]interface{}); ok {
+			elems = arr
+		}
+		for _, e := range elems {
+			if s, ok := e.(string); ok && re.MatchString(s) {
+				return true
+			}
+		}
+		return false
+	}}
+}
+
+// ClaimGreaterThan requires the claim at path to be a number greater than n.
+func ClaimGreaterThan(path string, n float64) ClaimPredicate {
+	return claimCompare(path, ">", n, func(f float64) bool { return f > n })
+}
+
+// ClaimGreaterOrEqual requires the claim at path to be a number greater than or equal to n.
+func ClaimGreaterOrEqual(path string, n float64) ClaimPredicate {
+	return claimCompare(path, ">=", n, func(f float64) bool { return f >= n })
+}
+
+// ClaimLessThan requires the claim at path to be a number less than n.
+func ClaimLessThan(path string, n float64) ClaimPredicate {
+	return claimCompare(path, "<", n, func(f float64) bool { return f < n })
+}
+
+// ClaimLessOrEqual requires the claim at path to be a number less than or equal to n.
+func ClaimLessOrEqual(path string, n float64) ClaimPredicate {
+	return claimCompare(path, "<=", n, func(f float64) bool { return f <= n })
+}
+
+func claimCompare(path, op string, n float64, cmp func(f float64) bool) ClaimPredicate {
+	return &claimPredicate{path: path, desc: fmt.Sprintf("%s %s %v", path, op, n), test: func(v interface{}) bool {
+		f, ok := claimNumber(v)
+		return ok && cmp(f)
+	}}
+}
+
+// AllClaims requires all of the predicates to be satisfied.
+func AllClaims(predicates ...ClaimPredicate) ClaimPredicate {
+	return &claimsAll{predicates: predicates}
+}
+
+// AnyClaims requires any of the predicates to be satisfied.
+func AnyClaims(predicates ...ClaimPredicate) ClaimPredicate {
+	return &claimsAny{predicates: predicates}
+}
+
+// NotClaims requires the predicate not to be satisfied.
+func NotClaims(p ClaimPredicate) ClaimPredicate {
+	return &claimsNot{p: p}
+}
+
+type claimsAll struct {
+	predicates []ClaimPredicate
+}
+
+// Check returns the error of the first unmet predicate.
+func (p *claimsAll) Check(claims map[string]interface{}) error {
+	for _, pp := range p.predicates {
+		if err := pp.Check(claims); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *claimsAll) String() string {
+	return "(" + joinClaimPredicates(p.predicates, " and ") + ")"
+}
+
+type claimsAny struct {
+	predicates []ClaimPredicate
+}
+
+func (p *claimsAny) Check(claims map[string]interface{}) error {
+	for _, pp := range p.predicates {
+		if pp.Check(claims) == nil {
+			return nil
+		}
+	}
+	return &ClaimError{Predicate: p.String()}
+}
+
+func (p *claimsAny) String() string {
+	return "(" + joinClaimPredicates(p.predicates, " or ") + ")"
+}
+
+type claimsNot struct {
+	p ClaimPredicate
+}
+
+func (p *claimsNot) Check(claims map[string]interface{}) error {
+	if p.p.Check(claims) == nil {
+		return &ClaimError{Predicate: p.String()}
+	}
+	return nil
+}
+
+func (p *claimsNot) String() string {
+	return "not " + p.p.String()
+}
+
+func joinClaimPredicates(predicates []ClaimPredicate, sep string) string {
+	s := make([]string, len(predicates))
+	for k, p := range predicates {
+		s[k] = p.String()
+	}
+	return strings.Join(s, sep)
+}
+
+// claimElements returns the elements of an array claim or the fields of a space delimited string claim
+func claimElements(v interface{}) []interface{} {
+	switch vv := v.(type) {
+	case []interface{}:
+		return vv
+	case []string:
+		elems := make([]interface{}, len(vv))
+		for k, s := range vv {
+			elems[k] = s
+		}
+		return elems
+	case string:
+		fields := strings.Fields(vv)
+		elems := make([]interface{}, len(fields))
+		for k, s := range fields {
+			elems[k] = s
+		}
+		return elems
+	}
+	return nil
+}
+
+func claimEquals(v, value interface{}) bool {
+	if f, ok := claimNumber(v); ok {
+		n, ok := claimNumber(value)
+		return ok && f == n
+	}
+	return reflect.DeepEqual(v, value)
+}
+
+// claimNumber returns the value of a numeric claim
+func claimNumber(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case float32:
+		return float64(n), true
+	case int:
+		return float64(n), true
+	case int32:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case json.Number:
+		f, err := n.Float64()
+		return f, err == nil
+	}
+	return 0, false
+}
+
+func quoteClaim(v interface{}) string {
+	if s, ok := v.(string); ok {
+		return fmt.Sprintf("%q", s)
+	}
+	return fmt.Sprintf("%v", v)
+}
@@ -0,0 +1,111 @@
+package jwtmw
+
+import (
+	"errors"
+	"fmt"
+	"github.com/crossid/crossid-go/pkg/x/testx"
+	"github.com/golang-jwt/jwt/v4"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClaimPredicates(t *testing.T) {
+	claims := map[string]interface{}{
+		"sub":            "alice",
+		"email_verified": true,
+		"age":            float64(42),
+		"scope":          "orders:read orders:write",
+		"realm_access": map[string]interface{}{
+			"roles": []interface{}{"billing", "support"},
+		},
+		"https://crossid.io/tenant": "acme",
+	}
+
+	for k, tc := range []struct {
+		p     ClaimPredicate
+		v     bool
+		claim string
+	}{
+		{p: ClaimExists("sub"), v: true},
+		{p: ClaimExists("email"), claim: "email"},
+		{p: ClaimEquals("email_verified", true), v: true},
+		{p: ClaimEquals("email_verified", "true"), claim: "email_verified"},
+		{p: ClaimEquals("age", 42), v: true},
+		{p: ClaimEquals("sub", "bob"), claim: "sub"},
+		{p: ClaimContains("realm_access.roles", "billing"), v: true},
+		{p: ClaimContains("realm_access.roles", "admin"), claim: "realm_access.roles"},
+		{p: ClaimContains("realm_access.missing", "admin"), claim: "realm_access.missing"},
+		{p: ClaimContains("sub.roles", "admin"), claim: "sub.roles"},
+		{p: ClaimContains("scope", "orders:write"), v: true},
+		{p: ClaimContains("scope", "orders"), claim: "scope"},
+		{p: ClaimMatches("sub", "^a"), v: true},
+		{p: ClaimMatches("realm_access.roles", "^sup"), v: true},
+		{p: ClaimMatches("age", "42"), claim: "age"},
+		{p: ClaimGreaterThan("age", 41), v: true},
+		{p: ClaimGreaterThan("age", 42), claim: "age"},
+		{p: ClaimGreaterOrEqual("age", 42), v: true},
+		{p: ClaimLessThan("age", 42), claim: "age"},
+		{p: ClaimLessOrEqual("age", 42), v: true},
+		{p: ClaimLessThan("sub", 42), claim: "sub"},
+		{p: ClaimEquals(`https://crossid\.io/tenant`, "acme"), v: true},
+		{p: AllClaims(ClaimEquals("email_verified", true), ClaimContains("realm_access.roles", "billing")), v: true},
+		{p: AllClaims(ClaimEquals("email_verified", true), ClaimContains("realm_access.roles", "admin")), claim: "realm_access.roles"},
+		{p: AnyClaims(ClaimContains("realm_access.roles", "admin"), ClaimEquals("sub", "alice")), v: true},
+		{p: AnyClaims(ClaimContains("realm_access.roles", "admin"), ClaimEquals("sub", "bob"))},
+		{p: NotClaims(ClaimEquals("sub", "bob")), v: true},
+		{p: NotClaims(ClaimEquals("sub", "alice"))},
+	} {
+		t.Run(fmt.Sprintf("case=%d/%s", k, tc.p), func(t *testing.T) {
+			err := tc.p.Check(claims)
+			if tc.v {
+				testx.AssertNoError(t, err)
+				return
+			}
+			var ce *ClaimError
+			testx.AssertTrue(t, errors.As(err, &ce), "expected a *ClaimError")
+			testx.AssertTrue(t, ce.Claim == tc.claim, fmt.Sprintf("expected claim '%s' but got '%s'", tc.claim, ce.Claim))
+		})
+	}
+}
+
+func TestWithClaims(t *testing.T) {
+	var got error
+	mw := WithClaims(AllClaims(ClaimEquals("email_verified", true), ClaimContains("realm_access.roles", "billing")), WithErrorWriter(func(w http.ResponseWriter, r *http.Request, err error) {
+		got = err
+		w.WriteHeader(http.StatusForbidden)
+	}))
+
+	for k, tc := range []struct {
+		opts   *JwtMiddlewareOpts
+		claims jwt.MapClaims
+		code   int
+	}{
+		{
+			claims: jwt.MapClaims{"email_verified": true, "realm_access": map[string]interface{}{"roles": []string{"billing"}}},
+			code:   http.StatusOK,
+		},
+		{
+			opts:   &JwtMiddlewareOpts{Claims: &jwt.RegisteredClaims{}},
+			claims: jwt.MapClaims{"email_verified": true, "realm_access": map[string]interface{}{"roles": []string{"billing"}}},
+			code:   http.StatusOK,
+		},
+		{
+			claims: jwt.MapClaims{"email_verified": false, "realm_access": map[string]interface{}{"roles": []string{"billing"}}},
+			code:   http.StatusForbidden,
+		},
+	} {
+		t.Run(fmt.Sprintf("case=%d", k), func(t *testing.T) {
+			r, err := http.NewRequest(http.MethodGet, "/", nil)
+			testx.AssertNoError(t, err)
+			r.Header.Set(BearerHeaderKey, signHS256JWT(t, tc.claims))
+			w := httptest.NewRecorder()
+			NewJWT(&JwtMiddlewareOpts{KeyFunc: validKeyFuncHS256}, tc.opts).Handler(mw(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))).ServeHTTP(w, r)
+			testx.AssertTrue(t, w.Code == tc.code, fmt.Sprintf("expected code %d but got %d", tc.code, w.Code))
+		})
+	}
+
+	testx.AssertTrue(t, errors.Is(got, ErrMissingClaim), "expected error to be ErrMissingClaim")
+	var ve *ValidationError
+	testx.AssertTrue(t, errors.As(got, &ve) && ve.Reason == ReasonInsufficientClaims && ve.Claim == "email_verified", "unexpected validation error")
+}
@@ -2,6 +2,7 @@ package jwtmw
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"github.com/golang-jwt/jwt/v4"
 	"net/http"
@@ -17,9 +18,11 @@ func DefaultClaimsFromToken(ctx context.Context, t *jwt.Token) ([]string, error)
 		if arr, ok := scpv.([]string); ok {
 			return arr, nil
 		} else if arr, ok := scpv.([]interface{}); ok {
-			strs := make([]string, len(arr))
-			for i, s := range arr {
-				strs[i] = s.(string)
+			strs := make([]string, 0, len(arr))
+			for _, s := range arr {
+				if s, ok := s.(string); ok {
+					strs = append(strs, s)
+				}
 			}
 			return strs, nil
 		}
@@ -30,34 +33,104 @@ func DefaultClaimsFromToken(ctx context.Context, t *jwt.Token) ([]string, error)
 	}
 }
 
+// principalScopes returns the scopes of the principal mapped from t, if any, otherwise it falls back to DefaultClaimsFromToken.
+func principalScopes(ctx context.Context, t *jwt.Token) ([]string, error) {
+	if p, ok := PrincipalFromContext(ctx); ok && p.token == t {
+		return p.Scopes, nil
+	}
+
+	return DefaultClaimsFromToken(ctx, t)
+}
+
 func WithScopes(required ...string) func(next http.Handler) http.Handler {
 	return WithScopesCustom(required)
 }
 
+// WithScopesCustom returns a middleware that requires the token put in context to be granted the required scopes.
+// options are resolved once, it panics if required is empty or contains an empty scope.
 func WithScopesCustom(required []string, opt ...WithScopesOpt) func(next http.Handler) http.Handler {
 	if required == nil || len(required) < 1 {
 		panic("required must be set with at least one scope.")
 	}
+	for _, s := range required {
+		if s == "" {
+			panic("required scopes must not be empty.")
+		}
+	}
+
+	opts := newWithScopesOpts(opt)
+	// required is copied so the caller may not change it once the middleware is built.
+	required = append([]string(nil), required...)
+	check := opts.ScopesChecker
+	if opts.exact {
+		// the default checker, all the required scopes are matched exactly against a pre-indexed set.
+		set := newScopeSet(required)
+		check = func(_ context.Context, _ []string, candidates []string) error {
+			if !set.all(candidates) {
+				return errScopesNotFound
+			}
+			return nil
+		}
+		if opts.defaultClaims {
+			// the scopes of a token decoded from JSON are matched in place rather converted per request.
+			opts.granted = func(t *jwt.Token) (bool, bool) {
+				mc, ok := t.Claims.(jwt.MapClaims)
+				if !ok {
+					return false, false
+				}
+				arr, ok := mc[ScopesClaim].([]interface{})
+				if !ok {
+					return false, false
+				}
+				return set.allValues(arr), true
+			}
+		}
+	}
+
+	return withScopes(required, check, opts)
+}
+
+// withScopes returns a middleware that requires the scopes of the token put in context to pass check.
+// an insufficient scope error reports required, or the scopes of the unmet sub-expression if check returns a *ScopeExprError.
+func withScopes(required []string, check ScopesCheckerFunc, opts *withScopesOpts) func(next http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			opts := newWithScopesOpts(opt)
-			tok, err := opts.TokenFromContext(r.Context())
+			ctx := r.Context()
+			tok, err := opts.TokenFromContext(ctx)
 			if err != nil {
 				opts.Logger(Info, "missing token")
 				opts.ErrorWriter(w, r, err)
 				return
 			}
 
-			cl, err := opts.ClaimsFromToken(r.Context(), tok)
+			// a denial is checked again below so it is reported as any other
+			if opts.granted != nil {
+				if granted, ok := opts.granted(tok); ok && granted {
+					next.ServeHTTP(w, r)
+					return
+				}
+			}
+
+			cl, err := opts.ClaimsFromToken(ctx, tok)
 			if err != nil {
 				opts.Logger(Info, "error extracting claims: %s", err)
-				opts.ErrorWriter(w, r, ErrExtractingClaims)
+				opts.ErrorWriter(w, r, &ValidationError{Reason: ReasonClaims, Claim: ScopesClaim, Err: err, sentinel: ErrExtractingClaims})
 				return
 			}
 
-			if err := opts.ScopesChecker(r.Context(), required, cl); err != nil {
+			if err := check(ctx, required, cl); err != nil {
 				opts.Logger(Info, "scopes errors: %s", err)
-				opts.ErrorWriter(w, r, ErrMissingClaim)
+				scopes := required
+				var see *ScopeExprError
+				if errors.As(err, &see) {
+					scopes = see.Scopes
+				}
+				opts.ErrorWriter(w, r, &ValidationError{
+					Reason:   ReasonInsufficientScope,
+					Claim:    ScopesClaim,
+					Err:      &ScopeError{Scopes: scopes, Err: err},
+					sentinel: ErrMissingClaim,
+				})
 				return
 			}
 
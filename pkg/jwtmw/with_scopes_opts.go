@@ -2,6 +2,7 @@ package jwtmw
 
 import (
 	"context"
+	"errors"
 	"github.com/golang-jwt/jwt/v4"
 	"net/http"
 )
@@ -13,14 +14,24 @@ type withScopesOpts struct {
 	TokenCtxKey interface{}
 	// TokenFromContext extracts an authenticated token from context.
 	// It assumes some prior middleware authenticated the token and put it in context, typically by the JWT middleware.
-	// default implementation is naive as r.Context().Value(TokenCtxKey).(*jwt.Token)
+	// defaults to the token stored under TokenCtxKey, ErrMissingToken is returned if there is none.
 	TokenFromContext func(ctx context.Context) (*jwt.Token, error)
-	ClaimsFromToken  claimFromTokenFunc
+	// ClaimsFromToken extracts the granted scopes of a token, defaults to DefaultClaimsFromToken.
+	ClaimsFromToken claimFromTokenFunc
 	// ErrorWriter writes an error into w
 	ErrorWriter errorWriter
 	// Logger logs various messages
 	Logger        logger
 	ScopesChecker ScopesCheckerFunc
+	// ScopeMatcher, if set, is used by the default scopes checker instead of exact matching.
+	ScopeMatcher *ScopeMatcher
+	// exact is true if the default checker, exact matching of all the required scopes, is in use.
+	exact bool
+	// defaultClaims is true if DefaultClaimsFromToken is in use.
+	defaultClaims bool
+	// granted, if set, reports whether t is granted the required scopes without extracting them,
+	// ok is false if it cannot tell, in which case the scopes are extracted and checked.
+	granted func(t *jwt.Token) (granted, ok bool)
 }
 
 type WithScopesOpt func(*withScopesOpts)
@@ -43,12 +54,26 @@ func WithClaimsFromToken(f claimFromTokenFunc) WithScopesOpt {
 	}
 }
 
+// WithPrincipalScopes reads the granted scopes from the Principal put in context by the JWT middleware,
+// as mapped by its PrincipalMapper regardless of the claims type, or by DefaultClaimsFromToken if there is none.
+func WithPrincipalScopes() WithScopesOpt {
+	return WithClaimsFromToken(principalScopes)
+}
+
 func WithScopesChecker(f ScopesCheckerFunc) WithScopesOpt {
 	return func(o *withScopesOpts) {
 		o.ScopesChecker = f
 	}
 }
 
+// WithScopeMatcher matches the required scopes using m (e.g., wildcards and implied scopes) rather exact matching.
+// it has no effect if a custom checker is set by WithScopesChecker, use ScopesCheckerANDWith or ScopesCheckerORWith instead.
+func WithScopeMatcher(m *ScopeMatcher) WithScopesOpt {
+	return func(o *withScopesOpts) {
+		o.ScopeMatcher = m
+	}
+}
+
 func newWithScopesOpts(opts []WithScopesOpt) *withScopesOpts {
 	o := new(withScopesOpts)
 	for _, oo := range opts {
@@ -58,7 +83,11 @@ func newWithScopesOpts(opts []WithScopesOpt) *withScopesOpts {
 	// defaults
 	if o.ErrorWriter == nil {
 		o.ErrorWriter = func(w http.ResponseWriter, r *http.Request, err error) {
-			http.Error(w, err.Error(), http.StatusForbidden)
+			status := http.StatusForbidden
+			if errors.Is(err, ErrAuthorizationFailed) {
+				status = http.StatusInternalServerError
+			}
+			http.Error(w, err.Error(), status)
 		}
 	}
 
@@ -68,12 +97,12 @@ func newWithScopesOpts(opts []WithScopesOpt) *withScopesOpts {
 
 	if o.TokenFromContext == nil {
 		o.TokenFromContext = func(ctx context.Context) (*jwt.Token, error) {
-			tv := ctx.Value(o.TokenCtxKey)
-			if tv == nil {
+			t, ok := tokenFromContext(ctx, o.TokenCtxKey)
+			if !ok {
 				return nil, ErrMissingToken
 			}
 
-			return tv.(*jwt.Token), nil
+			return t, nil
 		}
 	}
 
@@ -81,12 +110,18 @@ func newWithScopesOpts(opts []WithScopesOpt) *withScopesOpts {
 		o.TokenCtxKey = TokenCtxKey
 	}
 
+	if o.ScopesChecker == nil && o.ScopeMatcher != nil {
+		o.ScopesChecker = ScopesCheckerANDWith(o.ScopeMatcher)
+	}
+
 	if o.ScopesChecker == nil {
 		o.ScopesChecker = scopesCheckerAND
+		o.exact = true
 	}
 
 	if o.ClaimsFromToken == nil {
 		o.ClaimsFromToken = DefaultClaimsFromToken
+		o.defaultClaims = true
 	}
 
 	return o
@@ -6,6 +6,7 @@ import (
 	"github.com/golang-jwt/jwt/v4"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 )
 
@@ -143,3 +144,195 @@ func TestWithScopesCustom(t *testing.T) {
 		})
 	}
 }
+
+func TestScopeSet(t *testing.T) {
+	many := make([]string, 70)
+	for k := range many {
+		many[k] = fmt.Sprintf("s%d", k)
+	}
+
+	for k, tc := range []struct {
+		required   []string
+		candidates []string
+		all        bool
+	}{
+		{required: []string{"a", "b"}, candidates: []string{"b", "c", "a"}, all: true},
+		{required: []string{"a", "b"}, candidates: []string{"a", "a"}},
+		{required: []string{"a", "a"}, candidates: []string{"a"}, all: true},
+		{required: []string{"a"}},
+		{required: many, candidates: many, all: true},
+		{required: many, candidates: many[1:]},
+	} {
+		t.Run(fmt.Sprintf("case=%d", k), func(t *testing.T) {
+			if got := newScopeSet(tc.required).all(tc.candidates); got != tc.all {
+				t.Fatalf("expected %v but got %v", tc.all, got)
+			}
+		})
+	}
+}
+
+func TestWithScopes_Panics(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Fatalf("expected panic of an empty scope")
+		}
+	}()
+	WithScopes("foo", "")
+}
+
+// scopesRequest returns a request with a token, granted scopes, and its principal in context, as put by the JWT middleware.
+func scopesRequest(scopes ...string) *http.Request {
+	tok := &jwt.Token{Claims: jwt.MapClaims{ScopesClaim: scopes}, Valid: true}
+	p, _ := DefaultPrincipalMapper(context.Background(), tok)
+	p.token = tok
+	ctx := context.WithValue(context.Background(), TokenCtxKey, tok)
+	ctx = context.WithValue(ctx, principalCtxKey, p)
+	return httptest.NewRequest(http.MethodGet, "/", nil).WithContext(ctx)
+}
+
+// parsedScopesRequest returns a request with a token granted scopes in context, decoded from JSON as by the JWT middleware.
+func parsedScopesRequest(t *testing.T, scopes ...string) *http.Request {
+	raw, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{ScopesClaim: scopes}).SignedString(secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tok, err := jwt.Parse(raw, func(t *jwt.Token) (interface{}, error) { return secret, nil })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := tok.Claims.(jwt.MapClaims)[ScopesClaim].([]interface{}); !ok && scopes != nil {
+		t.Fatalf("expected scopes decoded into []interface{}")
+	}
+	return httptest.NewRequest(http.MethodGet, "/", nil).WithContext(context.WithValue(context.Background(), TokenCtxKey, tok))
+}
+
+func TestWithScopes_Allocations(t *testing.T) {
+	for k, r := range []*http.Request{
+		scopesRequest("openid", "profile", "email"),
+		parsedScopesRequest(t, "openid", "profile", "email"),
+	} {
+		t.Run(fmt.Sprintf("case=%d", k), func(t *testing.T) {
+			w := httptest.NewRecorder()
+			h := WithScopes("profile", "openid")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+			if n := testing.AllocsPerRun(100, func() { h.ServeHTTP(w, r) }); n != 0 {
+				t.Fatalf("expected no allocations but got %v", n)
+			}
+		})
+	}
+}
+
+func TestWithScopes_ParsedToken(t *testing.T) {
+	var many []string
+	for i := 0; i < 70; i++ {
+		many = append(many, fmt.Sprintf("s%d", i))
+	}
+
+	for k, tc := range []struct {
+		required []string
+		granted  []string
+		status   int
+	}{
+		{required: []string{"openid", "profile"}, granted: []string{"profile", "openid"}, status: http.StatusOK},
+		{required: []string{"openid", "profile"}, granted: []string{"openid"}, status: http.StatusForbidden},
+		{required: []string{"openid"}, status: http.StatusForbidden},
+		{required: many, granted: many, status: http.StatusOK},
+		{required: many, granted: many[1:], status: http.StatusForbidden},
+	} {
+		t.Run(fmt.Sprintf("case=%d", k), func(t *testing.T) {
+			w := httptest.NewRecorder()
+			WithScopesCustom(tc.required)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})).ServeHTTP(w, parsedScopesRequest(t, tc.granted...))
+			if w.Code != tc.status {
+				t.Fatalf("expected status %d but got %d", tc.status, w.Code)
+			}
+		})
+	}
+
+	// elements that are not strings grant nothing
+	tok := &jwt.Token{Claims: jwt.MapClaims{ScopesClaim: []interface{}{"openid", 1.0}}, Valid: true}
+	r := httptest.NewRequest(http.MethodGet, "/", nil).WithContext(context.WithValue(context.Background(), TokenCtxKey, tok))
+	w := httptest.NewRecorder()
+	WithScopes("openid")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})).ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200 but got %d", w.Code)
+	}
+}
+
+// withScopesPerRequest is the former WithScopesCustom, which resolved its options on every request,
+// kept to benchmark against.
+func withScopesPerRequest(required []string, opt ...WithScopesOpt) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			opts := newWithScopesOpts(opt)
+			tok, err := opts.TokenFromContext(r.Context())
+			if err != nil {
+				opts.ErrorWriter(w, r, err)
+				return
+			}
+
+			cl, err := opts.ClaimsFromToken(r.Context(), tok)
+			if err != nil {
+				opts.ErrorWriter(w, r, err)
+				return
+			}
+
+			if err := opts.ScopesChecker(r.Context(), required, cl); err != nil {
+				opts.ErrorWriter(w, r, err)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+var benchmarkScopes = []struct {
+	name string
+	mw   func(required []string, opt ...WithScopesOpt) func(next http.Handler) http.Handler
+}{
+	{name: "per-request", mw: withScopesPerRequest},
+	{name: "resolved-once", mw: WithScopesCustom},
+}
+
+func BenchmarkWithScopes(b *testing.B) {
+	granted := strings.Fields("openid profile email offline_access read:invoices write:invoices read:users")
+	r := scopesRequest(granted...)
+	for _, bc := range benchmarkScopes {
+		b.Run(bc.name, func(b *testing.B) {
+			h := bc.mw([]string{"read:invoices", "write:invoices", "openid"})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+			w := httptest.NewRecorder()
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				h.ServeHTTP(w, r)
+			}
+		})
+	}
+}
+
+func BenchmarkJWTHandlerWithScopes(b *testing.B) {
+	tok, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"sub":       "alice",
+		ScopesClaim: []string{"openid", "profile", "read:invoices", "write:invoices"},
+	}).SignedString(secret)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	jmw := NewJWT(&JwtMiddlewareOpts{KeyFunc: validKeyFuncHS256})
+	for _, bc := range benchmarkScopes {
+		b.Run(bc.name, func(b *testing.B) {
+			h := jmw.Handler(bc.mw([]string{"read:invoices", "openid"})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})))
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.Header.Set(BearerHeaderKey, BearerPrefix+" "+tok)
+			b.ReportAllocs()
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				w := httptest.NewRecorder()
+				for pb.Next() {
+					h.ServeHTTP(w, r)
+				}
+			})
+		})
+	}
+}
@@ -0,0 +1,201 @@
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"github.com/crossid/crossid-go/pkg/x/claimsx"
+	"github.com/golang-jwt/jwt/v4"
+	"strings"
+	"time"
+
+	// hash functions of the signing algorithms of ID tokens
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+)
+
+// ErrInvalidIDToken is returned, wrapped, when an ID token fails verification.
+var ErrInvalidIDToken = errors.New("invalid id token")
+
+// IDToken is a verified ID token.
+// see https://openid.net/specs/openid-connect-core-1_0.html#IDToken
+type IDToken struct {
+	// Raw is the encoded ID token.
+	Raw string
+	// Issuer is the "iss" claim.
+	Issuer string
+	// Subject is the "sub" claim.
+	Subject string
+	// Audience is the "aud" claim.
+	Audience []string
+	// Expiry is the "exp" claim.
+	Expiry time.Time
+	// IssuedAt is the "iat" claim.
+	IssuedAt time.Time
+	// AuthTime is the "auth_time" claim, zero if absent.
+	AuthTime time.Time
+	// Nonce is the "nonce" claim.
+	Nonce string
+	// Claims are all the claims of the ID token.
+	Claims jwt.MapClaims
+}
+
+// IDTokenVerifyOpts describes what is expected of an ID token.
+type IDTokenVerifyOpts struct {
+	// ClientID must be one of the audiences, and the authorized party if there are multiple audiences.
+	ClientID string
+	// Nonce, if set, must equal the "nonce" claim.
+	Nonce string
+	// AccessToken, if set, is verified against the "at_hash" claim, if present.
+	AccessToken string
+	// Code, if set, is verified against the "c_hash" claim, if present.
+	Code string
+	// MaxAge, if set, requires the "auth_time" claim to be no more than MaxAge ago.
+	MaxAge time.Duration
+	// Leeway is the tolerated clock skew when validating time based claims.
+	Leeway time.Duration
+	// Now returns the current time, defaults to time.Now.
+	Now func() time.Time
+}
+
+// VerifyIDToken verifies the signature of the ID token raw using the keys of p and its claims against o.
+func (p *Provider) VerifyIDToken(ctx context.Context, raw string, o *IDTokenVerifyOpts) (*IDToken, error) {
+	if o == nil {
+		o = &IDTokenVerifyOpts{}
+	}
+	now := time.Now
+	if o.Now != nil {
+		now = o.Now
+	}
+
+	parser := &jwt.Parser{ValidMethods: p.Metadata().SigningAlgs(), SkipClaimsValidation: true}
+	t, err := parser.ParseWithClaims(raw, jwt.MapClaims{}, func(t *jwt.Token) (interface{}, error) {
+		return p.KeyFunc(ctx, t)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidIDToken, err)
+	}
+
+	mc := t.Claims.(jwt.MapClaims)
+	tok := &IDToken{Raw: raw, Claims: mc, Audience: claimsx.Audiences(mc)}
+	tok.Issuer, _ = mc["iss"].(string)
+	tok.Subject, _ = mc["sub"].(string)
+	tok.Nonce, _ = mc["nonce"].(string)
+	tok.Expiry, _ = claimsx.NumericDate(mc["exp"])
+	tok.IssuedAt, _ = claimsx.NumericDate(mc["iat"])
+	tok.AuthTime, _ = claimsx.NumericDate(mc["auth_time"])
+
+	if err := verifyIDTokenClaims(tok, p.Issuer(), o, now()); err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidIDToken, err)
+	}
+
+	alg := t.Method.Alg()
+	if atHash, ok := mc["at_hash"].(string); ok && o.AccessToken != "" {
+		if err := verifyHalfHash(alg, o.AccessToken, atHash); err != nil {
+			return nil, fmt.Errorf("%w: at_hash: %s", ErrInvalidIDToken, err)
+		}
+	}
+	if cHash, ok := mc["c_hash"].(string); ok && o.Code != "" {
+		if err := verifyHalfHash(alg, o.Code, cHash); err != nil {
+			return nil, fmt.Errorf("%w: c_hash: %s", ErrInvalidIDToken, err)
+		}
+	}
+
+	return tok, nil
+}
+
+// verifyIDTokenClaims validates the claims of tok per section 3.1.3.7 of the spec.
+func verifyIDTokenClaims(tok *IDToken, issuer string, o *IDTokenVerifyOpts, now time.Time) error {
+	if tok.Issuer != issuer {
+		return fmt.Errorf("unexpected issuer '%s'", tok.Issuer)
+	}
+	if tok.Subject == "" {
+		return fmt.Errorf("missing sub claim")
+	}
+
+	if o.ClientID != "" {
+		found := false
+		for _, a := range tok.Audience {
+			found = found || a == o.ClientID
+		}
+		if !found {
+			return fmt.Errorf("client '%s' is not an audience", o.ClientID)
+		}
+		azp, _ := tok.Claims["azp"].(string)
+		if (len(tok.Audience) > 1 || azp != "") && azp != o.ClientID {
+			return fmt.Errorf("unexpected authorized party '%s'", azp)
+		}
+	}
+
+	if tok.Expiry.IsZero() {
+		return fmt.Errorf("missing exp claim")
+	}
+	if !now.Before(tok.Expiry.Add(o.Leeway)) {
+		return fmt.Errorf("token is expired")
+	}
+	if tok.IssuedAt.IsZero() {
+		return fmt.Errorf("missing iat claim")
+	}
+	if now.Add(o.Leeway).Before(tok.IssuedAt) {
+		return fmt.Errorf("token is used before issued")
+	}
+
+	if o.Nonce != "" && subtle.ConstantTimeCompare([]byte(tok.Nonce), []byte(o.Nonce)) != 1 {
+		return fmt.Errorf("nonce mismatch")
+	}
+
+	if o.MaxAge > 0 {
+		if tok.AuthTime.IsZero() {
+			return fmt.Errorf("missing auth_time claim")
+		}
+		if now.Sub(tok.AuthTime) > o.MaxAge+o.Leeway {
+			return fmt.Errorf("authentication is older than %s", o.MaxAge)
+		}
+	}
+
+	return nil
+}
+
+// verifyHalfHash verifies that h is the base64url encoding of the left-most half of the hash of v,
+// by the hash function of the signing algorithm alg (e.g., SHA-256 for RS256), as of the at_hash and c_hash claims.
+func verifyHalfHash(alg, v, h string) error {
+	expected, err := HalfHash(alg, v)
+	if err != nil {
+		return err
+	}
+	if subtle.ConstantTimeCompare([]byte(expected), []byte(h)) != 1 {
+		return fmt.Errorf("hash mismatch")
+	}
+
+	return nil
+}
+
+// HalfHash returns the value of an at_hash or c_hash claim of v for the signing algorithm alg.
+func HalfHash(alg, v string) (string, error) {
+	hf, err := algHash(alg)
+	if err != nil {
+		return "", err
+	}
+
+	hh := hf.New()
+	hh.Write([]byte(v))
+	sum := hh.Sum(nil)
+	return base64.RawURLEncoding.EncodeToString(sum[:len(sum)/2]), nil
+}
+
+// algHash returns the hash function of the signing algorithm alg.
+func algHash(alg string) (crypto.Hash, error) {
+	switch {
+	case strings.HasSuffix(alg, "256"):
+		return crypto.SHA256, nil
+	case strings.HasSuffix(alg, "384"):
+		return crypto.SHA384, nil
+	case strings.HasSuffix(alg, "512"), alg == "EdDSA":
+		return crypto.SHA512, nil
+	}
+
+	return 0, fmt.Errorf("unsupported signing algorithm '%s'", alg)
+}
@@ -0,0 +1,101 @@
+/*
+Package oidc provides OpenID Connect utilities such as provider discovery, ID token verification
+and a relying party that logs users in by the authorization code flow.
+*/
+package oidc
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+// DiscoveryPath is the path, relative to the issuer, of the OpenID provider configuration document.
+const DiscoveryPath = "/.well-known/openid-configuration"
+
+// ProviderMetadata is the OpenID provider configuration document.
+// see https://openid.net/specs/openid-connect-discovery-1_0.html#ProviderMetadata
+type ProviderMetadata struct {
+	Issuer                            string   `json:"issuer"`
+	AuthorizationEndpoint             string   `json:"authorization_endpoint,omitempty"`
+	TokenEndpoint                     string   `json:"token_endpoint,omitempty"`
+	UserinfoEndpoint                  string   `json:"userinfo_endpoint,omitempty"`
+	JWKSURI                           string   `json:"jwks_uri"`
+	EndSessionEndpoint                string   `json:"end_session_endpoint,omitempty"`
+	IntrospectionEndpoint             string   `json:"introspection_endpoint,omitempty"`
+	RevocationEndpoint                string   `json:"revocation_endpoint,omitempty"`
+	ScopesSupported                   []string `json:"scopes_supported,omitempty"`
+	ResponseTypesSupported            []string `json:"response_types_supported,omitempty"`
+	GrantTypesSupported               []string `json:"grant_types_supported,omitempty"`
+	SubjectTypesSupported             []string `json:"subject_types_supported,omitempty"`
+	IDTokenSigningAlgValuesSupported  []string `json:"id_token_signing_alg_values_supported,omitempty"`
+	TokenEndpointAuthMethodsSupported []string `json:"token_endpoint_auth_methods_supported,omitempty"`
+	ClaimsSupported                   []string `json:"claims_supported,omitempty"`
+	CodeChallengeMethodsSupported     []string `json:"code_challenge_methods_supported,omitempty"`
+}
+
+// DiscoveryURL returns the location of the configuration document of issuer.
+func DiscoveryURL(issuer string) string {
+	return strings.TrimSuffix(issuer, "/") + DiscoveryPath
+}
+
+// FetchProviderMetadata fetches the configuration document of issuer.
+// an error is returned if the issuer of the document is not issuer, as required by the spec.
+func FetchProviderMetadata(ctx context.Context, client *http.Client, issuer string) (*ProviderMetadata, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, DiscoveryURL(issuer), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching provider metadata: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("error fetching provider metadata: unexpected status code %d", resp.StatusCode)
+	}
+
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("error reading provider metadata: %w", err)
+	}
+
+	md := new(ProviderMetadata)
+	if err := json.Unmarshal(b, md); err != nil {
+		return nil, fmt.Errorf("error decoding provider metadata: %w", err)
+	}
+
+	if strings.TrimSuffix(md.Issuer, "/") != strings.TrimSuffix(issuer, "/") {
+		return nil, fmt.Errorf("issuer mismatch, expected '%s' but provider metadata says '%s'", issuer, md.Issuer)
+	}
+
+	if md.JWKSURI == "" {
+		return nil, fmt.Errorf("provider metadata has no jwks_uri")
+	}
+
+	return md, nil
+}
+
+// SigningAlgs returns the asymmetric ID token signing algorithms advertised by md, RS256 if none is advertised.
+func (md *ProviderMetadata) SigningAlgs() []string {
+	var algs []string
+	for _, a := range md.IDTokenSigningAlgValuesSupported {
+		// symmetric keys are shared with clients, they can never be used to verify tokens by a third party.
+		if a == "none" || strings.HasPrefix(a, "HS") {
+			continue
+		}
+		algs = append(algs, a)
+	}
+
+	if len(algs) == 0 {
+		return []string{"RS256"}
+	}
+
+	return algs
+}
@@ -0,0 +1,180 @@
+package oidc
+
+import (
+	"context"
+	"github.com/crossid/crossid-go/pkg/jwks"
+	"github.com/golang-jwt/jwt/v4"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// ProviderOpts describes the options of a Provider
+type ProviderOpts struct {
+	// HTTPClient is used to fetch the configuration document and the key set, defaults to an http.Client with a 10s timeout.
+	HTTPClient *http.Client
+	// RefreshInterval is the interval of re-reading the configuration document, defaults to 1 hour.
+	// a negative value disables refreshes.
+	RefreshInterval time.Duration
+	// RefreshErrorHandler is called when a refresh fails, the previously fetched document remains in use.
+	RefreshErrorHandler func(err error)
+	// KeySetOpts are the options of the key set fetched from the jwks_uri of the provider.
+	// HTTPClient and RefreshErrorHandler are inherited if not set.
+	KeySetOpts *jwks.RemoteKeySetOpts
+}
+
+func mergeProviderOpts(opts ...*ProviderOpts) *ProviderOpts {
+	opt := ProviderOpts{
+		HTTPClient:          &http.Client{Timeout: 10 * time.Second},
+		RefreshInterval:     time.Hour,
+		RefreshErrorHandler: func(err error) {},
+	}
+
+	for _, o := range opts {
+		if o == nil {
+			continue
+		}
+		if o.HTTPClient != nil {
+			opt.HTTPClient = o.HTTPClient
+		}
+		if o.RefreshInterval != 0 {
+			opt.RefreshInterval = o.RefreshInterval
+		}
+		if o.RefreshErrorHandler != nil {
+			opt.RefreshErrorHandler = o.RefreshErrorHandler
+		}
+		if o.KeySetOpts != nil {
+			opt.KeySetOpts = o.KeySetOpts
+		}
+	}
+
+	return &opt
+}
+
+// Provider is an OpenID provider whose configuration is discovered from its issuer URL.
+// The configuration document is re-read periodically and the key set is replaced if the jwks_uri changes.
+// It is safe for concurrent use.
+type Provider struct {
+	issuer string
+	opts   ProviderOpts
+
+	mu       sync.RWMutex
+	metadata *ProviderMetadata
+	keys     *jwks.RemoteKeySet
+
+	// refreshMu serializes refreshes so a changed jwks_uri results in a single new key set.
+	refreshMu sync.Mutex
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// NewProvider discovers the provider of issuer (e.g., https://<tenant>.crossid.io/oauth2/) and fetches its keys.
+// Close should be called once the provider is no longer needed to stop background refreshes.
+func NewProvider(ctx context.Context, issuer string, opts ...*ProviderOpts) (*Provider, error) {
+	p := &Provider{
+		issuer: issuer,
+		opts:   *mergeProviderOpts(opts...),
+		done:   make(chan struct{}),
+	}
+
+	if err := p.Refresh(ctx); err != nil {
+		return nil, err
+	}
+
+	bctx, cancel := context.WithCancel(context.Background())
+	p.cancel = cancel
+	go p.refreshLoop(bctx)
+
+	return p, nil
+}
+
+// Issuer returns the issuer identifier as advertised by the provider.
+func (p *Provider) Issuer() string {
+	return p.Metadata().Issuer
+}
+
+// Metadata returns the most recently fetched configuration document.
+func (p *Provider) Metadata() *ProviderMetadata {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.metadata
+}
+
+// KeySet returns the key set of the provider.
+func (p *Provider) KeySet() *jwks.RemoteKeySet {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.keys
+}
+
+// KeyFunc returns the key that verifies t from the key set of the provider.
+// It is compatible with jwtmw.Keyfunc.
+func (p *Provider) KeyFunc(ctx context.Context, t *jwt.Token) (interface{}, error) {
+	return p.KeySet().KeyFunc(ctx, t)
+}
+
+// Refresh re-reads the configuration document now.
+func (p *Provider) Refresh(ctx context.Context) error {
+	p.refreshMu.Lock()
+	defer p.refreshMu.Unlock()
+
+	md, err := FetchProviderMetadata(ctx, p.opts.HTTPClient, p.issuer)
+	if err != nil {
+		return err
+	}
+
+	p.mu.RLock()
+	keys := p.keys
+	p.mu.RUnlock()
+
+	var stale *jwks.RemoteKeySet
+	if keys == nil || keys.URL() != md.JWKSURI {
+		nk, err := jwks.NewRemoteKeySet(ctx, md.JWKSURI, &jwks.RemoteKeySetOpts{
+			HTTPClient:          p.opts.HTTPClient,
+			RefreshErrorHandler: p.opts.RefreshErrorHandler,
+		}, p.opts.KeySetOpts)
+		if err != nil {
+			return err
+		}
+		stale, keys = keys, nk
+	}
+
+	p.mu.Lock()
+	p.metadata = md
+	p.keys = keys
+	p.mu.Unlock()
+
+	if stale != nil {
+		stale.Close()
+	}
+
+	return nil
+}
+
+// Close stops background refreshes of the configuration document and the key set.
+func (p *Provider) Close() {
+	p.cancel()
+	<-p.done
+	p.KeySet().Close()
+}
+
+func (p *Provider) refreshLoop(ctx context.Context) {
+	defer close(p.done)
+	if p.opts.RefreshInterval < 0 {
+		return
+	}
+
+	t := time.NewTicker(p.opts.RefreshInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			if err := p.Refresh(ctx); err != nil && ctx.Err() == nil {
+				p.opts.RefreshErrorHandler(err)
+			}
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package oidc
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/crossid/crossid-go/pkg/x/testx"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+func TestProvider(t *testing.T) {
+	var mu sync.Mutex
+	jwksPath := "/jwks1.json"
+	mux := http.NewServeMux()
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	mux.HandleFunc(DiscoveryPath, func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		testx.AssertNoError(t, json.NewEncoder(w).Encode(&ProviderMetadata{
+			Issuer:        srv.URL,
+			JWKSURI:       srv.URL + jwksPath,
+			TokenEndpoint: srv.URL + "/token",
+		}))
+	})
+	for _, p := range []string{"/jwks1.json", "/jwks2.json"} {
+		mux.HandleFunc(p, func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte(`{"keys": []}`))
+		})
+	}
+
+	p, err := NewProvider(context.Background(), srv.URL+"/", &ProviderOpts{RefreshInterval: -1})
+	testx.AssertNoError(t, err)
+	defer p.Close()
+
+	testx.AssertTrue(t, p.Issuer() == srv.URL, "issuer mismatch")
+	testx.AssertTrue(t, p.Metadata().TokenEndpoint == srv.URL+"/token", "token endpoint mismatch")
+	testx.AssertTrue(t, p.KeySet().URL() == srv.URL+"/jwks1.json", "jwks uri mismatch")
+
+	// the provider moved its key set
+	mu.Lock()
+	jwksPath = "/jwks2.json"
+	mu.Unlock()
+	testx.AssertNoError(t, p.Refresh(context.Background()))
+	testx.AssertTrue(t, p.KeySet().URL() == srv.URL+"/jwks2.json", "expected key set to be replaced")
+}
+
+func TestFetchProviderMetadata_IssuerMismatch(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"issuer": "https://evil.io", "jwks_uri": "https://evil.io/jwks.json"}`))
+	}))
+	defer srv.Close()
+
+	_, err := FetchProviderMetadata(context.Background(), srv.Client(), srv.URL)
+	testx.AssertError(t, err)
+}
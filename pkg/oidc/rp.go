@@ -0,0 +1,565 @@
+package oidc
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/crossid/crossid-go/pkg/x/stringslice"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrInvalidState is returned by the callback when the state is unknown, tampered or expired.
+	ErrInvalidState = errors.New("invalid or expired state")
+)
+
+// AuthError is an error response of the provider (see RFC 6749 sections 4.1.2.1 and 5.2).
+type AuthError struct {
+	// Code is the error code (e.g., "access_denied").
+	Code string
+	// Description is the human-readable error description, if any.
+	Description string
+}
+
+func (e *AuthError) Error() string {
+	if e.Description == "" {
+		return fmt.Sprintf("authorization error: %s", e.Code)
+	}
+	return fmt.Sprintf("authorization error: %s: %s", e.Code, e.Description)
+}
+
+// Tokens are the tokens issued by the token endpoint.
+type Tokens struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	IDToken      string `json:"id_token,omitempty"`
+	Scope        string `json:"scope,omitempty"`
+	ExpiresIn    int64  `json:"expires_in,omitempty"`
+	// Expiry is the time the access token expires, per ExpiresIn, zero if unknown.
+	Expiry time.Time `json:"-"`
+}
+
+// LoginResult is the outcome of a successful login.
+type LoginResult struct {
+	// Tokens are the tokens issued to the relying party.
+	Tokens *Tokens
+	// IDToken is the verified ID token.
+	IDToken *IDToken
+	// ReturnTo is the local path the user should be redirected to, as requested when login started.
+	ReturnTo string
+}
+
+// RelyingPartyOpts describes the options of a RelyingParty
+type RelyingPartyOpts struct {
+	// ClientID and ClientSecret are the credentials of the client registered with the provider.
+	// the secret is sent by HTTP basic authentication, it may be empty for public clients.
+	ClientID     string
+	ClientSecret string
+	// RedirectURL is the callback URL registered with the provider (e.g., https://app.example.com/callback).
+	RedirectURL string
+	// Scopes are the requested scopes, defaults to "openid", which is always requested.
+	Scopes []string
+	// AuthParams are additional parameters of the authorization request (e.g., "audience" or "prompt").
+	AuthParams url.Values
+	// MaxAge, if set, is sent as the max_age parameter and the auth_time of the ID token is verified against it.
+	MaxAge time.Duration
+	// CookieSecret is the key that signs the cookie holding the state of a login, it must be at least 32 bytes.
+	CookieSecret []byte
+	// CookieName is the prefix of the name of the state cookie, defaults to "crossid_auth".
+	CookieName string
+	// CookiePath is the path of the state cookie, defaults to "/".
+	CookiePath string
+	// InsecureCookie allows the state cookie to be sent over plain HTTP, for local development only.
+	InsecureCookie bool
+	// StateTTL is the duration a login may take, defaults to 10 minutes.
+	StateTTL time.Duration
+	// Leeway is the tolerated clock skew when validating the ID token, defaults to 30 seconds.
+	Leeway time.Duration
+	// HTTPClient is used to call the token endpoint, defaults to an http.Client with a 10s timeout.
+	HTTPClient *http.Client
+	// PostLogin is called once a user logged in, typically to establish a session and redirect to res.ReturnTo.
+	// defaults to a redirect to res.ReturnTo.
+	PostLogin func(w http.ResponseWriter, r *http.Request, res *LoginResult)
+	// ErrorHandler writes a failed login into w, defaults to a plain text 400.
+	ErrorHandler func(w http.ResponseWriter, r *http.Request, err error)
+	// PostLogoutRedirectURL is where the provider redirects to once logged out, it must be registered with the provider.
+	PostLogoutRedirectURL string
+	// IDTokenHint returns the ID token of the user that logs out, sent as a hint to the provider, if any.
+	IDTokenHint func(r *http.Request) string
+	// OnLogout is called before redirecting to the provider, typically to clear the local session.
+	OnLogout func(w http.ResponseWriter, r *http.Request)
+	// LogoutCSRFToken returns the CSRF token of the session of r, a logout request must send it
+	// as the "csrf_token" form value, so other sites cannot log users out.
+	LogoutCSRFToken func(r *http.Request) string
+	// SameSiteStrictSession declares the session cookie is SameSite=Strict, so a logout request of another site
+	// carries no session and LogoutCSRFToken is not required.
+	SameSiteStrictSession bool
+}
+
+func mergeRelyingPartyOpts(opts ...*RelyingPartyOpts) *RelyingPartyOpts {
+	opt := RelyingPartyOpts{
+		Scopes:     []string{"openid"},
+		CookieName: "crossid_auth",
+		CookiePath: "/",
+		StateTTL:   10 * time.Minute,
+		Leeway:     30 * time.Second,
+		HTTPClient: &http.Client{Timeout: 10 * time.Second},
+		PostLogin: func(w http.ResponseWriter, r *http.Request, res *LoginResult) {
+			http.Redirect(w, r, res.ReturnTo, http.StatusFound)
+		},
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		},
+		IDTokenHint: func(r *http.Request) string { return "" },
+		OnLogout:    func(w http.ResponseWriter, r *http.Request) {},
+	}
+
+	for _, o := range opts {
+		if o == nil {
+			continue
+		}
+		if o.ClientID != "" {
+			opt.ClientID = o.ClientID
+		}
+		if o.ClientSecret != "" {
+			opt.ClientSecret = o.ClientSecret
+		}
+		if o.RedirectURL != "" {
+			opt.RedirectURL = o.RedirectURL
+		}
+		if o.Scopes != nil {
+			opt.Scopes = o.Scopes
+		}
+		if o.AuthParams != nil {
+			opt.AuthParams = o.AuthParams
+		}
+		if o.MaxAge != 0 {
+			opt.MaxAge = o.MaxAge
+		}
+		if o.CookieSecret != nil {
+			opt.CookieSecret = o.CookieSecret
+		}
+		if o.CookieName != "" {
+			opt.CookieName = o.CookieName
+		}
+		if o.CookiePath != "" {
+			opt.CookiePath = o.CookiePath
+		}
+		if o.InsecureCookie {
+			opt.InsecureCookie = o.InsecureCookie
+		}
+		if o.StateTTL != 0 {
+			opt.StateTTL = o.StateTTL
+		}
+		if o.Leeway != 0 {
+			opt.Leeway = o.Leeway
+		}
+		if o.HTTPClient != nil {
+			opt.HTTPClient = o.HTTPClient
+		}
+		if o.PostLogin != nil {
+			opt.PostLogin = o.PostLogin
+		}
+		if o.ErrorHandler != nil {
+			opt.ErrorHandler = o.ErrorHandler
+		}
+		if o.PostLogoutRedirectURL != "" {
+			opt.PostLogoutRedirectURL = o.PostLogoutRedirectURL
+		}
+		if o.IDTokenHint != nil {
+			opt.IDTokenHint = o.IDTokenHint
+		}
+		if o.OnLogout != nil {
+			opt.OnLogout = o.OnLogout
+		}
+		if o.LogoutCSRFToken != nil {
+			opt.LogoutCSRFToken = o.LogoutCSRFToken
+		}
+		if o.SameSiteStrictSession {
+			opt.SameSiteStrictSession = o.SameSiteStrictSession
+		}
+	}
+
+	if stringslice.IndexOf(opt.Scopes, "openid") == -1 {
+		opt.Scopes = append([]string{"openid"}, opt.Scopes...)
+	}
+
+	return &opt
+}
+
+// RelyingParty logs users in by the authorization code flow of a provider, with PKCE (RFC 7636).
+// The state, nonce and code verifier of a login are kept in a signed cookie, so any number of logins
+// may run concurrently and the relying party holds no server-side state.
+// It is safe for concurrent use.
+type RelyingParty struct {
+	provider *Provider
+	opts     RelyingPartyOpts
+}
+
+// NewRelyingParty returns a RelyingParty of the provider p.
+func NewRelyingParty(p *Provider, opts ...*RelyingPartyOpts) (*RelyingParty, error) {
+	o := mergeRelyingPartyOpts(opts...)
+	if o.ClientID == "" {
+		return nil, fmt.Errorf("client id must be set")
+	}
+	if o.RedirectURL == "" {
+		return nil, fmt.Errorf("redirect url must be set")
+	}
+	if len(o.CookieSecret) < 32 {
+		return nil, fmt.Errorf("cookie secret must be at least 32 bytes")
+	}
+
+	return &RelyingParty{provider: p, opts: *o}, nil
+}
+
+// loginState is the state of a login, kept in the state cookie between the login and the callback.
+type loginState struct {
+	State        string `json:"s"`
+	Nonce        string `json:"n"`
+	CodeVerifier string `json:"v"`
+	ReturnTo     string `json:"r"`
+	ExpiresAt    int64  `json:"e"`
+}
+
+// LoginHandler returns a handler that starts a login by redirecting to the authorization endpoint.
+// the optional "return_to" query parameter is the local path the user is redirected to once logged in.
+func (rp *RelyingParty) LoginHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ls := &loginState{
+			State:        randomString(),
+			Nonce:        randomString(),
+			CodeVerifier: randomString(),
+			ReturnTo:     localPath(r.URL.Query().Get("return_to")),
+			ExpiresAt:    time.Now().Add(rp.opts.StateTTL).Unix(),
+		}
+
+		if err := rp.setState(w, ls); err != nil {
+			rp.opts.ErrorHandler(w, r, err)
+			return
+		}
+
+		http.Redirect(w, r, rp.AuthCodeURL(ls.State, ls.Nonce, ls.CodeVerifier), http.StatusFound)
+	})
+}
+
+// AuthCodeURL returns the URL of the authorization request of state, nonce and the PKCE code verifier.
+func (rp *RelyingParty) AuthCodeURL(state, nonce, codeVerifier string) string {
+	q := url.Values{}
+	for k, v := range rp.opts.AuthParams {
+		q[k] = v
+	}
+	q.Set("response_type", "code")
+	q.Set("client_id", rp.opts.ClientID)
+	q.Set("redirect_uri", rp.opts.RedirectURL)
+	q.Set("scope", strings.Join(rp.opts.Scopes, " "))
+	q.Set("state", state)
+	q.Set("nonce", nonce)
+	q.Set("code_challenge", codeChallenge(codeVerifier))
+	q.Set("code_challenge_method", "S256")
+	if rp.opts.MaxAge > 0 {
+		q.Set("max_age", fmt.Sprintf("%d", int64(rp.opts.MaxAge/time.Second)))
+	}
+
+	u := rp.provider.Metadata().AuthorizationEndpoint
+	if strings.Contains(u, "?") {
+		return u + "&" + q.Encode()
+	}
+	return u + "?" + q.Encode()
+}
+
+// CallbackHandler returns the handler of the RedirectURL, it completes a login and calls PostLogin.
+func (rp *RelyingParty) CallbackHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		ls, err := rp.state(r, q.Get("state"))
+		// the state is single use, whether the login succeeds or not
+		rp.clearState(w, q.Get("state"))
+		if err != nil {
+			rp.opts.ErrorHandler(w, r, err)
+			return
+		}
+
+		if code := q.Get("error"); code != "" {
+			rp.opts.ErrorHandler(w, r, &AuthError{Code: code, Description: q.Get("error_description")})
+			return
+		}
+
+		code := q.Get("code")
+		if code == "" {
+			rp.opts.ErrorHandler(w, r, &AuthError{Code: "invalid_request", Description: "missing code"})
+			return
+		}
+
+		toks, err := rp.Exchange(r.Context(), code, ls.CodeVerifier)
+		if err != nil {
+			rp.opts.ErrorHandler(w, r, err)
+			return
+		}
+		if toks.IDToken == "" {
+			rp.opts.ErrorHandler(w, r, fmt.Errorf("%w: token response has no id_token", ErrInvalidIDToken))
+			return
+		}
+
+		idt, err := rp.provider.VerifyIDToken(r.Context(), toks.IDToken, &IDTokenVerifyOpts{
+			ClientID:    rp.opts.ClientID,
+			Nonce:       ls.Nonce,
+			AccessToken: toks.AccessToken,
+			Code:        code,
+			MaxAge:      rp.opts.MaxAge,
+			Leeway:      rp.opts.Leeway,
+		})
+		if err != nil {
+			rp.opts.ErrorHandler(w, r, err)
+			return
+		}
+
+		rp.opts.PostLogin(w, r, &LoginResult{Tokens: toks, IDToken: idt, ReturnTo: ls.ReturnTo})
+	})
+}
+
+// LogoutHandler returns a handler that calls OnLogout and redirects to the end session endpoint of the provider,
+// or to PostLogoutRedirectURL if the provider does not advertise one.
+// only POST requests are accepted, carrying the LogoutCSRFToken unless SameSiteStrictSession is set.
+// it panics if neither LogoutCSRFToken nor SameSiteStrictSession is set.
+func (rp *RelyingParty) LogoutHandler() http.Handler {
+	if rp.opts.LogoutCSRFToken == nil && !rp.opts.SameSiteStrictSession {
+		panic("oidc: LogoutCSRFToken or SameSiteStrictSession must be set to handle logouts")
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+
+		if rp.opts.LogoutCSRFToken != nil {
+			want := rp.opts.LogoutCSRFToken(r)
+			if want == "" || !hmac.Equal([]byte(r.PostFormValue("csrf_token")), []byte(want)) {
+				http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+				return
+			}
+		}
+
+		hint := rp.opts.IDTokenHint(r)
+		rp.opts.OnLogout(w, r)
+
+		endSession := rp.provider.Metadata().EndSessionEndpoint
+		if endSession == "" {
+			to := rp.opts.PostLogoutRedirectURL
+			if to == "" {
+				to = "/"
+			}
+			http.Redirect(w, r, to, http.StatusFound)
+			return
+		}
+
+		q := url.Values{}
+		q.Set("client_id", rp.opts.ClientID)
+		if hint != "" {
+			q.Set("id_token_hint", hint)
+		}
+		if rp.opts.PostLogoutRedirectURL != "" {
+			q.Set("post_logout_redirect_uri", rp.opts.PostLogoutRedirectURL)
+		}
+
+		sep := "?"
+		if strings.Contains(endSession, "?") {
+			sep = "&"
+		}
+		http.Redirect(w, r, endSession+sep+q.Encode(), http.StatusFound)
+	})
+}
+
+// Exchange exchanges an authorization code, and the PKCE code verifier of its request, for tokens.
+func (rp *RelyingParty) Exchange(ctx context.Context, code, codeVerifier string) (*Tokens, error) {
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", rp.opts.RedirectURL)
+	form.Set("code_verifier", codeVerifier)
+	if rp.opts.ClientSecret == "" {
+		form.Set("client_id", rp.opts.ClientID)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, rp.provider.Metadata().TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if rp.opts.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(rp.opts.ClientID), url.QueryEscape(rp.opts.ClientSecret))
+	}
+
+	resp, err := rp.opts.HTTPClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error calling token endpoint: %w", err)
+	}
+	defer resp.Body.Close()
+
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("error reading token response: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		var e struct {
+			Code        string `json:"error"`
+			Description string `json:"error_description"`
+		}
+		if err := json.Unmarshal(b, &e); err == nil && e.Code != "" {
+			return nil, &AuthError{Code: e.Code, Description: e.Description}
+		}
+		return nil, fmt.Errorf("error calling token endpoint: unexpected status code %d", resp.StatusCode)
+	}
+
+	toks := new(Tokens)
+	if err := json.Unmarshal(b, toks); err != nil {
+		return nil, fmt.Errorf("error decoding token response: %w", err)
+	}
+	if toks.AccessToken == "" {
+		return nil, fmt.Errorf("token response has no access_token")
+	}
+	if toks.ExpiresIn > 0 {
+		toks.Expiry = time.Now().Add(time.Duration(toks.ExpiresIn) * time.Second)
+	}
+
+	return toks, nil
+}
+
+// cookieName returns the name of the state cookie of state, each login has its own cookie.
+func (rp *RelyingParty) cookieName(state string) string {
+	if len(state) > 16 {
+		state = state[:16]
+	}
+	return rp.opts.CookieName + "_" + state
+}
+
+// setState signs ls into its state cookie.
+func (rp *RelyingParty) setState(w http.ResponseWriter, ls *loginState) error {
+	b, err := json.Marshal(ls)
+	if err != nil {
+		return err
+	}
+	payload := base64.RawURLEncoding.EncodeToString(b)
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     rp.cookieName(ls.State),
+		Value:    payload + "." + rp.sign(payload),
+		Path:     rp.opts.CookiePath,
+		MaxAge:   int(rp.opts.StateTTL / time.Second),
+		Secure:   !rp.opts.InsecureCookie,
+		HttpOnly: true,
+		// the callback is a top level navigation from the provider
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	return nil
+}
+
+// state returns the verified login state of state.
+func (rp *RelyingParty) state(r *http.Request, state string) (*loginState, error) {
+	if state == "" {
+		return nil, ErrInvalidState
+	}
+
+	c, err := r.Cookie(rp.cookieName(state))
+	if err != nil {
+		return nil, ErrInvalidState
+	}
+
+	p := strings.Split(c.Value, ".")
+	if len(p) != 2 || !hmac.Equal([]byte(rp.sign(p[0])), []byte(p[1])) {
+		return nil, ErrInvalidState
+	}
+
+	b, err := base64.RawURLEncoding.DecodeString(p[0])
+	if err != nil {
+		return nil, ErrInvalidState
+	}
+	ls := new(loginState)
+	if err := json.Unmarshal(b, ls); err != nil {
+		return nil, ErrInvalidState
+	}
+
+	if !hmac.Equal([]byte(ls.State), []byte(state)) || time.Now().Unix() > ls.ExpiresAt {
+		return nil, ErrInvalidState
+	}
+
+	return ls, nil
+}
+
+// clearState deletes the state cookie of state.
+func (rp *RelyingParty) clearState(w http.ResponseWriter, state string) {
+	if state == "" {
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     rp.cookieName(state),
+		Value:    "",
+		Path:     rp.opts.CookiePath,
+		MaxAge:   -1,
+		Secure:   !rp.opts.InsecureCookie,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+func (rp *RelyingParty) sign(payload string) string {
+	m := hmac.New(sha256.New, rp.opts.CookieSecret)
+	m.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(m.Sum(nil))
+}
+
+// codeChallenge returns the S256 code challenge of verifier (RFC 7636 section 4.2).
+func codeChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// randomString returns a random, URL safe, string of 256 bits.
+func randomString() string {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// localPath returns p if it is a local absolute path, "/" otherwise, to avoid open redirects.
+// control characters and backslashes are rejected, even percent-encoded, since browsers strip or
+// normalize them, e.g., "/\t/evil.com" is followed as "//evil.com".
+func localPath(p string) string {
+	if !isLocalPath(p) {
+		return "/"
+	}
+	u, err := url.Parse(p)
+	if err != nil || u.Scheme != "" || u.Host != "" || !isLocalPath(u.Path) {
+		return "/"
+	}
+	return p
+}
+
+// isLocalPath reports whether p starts with a single "/" and has no control characters nor backslashes.
+func isLocalPath(p string) bool {
+	if !strings.HasPrefix(p, "/") || strings.HasPrefix(p, "//") {
+		return false
+	}
+	for i := 0; i < len(p); i++ {
+		if c := p[i]; c < 0x20 || c == 0x7f || c == '\\' {
+			return false
+		}
+	}
+	return true
+}
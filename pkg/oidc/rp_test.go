@@ -0,0 +1,376 @@
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/crossid/crossid-go/pkg/x/testx"
+	"github.com/golang-jwt/jwt/v4"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+var rsaKey, _ = rsa.GenerateKey(rand.Reader, 2048)
+
+// authRequest is an authorization request received by the fake authorization server.
+type authRequest struct {
+	params url.Values
+}
+
+// fakeAuthServer is an in-process authorization server that issues a code to any authorization request.
+type fakeAuthServer struct {
+	*httptest.Server
+
+	mu    sync.Mutex
+	codes map[string]*authRequest
+	// claims alter the claims of the issued ID token
+	claims jwt.MapClaims
+	// tokenRequest is the last token request
+	tokenRequest url.Values
+}
+
+func newFakeAuthServer(t *testing.T) *fakeAuthServer {
+	s := &fakeAuthServer{codes: map[string]*authRequest{}}
+	mux := http.NewServeMux()
+	s.Server = httptest.NewServer(mux)
+
+	mux.HandleFunc(DiscoveryPath, func(w http.ResponseWriter, r *http.Request) {
+		testx.AssertNoError(t, json.NewEncoder(w).Encode(&ProviderMetadata{
+			Issuer:                           s.URL,
+			AuthorizationEndpoint:            s.URL + "/authorize",
+			TokenEndpoint:                    s.URL + "/token",
+			EndSessionEndpoint:               s.URL + "/logout",
+			JWKSURI:                          s.URL + "/jwks.json",
+			IDTokenSigningAlgValuesSupported: []string{"RS256"},
+		}))
+	})
+	mux.HandleFunc("/jwks.json", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = fmt.Fprintf(w, `{"keys": [{"kty": "RSA", "kid": "k1", "alg": "RS256", "use": "sig", "n": "%s", "e": "%s"}]}`,
+			base64.RawURLEncoding.EncodeToString(rsaKey.N.Bytes()),
+			base64.RawURLEncoding.EncodeToString(big.NewInt(int64(rsaKey.E)).Bytes()))
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		testx.AssertNoError(t, r.ParseForm())
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.tokenRequest = r.PostForm
+
+		ar, ok := s.codes[r.PostForm.Get("code")]
+		delete(s.codes, r.PostForm.Get("code"))
+		if !ok || codeChallenge(r.PostForm.Get("code_verifier")) != ar.params.Get("code_challenge") {
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`{"error": "invalid_grant", "error_description": "invalid code or verifier"}`))
+			return
+		}
+		if id, secret, _ := r.BasicAuth(); id != ar.params.Get("client_id") || secret != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			_, _ = w.Write([]byte(`{"error": "invalid_client"}`))
+			return
+		}
+
+		at := "access-token"
+		atHash, _ := HalfHash("RS256", at)
+		claims := jwt.MapClaims{
+			"iss":       s.URL,
+			"sub":       "alice",
+			"aud":       ar.params.Get("client_id"),
+			"exp":       time.Now().Add(time.Hour).Unix(),
+			"iat":       time.Now().Unix(),
+			"auth_time": time.Now().Unix(),
+			"nonce":     ar.params.Get("nonce"),
+			"at_hash":   atHash,
+		}
+		for k, v := range s.claims {
+			claims[k] = v
+		}
+		tok := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+		tok.Header["kid"] = "k1"
+		idt, err := tok.SignedString(rsaKey)
+		testx.AssertNoError(t, err)
+
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"access_token": at,
+			"token_type":   "Bearer",
+			"expires_in":   3600,
+			"id_token":     idt,
+		})
+	})
+
+	return s
+}
+
+// authorize simulates the user agent following the authorization request to the server,
+// it returns the callback URL the server redirects to.
+func (s *fakeAuthServer) authorize(t *testing.T, location string) string {
+	u, err := url.Parse(location)
+	testx.AssertNoError(t, err)
+	testx.AssertTrue(t, u.Path == "/authorize", "expected a redirect to the authorization endpoint")
+
+	code := randomString()
+	s.mu.Lock()
+	s.codes[code] = &authRequest{params: u.Query()}
+	s.mu.Unlock()
+
+	return fmt.Sprintf("%s?code=%s&state=%s", u.Query().Get("redirect_uri"), code, u.Query().Get("state"))
+}
+
+func TestRelyingParty(t *testing.T) {
+	srv := newFakeAuthServer(t)
+	defer srv.Close()
+
+	p, err := NewProvider(context.Background(), srv.URL, &ProviderOpts{RefreshInterval: -1})
+	testx.AssertNoError(t, err)
+	defer p.Close()
+
+	for k, tc := range []struct {
+		returnTo string
+		// tamper alters the callback request
+		tamper func(r *http.Request) *http.Request
+		claims jwt.MapClaims
+		maxAge time.Duration
+		// err is the expected error, nil if login should succeed
+		err      error
+		redirect string
+	}{
+		{returnTo: "/orders?id=1", redirect: "/orders?id=1"},
+		// open redirects are prevented
+		{returnTo: "https://evil.io", redirect: "/"},
+		{returnTo: "//evil.io", redirect: "/"},
+		{returnTo: "/\t/evil.io", redirect: "/"},
+		{returnTo: "/%09/evil.io", redirect: "/"},
+		{
+			tamper: func(r *http.Request) *http.Request {
+				r.Header.Del("Cookie")
+				return r
+			},
+			err: ErrInvalidState,
+		},
+		{
+			tamper: func(r *http.Request) *http.Request {
+				c := r.Cookies()[0]
+				r.Header.Del("Cookie")
+				r.AddCookie(&http.Cookie{Name: c.Name, Value: "x" + c.Value})
+				return r
+			},
+			err: ErrInvalidState,
+		},
+		{
+			tamper: func(r *http.Request) *http.Request {
+				q := r.URL.Query()
+				q.Set("code", "stolen")
+				r.URL.RawQuery = q.Encode()
+				return r
+			},
+			err: &AuthError{},
+		},
+		{
+			tamper: func(r *http.Request) *http.Request {
+				q := r.URL.Query()
+				q.Set("error", "access_denied")
+				r.URL.RawQuery = q.Encode()
+				return r
+			},
+			err: &AuthError{},
+		},
+		{claims: jwt.MapClaims{"nonce": "replayed"}, err: ErrInvalidIDToken},
+		{claims: jwt.MapClaims{"at_hash": "invalid"}, err: ErrInvalidIDToken},
+		{claims: jwt.MapClaims{"aud": "other"}, err: ErrInvalidIDToken},
+		{claims: jwt.MapClaims{"iss": "https://evil.io"}, err: ErrInvalidIDToken},
+		{claims: jwt.MapClaims{"auth_time": time.Now().Add(-time.Hour).Unix()}, maxAge: time.Minute, err: ErrInvalidIDToken},
+		{claims: jwt.MapClaims{"c_hash": "invalid"}, err: ErrInvalidIDToken},
+	} {
+		t.Run(fmt.Sprintf("case=%d", k), func(t *testing.T) {
+			srv.mu.Lock()
+			srv.claims = tc.claims
+			srv.mu.Unlock()
+
+			var got *LoginResult
+			var gotErr error
+			rp, err := NewRelyingParty(p, &RelyingPartyOpts{
+				ClientID:     "app",
+				ClientSecret: "secret",
+				RedirectURL:  "https://app.example.com/callback",
+				Scopes:       []string{"profile"},
+				AuthParams:   url.Values{"audience": {"api"}},
+				CookieSecret: []byte(strings.Repeat("k", 32)),
+				MaxAge:       tc.maxAge,
+				PostLogin: func(w http.ResponseWriter, r *http.Request, res *LoginResult) {
+					got = res
+					http.Redirect(w, r, res.ReturnTo, http.StatusFound)
+				},
+				ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+					gotErr = err
+					w.WriteHeader(http.StatusBadRequest)
+				},
+			})
+			testx.AssertNoError(t, err)
+
+			w := httptest.NewRecorder()
+			rp.LoginHandler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/login?return_to="+url.QueryEscape(tc.returnTo), nil))
+			testx.AssertTrue(t, w.Code == http.StatusFound, "expected login to redirect")
+
+			location := w.Header().Get("Location")
+			q, _ := url.ParseQuery(location[strings.Index(location, "?")+1:])
+			testx.AssertTrue(t, q.Get("code_challenge_method") == "S256" && q.Get("code_challenge") != "", "expected PKCE")
+			testx.AssertTrue(t, q.Get("scope") == "openid profile" && q.Get("audience") == "api", "unexpected authorization request")
+
+			r := httptest.NewRequest(http.MethodGet, srv.authorize(t, location), nil)
+			for _, c := range w.Result().Cookies() {
+				testx.AssertTrue(t, c.HttpOnly && c.Secure && c.SameSite == http.SameSiteLaxMode, "expected a secure cookie")
+				r.AddCookie(c)
+			}
+			if tc.tamper != nil {
+				r = tc.tamper(r)
+			}
+
+			w = httptest.NewRecorder()
+			rp.CallbackHandler().ServeHTTP(w, r)
+
+			if tc.err != nil {
+				var ae *AuthError
+				if errors.As(tc.err, &ae) {
+					testx.AssertTrue(t, errors.As(gotErr, &ae), fmt.Sprintf("expected an AuthError but got %v", gotErr))
+				} else {
+					testx.AssertTrue(t, errors.Is(gotErr, tc.err), fmt.Sprintf("expected %v but got %v", tc.err, gotErr))
+				}
+				testx.AssertTrue(t, got == nil, "expected PostLogin not to be called")
+				return
+			}
+
+			testx.AssertNoError(t, gotErr)
+			testx.AssertTrue(t, w.Header().Get("Location") == tc.redirect, fmt.Sprintf("expected a redirect to %s", tc.redirect))
+			testx.AssertTrue(t, got.IDToken.Subject == "alice" && got.Tokens.AccessToken == "access-token", "unexpected login result")
+			testx.AssertTrue(t, srv.tokenRequest.Get("redirect_uri") == "https://app.example.com/callback", "unexpected token request")
+
+			// the code is single use
+			gotErr = nil
+			rp.CallbackHandler().ServeHTTP(httptest.NewRecorder(), r)
+			testx.AssertTrue(t, gotErr != nil, "expected a replayed callback to fail")
+		})
+	}
+}
+
+func TestLocalPath(t *testing.T) {
+	for k, tc := range []struct {
+		p        string
+		expected string
+	}{
+		{p: "/orders?id=1#top", expected: "/orders?id=1#top"},
+		{p: "/a/b%20c", expected: "/a/b%20c"},
+		{p: ""},
+		{p: "orders"},
+		{p: "https://evil.io"},
+		{p: "//evil.io"},
+		{p: "/\\evil.io"},
+		{p: "/\t/evil.io"},
+		{p: "/\n/evil.io"},
+		{p: "/\r/evil.io"},
+		{p: "/%09/evil.io"},
+		{p: "/%5C/evil.io"},
+		{p: "/%2F/evil.io"},
+		{p: "/orders\x7f"},
+	} {
+		t.Run(fmt.Sprintf("case=%d", k), func(t *testing.T) {
+			expected := tc.expected
+			if expected == "" {
+				expected = "/"
+			}
+			got := localPath(tc.p)
+			testx.AssertTrue(t, got == expected, fmt.Sprintf("expected %q but got %q", expected, got))
+		})
+	}
+}
+
+func TestRelyingParty_Logout(t *testing.T) {
+	srv := newFakeAuthServer(t)
+	defer srv.Close()
+
+	p, err := NewProvider(context.Background(), srv.URL, &ProviderOpts{RefreshInterval: -1})
+	testx.AssertNoError(t, err)
+	defer p.Close()
+
+	loggedOut := false
+	rp, err := NewRelyingParty(p, &RelyingPartyOpts{
+		ClientID:              "app",
+		RedirectURL:           "https://app.example.com/callback",
+		CookieSecret:          []byte(strings.Repeat("k", 32)),
+		PostLogoutRedirectURL: "https://app.example.com/",
+		IDTokenHint:           func(r *http.Request) string { return "id-token" },
+		OnLogout:              func(w http.ResponseWriter, r *http.Request) { loggedOut = true },
+		LogoutCSRFToken:       func(r *http.Request) string { return "csrf" },
+	})
+	testx.AssertNoError(t, err)
+
+	logout := func(method, csrf string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, "/logout", strings.NewReader(url.Values{"csrf_token": {csrf}}.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		rp.LogoutHandler().ServeHTTP(w, r)
+		return w
+	}
+
+	// a logout must be posted with the csrf token of the session
+	w := logout(http.MethodGet, "csrf")
+	testx.AssertTrue(t, w.Code == http.StatusMethodNotAllowed && w.Header().Get("Allow") == http.MethodPost, fmt.Sprintf("expected 405 but got %d", w.Code))
+	for _, csrf := range []string{"", "other"} {
+		w = logout(http.MethodPost, csrf)
+		testx.AssertTrue(t, w.Code == http.StatusForbidden, fmt.Sprintf("expected 403 but got %d", w.Code))
+	}
+	testx.AssertTrue(t, !loggedOut, "expected OnLogout not to be called")
+
+	w = logout(http.MethodPost, "csrf")
+	u, err := url.Parse(w.Header().Get("Location"))
+	testx.AssertNoError(t, err)
+	testx.AssertTrue(t, loggedOut, "expected OnLogout to be called")
+	testx.AssertTrue(t, u.Path == "/logout" && u.Query().Get("id_token_hint") == "id-token", "expected a redirect to the end session endpoint")
+	testx.AssertTrue(t, u.Query().Get("post_logout_redirect_uri") == "https://app.example.com/", "expected a post logout redirect uri")
+}
+
+func TestRelyingParty_LogoutSameSiteStrict(t *testing.T) {
+	srv := newFakeAuthServer(t)
+	defer srv.Close()
+
+	p, err := NewProvider(context.Background(), srv.URL, &ProviderOpts{RefreshInterval: -1})
+	testx.AssertNoError(t, err)
+	defer p.Close()
+
+	o := &RelyingPartyOpts{ClientID: "app", RedirectURL: "https://app.example.com/callback", CookieSecret: []byte(strings.Repeat("k", 32))}
+	rp, err := NewRelyingParty(p, o)
+	testx.AssertNoError(t, err)
+	func() {
+		defer func() {
+			testx.AssertTrue(t, recover() != nil, "expected a logout handler without CSRF protection to panic")
+		}()
+		rp.LogoutHandler()
+	}()
+
+	o.SameSiteStrictSession = true
+	rp, err = NewRelyingParty(p, o)
+	testx.AssertNoError(t, err)
+	w := httptest.NewRecorder()
+	rp.LogoutHandler().ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/logout", nil))
+	testx.AssertTrue(t, w.Code == http.StatusFound, fmt.Sprintf("expected 302 but got %d", w.Code))
+}
+
+func TestNewRelyingParty_Errors(t *testing.T) {
+	for k, o := range []*RelyingPartyOpts{
+		{RedirectURL: "https://app.example.com/callback", CookieSecret: make([]byte, 32)},
+		{ClientID: "app", CookieSecret: make([]byte, 32)},
+		{ClientID: "app", RedirectURL: "https://app.example.com/callback", CookieSecret: make([]byte, 16)},
+	} {
+		t.Run(fmt.Sprintf("case=%d", k), func(t *testing.T) {
+			_, err := NewRelyingParty(nil, o)
+			testx.AssertError(t, err)
+		})
+	}
+}
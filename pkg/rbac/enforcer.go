@@ -0,0 +1,250 @@
+package rbac
+
+import (
+	"context"
+	"fmt"
+	"github.com/crossid/crossid-go/pkg/jwtmw"
+	"github.com/golang-jwt/jwt/v4"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// EnforcerOpts describes the options of an Enforcer
+type EnforcerOpts struct {
+	// RoleResolver extracts the roles of a token, defaults to ClaimsRoleResolver("roles").
+	RoleResolver RoleResolver
+	// TokenCtxKey is the context key of the token put in context by the JWT middleware, defaults to jwtmw.TokenCtxKey.
+	TokenCtxKey interface{}
+	// ErrorWriter writes an error into w, defaults to a plain text 403 (e.g., jwtmw.NewBearerErrorWriter() for RFC 6750 errors).
+	ErrorWriter func(w http.ResponseWriter, r *http.Request, err error)
+	// Logger logs various messages
+	Logger func(level jwtmw.Level, format string, args ...interface{})
+	// ReloadInterval is the interval a policy file is checked for changes, defaults to 10 seconds.
+	// a negative value disables reloads.
+	ReloadInterval time.Duration
+	// ReloadErrorHandler is called when a changed policy file cannot be loaded, the previous policy remains in use.
+	ReloadErrorHandler func(err error)
+}
+
+func mergeEnforcerOpts(opts ...*EnforcerOpts) *EnforcerOpts {
+	opt := EnforcerOpts{
+		RoleResolver: ClaimsRoleResolver("roles"),
+		TokenCtxKey:  jwtmw.TokenCtxKey,
+		ErrorWriter: func(w http.ResponseWriter, r *http.Request, err error) {
+			http.Error(w, err.Error(), http.StatusForbidden)
+		},
+		Logger:             func(level jwtmw.Level, format string, args ...interface{}) {},
+		ReloadInterval:     10 * time.Second,
+		ReloadErrorHandler: func(err error) {},
+	}
+
+	for _, o := range opts {
+		if o == nil {
+			continue
+		}
+		if o.RoleResolver != nil {
+			opt.RoleResolver = o.RoleResolver
+		}
+		if o.TokenCtxKey != nil {
+			opt.TokenCtxKey = o.TokenCtxKey
+		}
+		if o.ErrorWriter != nil {
+			opt.ErrorWriter = o.ErrorWriter
+		}
+		if o.Logger != nil {
+			opt.Logger = o.Logger
+		}
+		if o.ReloadInterval != 0 {
+			opt.ReloadInterval = o.ReloadInterval
+		}
+		if o.ReloadErrorHandler != nil {
+			opt.ReloadErrorHandler = o.ReloadErrorHandler
+		}
+	}
+
+	return &opt
+}
+
+// PermissionError is returned when none of the roles grants the required permission.
+// It matches jwtmw.ErrMissingClaim when tested by errors.Is.
+type PermissionError struct {
+	// Permission is the required permission.
+	Permission string
+	// Roles are the roles of the token.
+	Roles []string
+}
+
+func (e *PermissionError) Error() string {
+	return fmt.Sprintf("%s: permission '%s' is not granted", jwtmw.ErrMissingClaim, e.Permission)
+}
+
+func (e *PermissionError) Is(target error) bool {
+	return target == jwtmw.ErrMissingClaim
+}
+
+// Enforcer enforces a policy that may be replaced at runtime.
+// It is safe for concurrent use.
+type Enforcer struct {
+	opts EnforcerOpts
+
+	mu     sync.RWMutex
+	policy *Policy
+
+	// path, modTime and size of the policy file, if loaded from a file
+	path    string
+	modTime time.Time
+	size    int64
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// NewEnforcer returns an Enforcer of p, which may be replaced by SetPolicy.
+func NewEnforcer(p *Policy, opts ...*EnforcerOpts) *Enforcer {
+	done := make(chan struct{})
+	close(done)
+	return &Enforcer{
+		opts:   *mergeEnforcerOpts(opts...),
+		policy: p,
+		cancel: func() {},
+		done:   done,
+	}
+}
+
+// NewFileEnforcer loads the policy file at path and reloads it in the background whenever it changes.
+// Close should be called once the enforcer is no longer needed to stop the background reload.
+func NewFileEnforcer(path string, opts ...*EnforcerOpts) (*Enforcer, error) {
+	e := &Enforcer{
+		opts: *mergeEnforcerOpts(opts...),
+		path: path,
+		done: make(chan struct{}),
+	}
+
+	if _, err := e.Reload(); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	e.cancel = cancel
+	go e.reloadLoop(ctx)
+
+	return e, nil
+}
+
+// Policy returns the policy in use.
+func (e *Enforcer) Policy() *Policy {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.policy
+}
+
+// SetPolicy replaces the policy in use.
+func (e *Enforcer) SetPolicy(p *Policy) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.policy = p
+}
+
+// Reload loads the policy file if it changed since it was last loaded, it returns true if the policy was replaced.
+func (e *Enforcer) Reload() (bool, error) {
+	if e.path == "" {
+		return false, nil
+	}
+
+	fi, err := os.Stat(e.path)
+	if err != nil {
+		return false, err
+	}
+
+	e.mu.RLock()
+	unchanged := e.policy != nil && fi.ModTime().Equal(e.modTime) && fi.Size() == e.size
+	e.mu.RUnlock()
+	if unchanged {
+		return false, nil
+	}
+
+	p, err := LoadPolicyFile(e.path)
+	if err != nil {
+		return false, err
+	}
+
+	e.mu.Lock()
+	e.policy = p
+	e.modTime = fi.ModTime()
+	e.size = fi.Size()
+	e.mu.Unlock()
+
+	return true, nil
+}
+
+// Close stops the background reload.
+func (e *Enforcer) Close() {
+	e.cancel()
+	<-e.done
+}
+
+func (e *Enforcer) reloadLoop(ctx context.Context) {
+	defer close(e.done)
+	if e.opts.ReloadInterval < 0 {
+		return
+	}
+
+	t := time.NewTicker(e.opts.ReloadInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			if reloaded, err := e.Reload(); err != nil {
+				e.opts.ReloadErrorHandler(err)
+			} else if reloaded {
+				e.opts.Logger(jwtmw.Info, "policy %s reloaded", e.path)
+			}
+		}
+	}
+}
+
+// Allowed returns nil if the roles of t grant perm, otherwise a *PermissionError.
+func (e *Enforcer) Allowed(ctx context.Context, t *jwt.Token, perm string) error {
+	roles, err := e.opts.RoleResolver(ctx, t)
+	if err != nil {
+		return fmt.Errorf("%w: %s", jwtmw.ErrExtractingClaims, err)
+	}
+
+	if !e.Policy().Allowed(roles, perm) {
+		return &PermissionError{Permission: perm, Roles: roles}
+	}
+
+	return nil
+}
+
+// RequirePermission returns a middleware that requires the roles of the token, put in context
+// by the JWT middleware, to grant perm (e.g., "invoice:approve").
+func (e *Enforcer) RequirePermission(perm string) func(next http.Handler) http.Handler {
+	if strings.TrimSpace(perm) == "" {
+		panic("permission must be set.")
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			tok, ok := r.Context().Value(e.opts.TokenCtxKey).(*jwt.Token)
+			if !ok {
+				e.opts.Logger(jwtmw.Info, "missing token")
+				e.opts.ErrorWriter(w, r, jwtmw.ErrMissingToken)
+				return
+			}
+
+			if err := e.Allowed(r.Context(), tok, perm); err != nil {
+				e.opts.Logger(jwtmw.Info, "permission errors: %s", err)
+				e.opts.ErrorWriter(w, r, err)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
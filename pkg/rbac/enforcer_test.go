@@ -0,0 +1,144 @@
+package rbac
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/crossid/crossid-go/pkg/jwtmw"
+	"github.com/crossid/crossid-go/pkg/x/testx"
+	"github.com/golang-jwt/jwt/v4"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+var secret = []byte("secret")
+
+func serve(t *testing.T, h http.Handler, claims jwt.MapClaims) *httptest.ResponseRecorder {
+	raw, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(secret)
+	testx.AssertNoError(t, err)
+
+	r, err := http.NewRequest(http.MethodGet, "/", nil)
+	testx.AssertNoError(t, err)
+	r.Header.Set(jwtmw.BearerHeaderKey, "Bearer "+raw)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+func protect(e *Enforcer, perm string) http.Handler {
+	jmw := jwtmw.NewJWT(&jwtmw.JwtMiddlewareOpts{
+		KeyFunc: func(_ context.Context, _ *jwt.Token) (interface{}, error) {
+			return secret, nil
+		},
+	})
+	return jmw.Handler(e.RequirePermission(perm)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})))
+}
+
+func TestEnforcer_RequirePermission(t *testing.T) {
+	p, err := ParsePolicy([]byte(testPolicy))
+	testx.AssertNoError(t, err)
+
+	var got error
+	e := NewEnforcer(p, &EnforcerOpts{
+		RoleResolver: ClaimsRoleResolver("roles", "realm_access.roles"),
+		ErrorWriter: func(w http.ResponseWriter, r *http.Request, err error) {
+			got = err
+			w.WriteHeader(http.StatusForbidden)
+		},
+	})
+	h := protect(e, "invoice:approve")
+
+	for k, tc := range []struct {
+		claims jwt.MapClaims
+		code   int
+	}{
+		{claims: jwt.MapClaims{"roles": []string{"accountant"}}, code: http.StatusOK},
+		{claims: jwt.MapClaims{"roles": "viewer controller"}, code: http.StatusOK},
+		{claims: jwt.MapClaims{"realm_access": map[string]interface{}{"roles": []string{"admin"}}}, code: http.StatusOK},
+		{claims: jwt.MapClaims{"roles": []string{"viewer"}}, code: http.StatusForbidden},
+		{claims: jwt.MapClaims{}, code: http.StatusForbidden},
+	} {
+		t.Run(fmt.Sprintf("case=%d", k), func(t *testing.T) {
+			w := serve(t, h, tc.claims)
+			testx.AssertTrue(t, w.Code == tc.code, fmt.Sprintf("expected code %d but got %d", tc.code, w.Code))
+		})
+	}
+
+	testx.AssertTrue(t, errors.Is(got, jwtmw.ErrMissingClaim), "expected error to be ErrMissingClaim")
+	var pe *PermissionError
+	testx.AssertTrue(t, errors.As(got, &pe) && pe.Permission == "invoice:approve", "expected a *PermissionError")
+}
+
+func TestPrincipalRoleResolver(t *testing.T) {
+	p, err := ParsePolicy([]byte(testPolicy))
+	testx.AssertNoError(t, err)
+
+	h := protect(NewEnforcer(p, &EnforcerOpts{RoleResolver: PrincipalRoleResolver()}), "invoice:approve")
+	w := serve(t, h, jwt.MapClaims{"roles": []string{"accountant"}})
+	testx.AssertTrue(t, w.Code == http.StatusOK, fmt.Sprintf("expected code 200 but got %d", w.Code))
+	w = serve(t, h, jwt.MapClaims{"roles": []string{"viewer"}})
+	testx.AssertTrue(t, w.Code == http.StatusForbidden, fmt.Sprintf("expected code 403 but got %d", w.Code))
+
+	// the principal in context is not used for another token
+	e := NewEnforcer(p, &EnforcerOpts{RoleResolver: PrincipalRoleResolver()})
+	other := &jwt.Token{Claims: jwt.MapClaims{"roles": []string{"viewer"}}}
+	var got error
+	h = jwtmw.NewJWT(&jwtmw.JwtMiddlewareOpts{
+		KeyFunc: func(_ context.Context, _ *jwt.Token) (interface{}, error) {
+			return secret, nil
+		},
+	}).Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = e.Allowed(r.Context(), other, "invoice:approve")
+	}))
+	w = serve(t, h, jwt.MapClaims{"roles": []string{"accountant"}})
+	testx.AssertTrue(t, w.Code == http.StatusOK, fmt.Sprintf("expected code 200 but got %d", w.Code))
+	testx.AssertTrue(t, errors.Is(got, jwtmw.ErrMissingClaim), fmt.Sprintf("expected the roles of the other token, got %v", got))
+}
+
+func TestNewFileEnforcer_Reload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rbac")
+	testx.AssertNoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "policy.yaml")
+	testx.AssertNoError(t, ioutil.WriteFile(path, []byte(testPolicy), 0600))
+
+	errs := make(chan error, 10)
+	e, err := NewFileEnforcer(path, &EnforcerOpts{
+		ReloadInterval: 10 * time.Millisecond,
+		ReloadErrorHandler: func(err error) {
+			select {
+			case errs <- err:
+			default:
+			}
+		},
+	})
+	testx.AssertNoError(t, err)
+	defer e.Close()
+
+	h := protect(e, "invoice:approve")
+	claims := jwt.MapClaims{"roles": []string{"viewer"}}
+	testx.AssertTrue(t, serve(t, h, claims).Code == http.StatusForbidden, "expected viewer to be forbidden")
+
+	// an invalid policy keeps the previous one
+	testx.AssertNoError(t, ioutil.WriteFile(path, []byte("roles:\n  viewer:\n    inherits: [missing]\n"), 0600))
+	select {
+	case <-errs:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected a reload error")
+	}
+	testx.AssertTrue(t, e.Policy().Allowed([]string{"accountant"}, "invoice:approve"), "expected previous policy to remain in use")
+
+	testx.AssertNoError(t, ioutil.WriteFile(path, []byte("roles:\n  viewer:\n    permissions: [invoice:approve]\n"), 0600))
+	deadline := time.Now().Add(5 * time.Second)
+	for serve(t, h, claims).Code != http.StatusOK {
+		if time.Now().After(deadline) {
+			t.Fatal("expected policy to be reloaded")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,145 @@
+/*
+Package rbac provides role based access control where roles, typically carried by a token's claims,
+are mapped to permissions by a policy, and a middleware that requires a permission.
+*/
+package rbac
+
+import (
+	"fmt"
+	"github.com/crossid/crossid-go/pkg/jwtmw"
+	"gopkg.in/yaml.v3"
+	"io/ioutil"
+	"sort"
+)
+
+// Policy maps roles to permissions, e.g., in YAML:
+//
+//	roles:
+//	  viewer:
+//	    permissions: [invoice:read]
+//	  accountant:
+//	    inherits: [viewer]
+//	    permissions: [invoice:approve]
+//	  admin:
+//	    permissions: ["*"]
+//
+// Permissions may use trailing wildcards (e.g., "invoice:*"), see jwtmw.ScopeMatcher.
+type Policy struct {
+	Roles map[string]*Role `yaml:"roles" json:"roles"`
+
+	// permissions are the effective permissions of each role, including inherited ones
+	permissions map[string][]string
+	matcher     *jwtmw.ScopeMatcher
+}
+
+// Role is a named set of permissions.
+type Role struct {
+	// Inherits are roles whose permissions are granted by this role as well.
+	Inherits []string `yaml:"inherits" json:"inherits"`
+	// Permissions are the permissions granted by this role.
+	Permissions []string `yaml:"permissions" json:"permissions"`
+}
+
+// ParsePolicy parses a YAML or JSON encoded policy.
+// it fails if a role inherits an unknown role or if the inheritance is cyclic.
+func ParsePolicy(b []byte) (*Policy, error) {
+	p := new(Policy)
+	if err := yaml.Unmarshal(b, p); err != nil {
+		return nil, fmt.Errorf("error decoding policy: %w", err)
+	}
+
+	if err := p.compile(); err != nil {
+		return nil, err
+	}
+
+	return p, nil
+}
+
+// LoadPolicyFile parses the policy file at path.
+func LoadPolicyFile(path string) (*Policy, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return ParsePolicy(b)
+}
+
+// Permissions returns the effective permissions of role, including inherited ones.
+func (p *Policy) Permissions(role string) []string {
+	return p.permissions[role]
+}
+
+// Allowed returns true if any of roles grants perm, unknown roles are ignored.
+func (p *Policy) Allowed(roles []string, perm string) bool {
+	for _, r := range roles {
+		if p.matcher.Contains(p.permissions[r], perm) {
+			return true
+		}
+	}
+	return false
+}
+
+// compile resolves the inheritance of roles
+func (p *Policy) compile() error {
+	p.permissions = make(map[string][]string, len(p.Roles))
+	p.matcher = jwtmw.NewScopeMatcher()
+
+	const (
+		visiting = 1
+		visited  = 2
+	)
+	state := map[string]int{}
+
+	var visit func(name string, path []string) error
+	visit = func(name string, path []string) error {
+		switch state[name] {
+		case visited:
+			return nil
+		case visiting:
+			return fmt.Errorf("cyclic role inheritance %v", append(path, name))
+		}
+
+		r, ok := p.Roles[name]
+		if !ok || r == nil {
+			return fmt.Errorf("role '%s' inherited by '%s' is not defined", name, path[len(path)-1])
+		}
+
+		state[name] = visiting
+		set := map[string]struct{}{}
+		for _, perm := range r.Permissions {
+			set[perm] = struct{}{}
+		}
+		for _, parent := range r.Inherits {
+			if err := visit(parent, append(path, name)); err != nil {
+				return err
+			}
+			for _, perm := range p.permissions[parent] {
+				set[perm] = struct{}{}
+			}
+		}
+		state[name] = visited
+
+		perms := make([]string, 0, len(set))
+		for perm := range set {
+			perms = append(perms, perm)
+		}
+		sort.Strings(perms)
+		p.permissions[name] = perms
+
+		return nil
+	}
+
+	for name, r := range p.Roles {
+		if r == nil {
+			p.Roles[name] = &Role{}
+		}
+	}
+	for name := range p.Roles {
+		if err := visit(name, nil); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,66 @@
+package rbac
+
+import (
+	"fmt"
+	"github.com/crossid/crossid-go/pkg/x/testx"
+	"strings"
+	"testing"
+)
+
+const testPolicy = `
+roles:
+  viewer:
+    permissions: [invoice:read]
+  accountant:
+    inherits: [viewer]
+    permissions: [invoice:approve]
+  controller:
+    inherits: [accountant]
+    permissions: ["report:*"]
+  admin:
+    permissions: ["*"]
+`
+
+func TestParsePolicy(t *testing.T) {
+	p, err := ParsePolicy([]byte(testPolicy))
+	testx.AssertNoError(t, err)
+	testx.AssertTrue(t, strings.Join(p.Permissions("controller"), " ") == "invoice:approve invoice:read report:*", fmt.Sprintf("unexpected permissions %v", p.Permissions("controller")))
+
+	for k, tc := range []struct {
+		roles []string
+		perm  string
+		v     bool
+	}{
+		{roles: []string{"viewer"}, perm: "invoice:read", v: true},
+		{roles: []string{"viewer"}, perm: "invoice:approve"},
+		{roles: []string{"accountant"}, perm: "invoice:read", v: true},
+		{roles: []string{"accountant"}, perm: "invoice:approve", v: true},
+		{roles: []string{"controller"}, perm: "report:export", v: true},
+		{roles: []string{"accountant"}, perm: "report:export"},
+		{roles: []string{"unknown", "viewer"}, perm: "invoice:read", v: true},
+		{roles: []string{"admin"}, perm: "anything:at:all", v: true},
+		{roles: nil, perm: "invoice:read"},
+	} {
+		t.Run(fmt.Sprintf("case=%d", k), func(t *testing.T) {
+			testx.AssertTrue(t, p.Allowed(tc.roles, tc.perm) == tc.v, fmt.Sprintf("expected %v to be allowed %s: %v", tc.roles, tc.perm, tc.v))
+		})
+	}
+}
+
+func TestParsePolicy_JSON(t *testing.T) {
+	p, err := ParsePolicy([]byte(`{"roles": {"viewer": {"permissions": ["invoice:read"]}, "editor": {"inherits": ["viewer"]}}}`))
+	testx.AssertNoError(t, err)
+	testx.AssertTrue(t, p.Allowed([]string{"editor"}, "invoice:read"), "expected inherited permission")
+}
+
+func TestParsePolicy_Errors(t *testing.T) {
+	for _, src := range []string{
+		"roles: [",
+		"roles:\n  a:\n    inherits: [b]\n",
+		"roles:\n  a:\n    inherits: [b]\n  b:\n    inherits: [a]\n",
+		"roles:\n  a:\n    inherits: [a]\n",
+	} {
+		_, err := ParsePolicy([]byte(src))
+		testx.AssertTrue(t, err != nil, fmt.Sprintf("expected policy to be invalid: %s", src))
+	}
+}
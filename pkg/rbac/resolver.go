@@ -0,0 +1,61 @@
+package rbac
+
+import (
+	"context"
+	"github.com/crossid/crossid-go/pkg/jwtmw"
+	"github.com/golang-jwt/jwt/v4"
+	"strings"
+)
+
+// RoleResolver extracts the roles of the subject of a verified token.
+type RoleResolver func(ctx context.Context, t *jwt.Token) ([]string, error)
+
+// ClaimsRoleResolver returns a RoleResolver that collects roles from the claims at paths
+// (e.g., "roles", "groups" or "realm_access.roles", see jwtmw.ClaimValue).
+// a claim is either an array of strings or a space or comma delimited string, missing claims are ignored.
+func ClaimsRoleResolver(paths ...string) RoleResolver {
+	return func(_ context.Context, t *jwt.Token) ([]string, error) {
+		mc, err := jwtmw.MapClaimsFromToken(t)
+		if err != nil {
+			return nil, err
+		}
+
+		var roles []string
+		for _, p := range paths {
+			v, ok := jwtmw.ClaimValue(mc, p)
+			if !ok {
+				continue
+			}
+			switch vv := v.(type) {
+			case []interface{}:
+				for _, r := range vv {
+					if s, ok := r.(string); ok {
+						roles = append(roles, s)
+					}
+				}
+			case []string:
+				roles = append(roles, vv...)
+			case string:
+				roles = append(roles, strings.FieldsFunc(vv, func(r rune) bool {
+					return r == ' ' || r == ','
+				})...)
+			}
+		}
+
+		return roles, nil
+	}
+}
+
+// PrincipalRoleResolver returns a RoleResolver that returns the roles of the jwtmw.Principal put in context
+// by the JWT middleware, see jwtmw.PrincipalMapperOpts.RolesClaims.
+// if there is no principal in context that was mapped from the token, the token is mapped by jwtmw.DefaultPrincipalMapper.
+func PrincipalRoleResolver() RoleResolver {
+	return func(ctx context.Context, t *jwt.Token) ([]string, error) {
+		p, err := jwtmw.PrincipalOf(ctx, t)
+		if err != nil {
+			return nil, err
+		}
+
+		return p.Roles, nil
+	}
+}
@@ -0,0 +1,149 @@
+package session
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// maxChunkSize is the maximal size of the value of a cookie, browsers limit a cookie to 4096 bytes
+	// including its name and attributes.
+	maxChunkSize = 3800
+	// maxChunks is the maximal number of cookies a session may be split into.
+	maxChunks = 10
+)
+
+var (
+	// ErrTooLarge is returned when a session exceeds the cookies it may be kept in.
+	ErrTooLarge = errors.New("session is too large to be kept in cookies")
+	// errInvalidCookie is returned when a cookie cannot be decrypted by any of the keys.
+	errInvalidCookie = errors.New("invalid session cookie")
+)
+
+// codec encrypts and authenticates cookie values by AES-256-GCM.
+// the first key encrypts while any of the keys decrypts, so keys can be rotated.
+type codec struct {
+	aeads []cipher.AEAD
+}
+
+func newCodec(keys [][]byte) (*codec, error) {
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("at least one key must be set")
+	}
+
+	c := &codec{}
+	for i, k := range keys {
+		if len(k) != 32 {
+			return nil, fmt.Errorf("key %d must be 32 bytes", i)
+		}
+		b, err := aes.NewCipher(k)
+		if err != nil {
+			return nil, err
+		}
+		aead, err := cipher.NewGCM(b)
+		if err != nil {
+			return nil, err
+		}
+		c.aeads = append(c.aeads, aead)
+	}
+
+	return c, nil
+}
+
+// encode encrypts plain into the value of the cookie name, the name is authenticated
+// so a value cannot be moved into another cookie.
+func (c *codec) encode(name string, plain []byte) (string, error) {
+	aead := c.aeads[0]
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plain)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(aead.Seal(nonce, nonce, plain, []byte(name))), nil
+}
+
+// decode decrypts the value v of the cookie name.
+func (c *codec) decode(name, v string) ([]byte, error) {
+	b, err := base64.RawURLEncoding.DecodeString(v)
+	if err != nil {
+		return nil, errInvalidCookie
+	}
+
+	for _, aead := range c.aeads {
+		if len(b) < aead.NonceSize() {
+			continue
+		}
+		if plain, err := aead.Open(nil, b[:aead.NonceSize()], b[aead.NonceSize():], []byte(name)); err == nil {
+			return plain, nil
+		}
+	}
+
+	return nil, errInvalidCookie
+}
+
+// chunkName returns the name of the i-th cookie of name, the first cookie is name itself.
+func chunkName(name string, i int) string {
+	if i == 0 {
+		return name
+	}
+	return name + "_" + strconv.Itoa(i)
+}
+
+// readChunks returns the value split into the cookies of name and the number of cookies it was read from.
+func readChunks(r *http.Request, name string) (string, int) {
+	var sb strings.Builder
+	n := 0
+	for ; n < maxChunks; n++ {
+		c, err := r.Cookie(chunkName(name, n))
+		if err != nil {
+			break
+		}
+		sb.WriteString(c.Value)
+	}
+
+	return sb.String(), n
+}
+
+// writeChunks sets the cookies of name to v, split into as many cookies as needed,
+// and deletes the cookies of a previous value that was split into prev cookies.
+// tmpl holds the attributes of the cookies, the number of cookies is returned.
+func writeChunks(w http.ResponseWriter, tmpl http.Cookie, name, v string, prev int) (int, error) {
+	n := (len(v) + maxChunkSize - 1) / maxChunkSize
+	if n > maxChunks {
+		return 0, ErrTooLarge
+	}
+
+	for i := 0; i < n; i++ {
+		end := (i + 1) * maxChunkSize
+		if end > len(v) {
+			end = len(v)
+		}
+		c := tmpl
+		c.Name = chunkName(name, i)
+		c.Value = v[i*maxChunkSize : end]
+		http.SetCookie(w, &c)
+	}
+	deleteChunks(w, tmpl, name, n, prev)
+
+	return n, nil
+}
+
+// deleteChunks deletes the cookies of name from the from-th cookie up to the to-th cookie.
+func deleteChunks(w http.ResponseWriter, tmpl http.Cookie, name string, from, to int) {
+	for i := from; i < to; i++ {
+		c := tmpl
+		c.Name = chunkName(name, i)
+		c.Value = ""
+		c.MaxAge = -1
+		c.Expires = time.Time{}
+		http.SetCookie(w, &c)
+	}
+}
@@ -0,0 +1,113 @@
+package session
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/crossid/crossid-go/pkg/x/testx"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func key(b byte) []byte {
+	return bytes.Repeat([]byte{b}, 32)
+}
+
+func TestCodec(t *testing.T) {
+	old, err := newCodec([][]byte{key(1)})
+	testx.AssertNoError(t, err)
+	rotated, err := newCodec([][]byte{key(2), key(1)})
+	testx.AssertNoError(t, err)
+	other, err := newCodec([][]byte{key(3)})
+	testx.AssertNoError(t, err)
+
+	v, err := old.encode("sid", []byte("hello"))
+	testx.AssertNoError(t, err)
+
+	for k, tc := range []struct {
+		c    *codec
+		name string
+		v    string
+		ok   bool
+	}{
+		{c: old, name: "sid", v: v, ok: true},
+		// values encrypted by a previous key are decrypted once keys are rotated
+		{c: rotated, name: "sid", v: v, ok: true},
+		{c: other, name: "sid", v: v},
+		// a value cannot be moved into another cookie
+		{c: old, name: "other", v: v},
+		{c: old, name: "sid", v: v[:len(v)-2] + "AA"},
+		{c: old, name: "sid", v: "!"},
+		{c: old, name: "sid", v: ""},
+	} {
+		t.Run(fmt.Sprintf("case=%d", k), func(t *testing.T) {
+			plain, err := tc.c.decode(tc.name, tc.v)
+			if !tc.ok {
+				testx.AssertError(t, err)
+				return
+			}
+			testx.AssertNoError(t, err)
+			testx.AssertTrue(t, string(plain) == "hello", "unexpected plain text")
+		})
+	}
+
+	// rotated keys encrypt by the first key
+	v, err = rotated.encode("sid", []byte("hello"))
+	testx.AssertNoError(t, err)
+	_, err = old.decode("sid", v)
+	testx.AssertError(t, err)
+}
+
+func TestNewCodec_Errors(t *testing.T) {
+	for k, keys := range [][][]byte{nil, {key(1)[:16]}, {key(1), []byte("short")}} {
+		t.Run(fmt.Sprintf("case=%d", k), func(t *testing.T) {
+			_, err := newCodec(keys)
+			testx.AssertError(t, err)
+		})
+	}
+}
+
+func TestChunks(t *testing.T) {
+	for k, tc := range []struct {
+		size   int
+		prev   int
+		chunks int
+		err    error
+	}{
+		{size: 10, chunks: 1},
+		{size: maxChunkSize, chunks: 1},
+		{size: maxChunkSize + 1, chunks: 2},
+		{size: 3*maxChunkSize - 1, chunks: 3},
+		// stale chunks of a larger value are deleted
+		{size: 10, prev: 4, chunks: 1},
+		{size: maxChunks*maxChunkSize + 1, err: ErrTooLarge},
+	} {
+		t.Run(fmt.Sprintf("case=%d", k), func(t *testing.T) {
+			v := strings.Repeat("x", tc.size)
+			w := httptest.NewRecorder()
+			n, err := writeChunks(w, http.Cookie{Path: "/"}, "sid", v, tc.prev)
+			if tc.err != nil {
+				testx.AssertTrue(t, err == tc.err, fmt.Sprintf("expected %v but got %v", tc.err, err))
+				return
+			}
+			testx.AssertNoError(t, err)
+			testx.AssertTrue(t, n == tc.chunks, fmt.Sprintf("expected %d chunks but got %d", tc.chunks, n))
+
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			deleted := 0
+			for _, c := range w.Result().Cookies() {
+				testx.AssertTrue(t, len(c.Value) <= maxChunkSize, "expected a chunk to fit a cookie")
+				if c.MaxAge < 0 {
+					deleted++
+					continue
+				}
+				r.AddCookie(c)
+			}
+			testx.AssertTrue(t, deleted == tc.prev-tc.chunks || tc.prev < tc.chunks, "expected stale chunks to be deleted")
+
+			got, n := readChunks(r, "sid")
+			testx.AssertTrue(t, got == v && n == tc.chunks, "expected the value to be read from its chunks")
+		})
+	}
+}
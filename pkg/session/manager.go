@@ -0,0 +1,314 @@
+package session
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// ManagerOpts describes the options of a Manager
+type ManagerOpts struct {
+	// Keys encrypt and authenticate the session cookies by AES-256-GCM, each key must be 32 bytes.
+	// the first key encrypts while any of the keys decrypts, a key is rotated by prepending a new key
+	// and removing the oldest key once the sessions it encrypted have expired.
+	Keys [][]byte
+	// Store keeps the sessions server-side, in which case the cookie holds only the session ID.
+	// defaults to nil, where sessions are kept entirely in cookies, split into multiple cookies if needed.
+	Store Store
+	// CookieName is the name of the session cookie, defaults to "crossid_session".
+	CookieName string
+	// CookiePath is the path of the session cookie, defaults to "/".
+	CookiePath string
+	// CookieDomain is the domain of the session cookie, defaults to the host of the request.
+	CookieDomain string
+	// InsecureCookie allows the session cookie to be sent over plain HTTP, for local development only.
+	InsecureCookie bool
+	// SameSite is the SameSite attribute of the session cookie, defaults to http.SameSiteLaxMode.
+	SameSite http.SameSite
+	// IdleTimeout is the duration of inactivity after which a session expires, defaults to 30 minutes.
+	// activity is recorded at most once per tenth of the timeout.
+	IdleTimeout time.Duration
+	// AbsoluteTimeout is the duration after which a session expires regardless of activity, defaults to 24 hours.
+	AbsoluteTimeout time.Duration
+	// ErrorHandler writes the failure of the middleware to load or save a session into w,
+	// defaults to a plain text 500.
+	ErrorHandler func(w http.ResponseWriter, r *http.Request, err error)
+}
+
+func mergeManagerOpts(opts ...*ManagerOpts) *ManagerOpts {
+	opt := ManagerOpts{
+		CookieName:      "crossid_session",
+		CookiePath:      "/",
+		SameSite:        http.SameSiteLaxMode,
+		IdleTimeout:     30 * time.Minute,
+		AbsoluteTimeout: 24 * time.Hour,
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		},
+	}
+
+	for _, o := range opts {
+		if o == nil {
+			continue
+		}
+		if o.Keys != nil {
+			opt.Keys = o.Keys
+		}
+		if o.Store != nil {
+			opt.Store = o.Store
+		}
+		if o.CookieName != "" {
+			opt.CookieName = o.CookieName
+		}
+		if o.CookiePath != "" {
+			opt.CookiePath = o.CookiePath
+		}
+		if o.CookieDomain != "" {
+			opt.CookieDomain = o.CookieDomain
+		}
+		if o.InsecureCookie {
+			opt.InsecureCookie = o.InsecureCookie
+		}
+		if o.SameSite != 0 {
+			opt.SameSite = o.SameSite
+		}
+		if o.IdleTimeout != 0 {
+			opt.IdleTimeout = o.IdleTimeout
+		}
+		if o.AbsoluteTimeout != 0 {
+			opt.AbsoluteTimeout = o.AbsoluteTimeout
+		}
+		if o.ErrorHandler != nil {
+			opt.ErrorHandler = o.ErrorHandler
+		}
+	}
+
+	return &opt
+}
+
+// Manager loads and saves the sessions of requests.
+// It is safe for concurrent use.
+type Manager struct {
+	opts  ManagerOpts
+	codec *codec
+	now   func() time.Time
+}
+
+// NewManager returns a new Manager.
+func NewManager(opts ...*ManagerOpts) (*Manager, error) {
+	o := mergeManagerOpts(opts...)
+	c, err := newCodec(o.Keys)
+	if err != nil {
+		return nil, fmt.Errorf("invalid session keys: %w", err)
+	}
+
+	return &Manager{opts: *o, codec: c, now: time.Now}, nil
+}
+
+// Load returns the session of r, or a new session if r has no session or its session is invalid or expired.
+// an error is returned only if the store fails.
+func (m *Manager) Load(r *http.Request) (*Session, error) {
+	v, n := readChunks(r, m.opts.CookieName)
+	s, err := m.load(r.Context(), v)
+	if err != nil {
+		return nil, err
+	}
+	if s == nil {
+		s = newSession(m.now)
+	}
+	s.chunks = n
+
+	return s, nil
+}
+
+// load returns the session of the cookie value v, nil if there is no valid session.
+func (m *Manager) load(ctx context.Context, v string) (*Session, error) {
+	if v == "" {
+		return nil, nil
+	}
+	plain, err := m.codec.decode(m.opts.CookieName, v)
+	if err != nil {
+		return nil, nil
+	}
+
+	id := ""
+	if m.opts.Store != nil {
+		id = string(plain)
+		data, ok, err := m.opts.Store.Get(ctx, id)
+		if err != nil || !ok {
+			return nil, err
+		}
+		plain = data
+	}
+
+	rec := new(record)
+	if err := json.Unmarshal(plain, rec); err != nil || (m.opts.Store != nil && rec.ID != id) {
+		return nil, nil
+	}
+	if rec.Values == nil {
+		rec.Values = map[string]string{}
+	}
+
+	s := &Session{
+		id:         rec.ID,
+		values:     rec.Values,
+		createdAt:  time.Unix(rec.CreatedAt, 0),
+		lastSeenAt: time.Unix(rec.LastSeenAt, 0),
+		loadedID:   rec.ID,
+		now:        m.now,
+	}
+	if !m.now().Before(m.expiresAt(s)) {
+		if m.opts.Store != nil {
+			return nil, m.opts.Store.Delete(ctx, id)
+		}
+		return nil, nil
+	}
+
+	return s, nil
+}
+
+// Save writes the session s into the cookies of w, and into the store if set.
+// a session is written only if it was modified, destroyed or its activity is due to be recorded,
+// and must be saved before the response headers are written.
+func (m *Manager) Save(w http.ResponseWriter, r *http.Request, s *Session) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := m.now()
+	tmpl := http.Cookie{
+		Path:     m.opts.CookiePath,
+		Domain:   m.opts.CookieDomain,
+		Secure:   !m.opts.InsecureCookie,
+		HttpOnly: true,
+		SameSite: m.opts.SameSite,
+	}
+
+	if s.destroyed {
+		if m.opts.Store != nil && s.loadedID != "" {
+			if err := m.opts.Store.Delete(r.Context(), s.loadedID); err != nil {
+				return err
+			}
+		}
+		deleteChunks(w, tmpl, m.opts.CookieName, 0, s.chunks)
+		s.loadedID, s.chunks, s.isNew, s.destroyed = "", 0, true, false
+		return nil
+	}
+
+	if !s.modified && (s.isNew || now.Sub(s.lastSeenAt) < m.opts.IdleTimeout/10) {
+		return nil
+	}
+	s.lastSeenAt = now
+	ttl := m.expiresAt(s).Sub(now)
+	if ttl < time.Second {
+		return nil
+	}
+
+	data, err := json.Marshal(s.record())
+	if err != nil {
+		return err
+	}
+	if m.opts.Store != nil {
+		if err := m.opts.Store.Set(r.Context(), s.id, data, ttl); err != nil {
+			return err
+		}
+		if s.loadedID != "" && s.loadedID != s.id {
+			if err := m.opts.Store.Delete(r.Context(), s.loadedID); err != nil {
+				return err
+			}
+		}
+		data = []byte(s.id)
+	}
+
+	v, err := m.codec.encode(m.opts.CookieName, data)
+	if err != nil {
+		return err
+	}
+	tmpl.MaxAge = int(ttl / time.Second)
+	n, err := writeChunks(w, tmpl, m.opts.CookieName, v, s.chunks)
+	if err != nil {
+		return err
+	}
+
+	s.loadedID, s.chunks, s.isNew, s.modified = s.id, n, false, false
+	return nil
+}
+
+// expiresAt returns when s expires by either its idle or its absolute timeout.
+func (m *Manager) expiresAt(s *Session) time.Time {
+	idle := s.lastSeenAt.Add(m.opts.IdleTimeout)
+	if abs := s.createdAt.Add(m.opts.AbsoluteTimeout); abs.Before(idle) {
+		return abs
+	}
+	return idle
+}
+
+// Middleware loads the session of each request into its context, retrieved by FromContext,
+// and saves it once the handler writes the response headers, or returns.
+func (m *Manager) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s, err := m.Load(r)
+		if err != nil {
+			m.opts.ErrorHandler(w, r, err)
+			return
+		}
+
+		r = r.WithContext(NewContext(r.Context(), s))
+		sw := &saveWriter{ResponseWriter: w, m: m, r: r, s: s}
+		next.ServeHTTP(sw, r)
+		sw.save()
+	})
+}
+
+// saveWriter saves a session right before the response headers are written.
+type saveWriter struct {
+	http.ResponseWriter
+	m *Manager
+	r *http.Request
+	s *Session
+	// saved is true once the session was saved
+	saved bool
+	// failed is true if the session could not be saved, in which case the error response replaces the response
+	failed bool
+}
+
+func (w *saveWriter) save() {
+	if w.saved {
+		return
+	}
+	w.saved = true
+	if err := w.m.Save(w.ResponseWriter, w.r, w.s); err != nil {
+		w.failed = true
+		w.m.opts.ErrorHandler(w.ResponseWriter, w.r, err)
+	}
+}
+
+func (w *saveWriter) WriteHeader(code int) {
+	w.save()
+	if w.failed {
+		return
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *saveWriter) Write(b []byte) (int, error) {
+	w.save()
+	if w.failed {
+		return len(b), nil
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// Flush implements http.Flusher.
+func (w *saveWriter) Flush() {
+	w.save()
+	if f, ok := w.ResponseWriter.(http.Flusher); ok && !w.failed {
+		f.Flush()
+	}
+}
+
+// Unwrap returns the underlying http.ResponseWriter.
+func (w *saveWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
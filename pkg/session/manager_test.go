@@ -0,0 +1,234 @@
+package session
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/crossid/crossid-go/pkg/x/testx"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// browser keeps the cookies of responses and sends them with requests.
+type browser struct {
+	cookies map[string]*http.Cookie
+}
+
+func newBrowser() *browser {
+	return &browser{cookies: map[string]*http.Cookie{}}
+}
+
+func (b *browser) request() *http.Request {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	for _, c := range b.cookies {
+		r.AddCookie(&http.Cookie{Name: c.Name, Value: c.Value})
+	}
+	return r
+}
+
+func (b *browser) receive(w *httptest.ResponseRecorder) {
+	for _, c := range w.Result().Cookies() {
+		if c.MaxAge < 0 {
+			delete(b.cookies, c.Name)
+			continue
+		}
+		b.cookies[c.Name] = c
+	}
+}
+
+// clock is a fake time source of a Manager.
+type clock struct {
+	now time.Time
+}
+
+func (c *clock) Now() time.Time {
+	return c.now
+}
+
+func newTestManager(t *testing.T, store Store, clk *clock) *Manager {
+	m, err := NewManager(&ManagerOpts{
+		Keys:            [][]byte{key(1)},
+		Store:           store,
+		IdleTimeout:     10 * time.Minute,
+		AbsoluteTimeout: time.Hour,
+	})
+	testx.AssertNoError(t, err)
+	m.now = clk.Now
+	return m
+}
+
+func TestManager(t *testing.T) {
+	for k, store := range []*MemoryStore{nil, NewMemoryStore()} {
+		t.Run(fmt.Sprintf("case=%d", k), func(t *testing.T) {
+			clk := &clock{now: time.Now()}
+			var m *Manager
+			if store == nil {
+				m = newTestManager(t, nil, clk)
+			} else {
+				m = newTestManager(t, store, clk)
+			}
+			b := newBrowser()
+
+			// roundTrip loads the session of the browser, calls f and saves the session.
+			roundTrip := func(f func(s *Session)) *Session {
+				r := b.request()
+				s, err := m.Load(r)
+				testx.AssertNoError(t, err)
+				f(s)
+				w := httptest.NewRecorder()
+				testx.AssertNoError(t, m.Save(w, r, s))
+				b.receive(w)
+				return s
+			}
+
+			// an unmodified new session is not saved
+			s := roundTrip(func(s *Session) {})
+			testx.AssertTrue(t, s.IsNew() && len(b.cookies) == 0, "expected no cookie")
+
+			id := roundTrip(func(s *Session) {
+				s.Set("sub", "alice")
+				s.Set("id_token", strings.Repeat("t", 3*maxChunkSize))
+			}).ID()
+			if store == nil {
+				testx.AssertTrue(t, len(b.cookies) > 1, "expected the session to be split into multiple cookies")
+			} else {
+				testx.AssertTrue(t, len(b.cookies) == 1 && store.Len() == 1, "expected the session to be kept server-side")
+			}
+
+			s = roundTrip(func(s *Session) {
+				testx.AssertTrue(t, !s.IsNew() && s.ID() == id, "expected the session to be loaded")
+				v, _ := s.Get("sub")
+				testx.AssertTrue(t, v == "alice", "expected the session values to be loaded")
+				s.Delete("id_token")
+				s.Renew()
+			})
+			testx.AssertTrue(t, s.ID() != id, "expected a renewed session id")
+			testx.AssertTrue(t, len(b.cookies) == 1, "expected stale chunks to be deleted")
+			if store != nil {
+				_, ok, _ := store.Get(context.Background(), id)
+				testx.AssertTrue(t, !ok && store.Len() == 1, "expected the previous session id to be deleted")
+			}
+
+			// activity is recorded without modifications, extending the idle timeout
+			clk.now = clk.now.Add(6 * time.Minute)
+			roundTrip(func(s *Session) {
+				testx.AssertTrue(t, !s.IsNew(), "expected the session to be loaded")
+			})
+			clk.now = clk.now.Add(6 * time.Minute)
+			roundTrip(func(s *Session) {
+				testx.AssertTrue(t, !s.IsNew(), "expected the idle timeout to be extended")
+			})
+
+			// idle timeout
+			clk.now = clk.now.Add(11 * time.Minute)
+			roundTrip(func(s *Session) {
+				testx.AssertTrue(t, s.IsNew(), "expected the session to be expired by the idle timeout")
+				s.Set("sub", "alice")
+			})
+
+			// absolute timeout
+			for i := 0; i < 6; i++ {
+				clk.now = clk.now.Add(9 * time.Minute)
+				roundTrip(func(s *Session) {
+					testx.AssertTrue(t, !s.IsNew(), "expected an active session")
+				})
+			}
+			clk.now = clk.now.Add(9 * time.Minute)
+			roundTrip(func(s *Session) {
+				testx.AssertTrue(t, s.IsNew(), "expected the session to be expired by the absolute timeout")
+				s.Set("sub", "alice")
+			})
+
+			roundTrip(func(s *Session) {
+				s.Destroy()
+			})
+			testx.AssertTrue(t, len(b.cookies) == 0, "expected the session cookies to be deleted")
+			if store != nil {
+				testx.AssertTrue(t, store.Len() == 0, "expected the session to be deleted from the store")
+			}
+			roundTrip(func(s *Session) {
+				testx.AssertTrue(t, s.IsNew(), "expected a destroyed session not to be loaded")
+			})
+		})
+	}
+}
+
+func TestManager_KeyRotation(t *testing.T) {
+	clk := &clock{now: time.Now()}
+	m := newTestManager(t, nil, clk)
+	b := newBrowser()
+
+	r := b.request()
+	s, _ := m.Load(r)
+	s.Set("sub", "alice")
+	w := httptest.NewRecorder()
+	testx.AssertNoError(t, m.Save(w, r, s))
+	b.receive(w)
+
+	for k, tc := range []struct {
+		keys [][]byte
+		ok   bool
+	}{
+		{keys: [][]byte{key(2), key(1)}, ok: true},
+		{keys: [][]byte{key(2)}},
+	} {
+		t.Run(fmt.Sprintf("case=%d", k), func(t *testing.T) {
+			rotated, err := NewManager(&ManagerOpts{Keys: tc.keys})
+			testx.AssertNoError(t, err)
+			s, err := rotated.Load(b.request())
+			testx.AssertNoError(t, err)
+			v, _ := s.Get("sub")
+			testx.AssertTrue(t, (v == "alice") == tc.ok, "unexpected session of rotated keys")
+		})
+	}
+}
+
+// failingStore is a Store that always fails.
+type failingStore struct{}
+
+func (failingStore) Get(context.Context, string) ([]byte, bool, error) {
+	return nil, false, errors.New("unavailable")
+}
+
+func (failingStore) Set(context.Context, string, []byte, time.Duration) error {
+	return errors.New("unavailable")
+}
+
+func (failingStore) Delete(context.Context, string) error {
+	return errors.New("unavailable")
+}
+
+func TestManager_Middleware(t *testing.T) {
+	clk := &clock{now: time.Now()}
+	b := newBrowser()
+
+	h := func(w http.ResponseWriter, r *http.Request) {
+		s, ok := FromContext(r.Context())
+		testx.AssertTrue(t, ok, "expected a session in the context")
+		v, _ := s.Get("n")
+		s.Set("n", v+"1")
+		_, _ = w.Write([]byte("n=" + v))
+	}
+
+	m := newTestManager(t, NewMemoryStore(), clk)
+	for i := 0; i < 3; i++ {
+		w := httptest.NewRecorder()
+		m.Middleware(http.HandlerFunc(h)).ServeHTTP(w, b.request())
+		b.receive(w)
+		testx.AssertTrue(t, w.Code == http.StatusOK && w.Body.String() == "n="+strings.Repeat("1", i), "expected the session to be saved")
+	}
+
+	// a session that is not saved replaces the response by an error
+	m = newTestManager(t, failingStore{}, clk)
+	w := httptest.NewRecorder()
+	m.Middleware(http.HandlerFunc(h)).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	testx.AssertTrue(t, w.Code == http.StatusInternalServerError && !strings.Contains(w.Body.String(), "n="), "expected an error response")
+
+	// a session that is not loaded
+	w = httptest.NewRecorder()
+	m.Middleware(http.HandlerFunc(h)).ServeHTTP(w, b.request())
+	testx.AssertTrue(t, w.Code == http.StatusInternalServerError, "expected an error response")
+}
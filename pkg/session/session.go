@@ -0,0 +1,156 @@
+/*
+Package session provides browser sessions that are either kept entirely in encrypted cookies,
+or kept in a server-side Store and referenced by an encrypted cookie.
+*/
+package session
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"sync"
+	"time"
+)
+
+// Session is the session of a browser, it is loaded and saved by a Manager.
+// It is safe for concurrent use.
+type Session struct {
+	mu sync.Mutex
+	// id identifies the session, it changes when the session is renewed
+	id         string
+	values     map[string]string
+	createdAt  time.Time
+	lastSeenAt time.Time
+
+	// loadedID is the id the session was loaded by, empty for new sessions
+	loadedID  string
+	isNew     bool
+	modified  bool
+	destroyed bool
+	// chunks is the number of cookies the session was loaded from
+	chunks int
+	now    func() time.Time
+}
+
+// record is the serialized form of a session.
+type record struct {
+	ID         string            `json:"id"`
+	Values     map[string]string `json:"v,omitempty"`
+	CreatedAt  int64             `json:"c"`
+	LastSeenAt int64             `json:"l"`
+}
+
+func newSession(now func() time.Time) *Session {
+	return &Session{
+		id:         randomString(),
+		values:     map[string]string{},
+		createdAt:  now(),
+		lastSeenAt: now(),
+		isNew:      true,
+		now:        now,
+	}
+}
+
+func (s *Session) record() *record {
+	return &record{
+		ID:         s.id,
+		Values:     s.values,
+		CreatedAt:  s.createdAt.Unix(),
+		LastSeenAt: s.lastSeenAt.Unix(),
+	}
+}
+
+// ID returns the ID of the session.
+func (s *Session) ID() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.id
+}
+
+// IsNew returns true if the session was not loaded from the request.
+func (s *Session) IsNew() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.isNew
+}
+
+// CreatedAt returns when the session was created or last renewed, the absolute timeout is relative to it.
+func (s *Session) CreatedAt() time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.createdAt
+}
+
+// Get returns the value of key, false if not set.
+func (s *Session) Get(key string) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	v, ok := s.values[key]
+	return v, ok
+}
+
+// Set sets the value of key.
+func (s *Session) Set(key, value string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.values[key] = value
+	s.modified = true
+	s.destroyed = false
+}
+
+// Delete removes key.
+func (s *Session) Delete(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.values[key]; ok {
+		delete(s.values, key)
+		s.modified = true
+	}
+}
+
+// Renew assigns a new ID to the session and restarts its absolute timeout, keeping its values.
+// it should be called once a user logs in, to prevent session fixation.
+func (s *Session) Renew() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.id = randomString()
+	s.createdAt = s.now()
+	s.modified = true
+}
+
+// Destroy removes all the values of the session, its cookies and server-side data are deleted once saved.
+// values set afterwards start a new session.
+func (s *Session) Destroy() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.id = randomString()
+	s.values = map[string]string{}
+	s.createdAt = s.now()
+	s.modified = false
+	s.destroyed = true
+}
+
+// ctxKey is the type of the context keys of this package, it prevents collisions with keys of other packages.
+type ctxKey int
+
+const sessionKey ctxKey = iota
+
+// FromContext returns the session loaded by the Manager middleware, if any.
+func FromContext(ctx context.Context) (*Session, bool) {
+	s, ok := ctx.Value(sessionKey).(*Session)
+	return s, ok
+}
+
+// NewContext returns a copy of ctx that carries s.
+func NewContext(ctx context.Context, s *Session) context.Context {
+	return context.WithValue(ctx, sessionKey, s)
+}
+
+// randomString returns a random, URL safe, string of 256 bits.
+func randomString() string {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
@@ -0,0 +1,88 @@
+package session
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Store keeps the data of server-side sessions, keyed by the session ID.
+// implementations must be safe for concurrent use.
+type Store interface {
+	// Get returns the data of the session id, false if not found or expired.
+	Get(ctx context.Context, id string) ([]byte, bool, error)
+	// Set stores the data of the session id, which expires after ttl.
+	Set(ctx context.Context, id string, data []byte, ttl time.Duration) error
+	// Delete removes the session id, a missing session is not an error.
+	Delete(ctx context.Context, id string) error
+}
+
+// MemoryStore is an in-memory Store, sessions are lost once the process exits
+// and are not shared by multiple instances of an app.
+type MemoryStore struct {
+	mu       sync.RWMutex
+	sessions map[string]*memoryEntry
+	// sweptAt is when expired sessions were last removed
+	sweptAt time.Time
+}
+
+type memoryEntry struct {
+	data      []byte
+	expiresAt time.Time
+}
+
+// NewMemoryStore returns a new MemoryStore.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{sessions: map[string]*memoryEntry{}}
+}
+
+// Get implements Store.
+func (s *MemoryStore) Get(_ context.Context, id string) ([]byte, bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	e, ok := s.sessions[id]
+	if !ok || !time.Now().Before(e.expiresAt) {
+		return nil, false, nil
+	}
+
+	return append([]byte(nil), e.data...), true, nil
+}
+
+// Set implements Store.
+func (s *MemoryStore) Set(_ context.Context, id string, data []byte, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sweep(time.Now())
+	s.sessions[id] = &memoryEntry{data: append([]byte(nil), data...), expiresAt: time.Now().Add(ttl)}
+	return nil
+}
+
+// Delete implements Store.
+func (s *MemoryStore) Delete(_ context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.sessions, id)
+	return nil
+}
+
+// Len returns the number of stored sessions, including expired sessions that were not yet removed.
+func (s *MemoryStore) Len() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.sessions)
+}
+
+// sweep removes expired sessions at most once a minute, s.mu must be held.
+func (s *MemoryStore) sweep(now time.Time) {
+	if now.Sub(s.sweptAt) < time.Minute {
+		return
+	}
+	s.sweptAt = now
+
+	for id, e := range s.sessions {
+		if !now.Before(e.expiresAt) {
+			delete(s.sessions, id)
+		}
+	}
+}
@@ -0,0 +1,39 @@
+package session
+
+import (
+	"context"
+	"github.com/crossid/crossid-go/pkg/x/testx"
+	"testing"
+	"time"
+)
+
+func TestMemoryStore(t *testing.T) {
+	ctx := context.Background()
+	s := NewMemoryStore()
+
+	testx.AssertNoError(t, s.Set(ctx, "a", []byte("data"), time.Hour))
+	testx.AssertNoError(t, s.Set(ctx, "b", []byte("data"), -time.Second))
+
+	data, ok, err := s.Get(ctx, "a")
+	testx.AssertNoError(t, err)
+	testx.AssertTrue(t, ok && string(data) == "data", "expected session a to be found")
+
+	// the stored data is not shared with the caller
+	data[0] = 'x'
+	data, _, _ = s.Get(ctx, "a")
+	testx.AssertTrue(t, string(data) == "data", "expected the stored data to be unchanged")
+
+	_, ok, err = s.Get(ctx, "b")
+	testx.AssertNoError(t, err)
+	testx.AssertTrue(t, !ok, "expected session b to be expired")
+
+	testx.AssertNoError(t, s.Delete(ctx, "a"))
+	testx.AssertNoError(t, s.Delete(ctx, "missing"))
+	_, ok, _ = s.Get(ctx, "a")
+	testx.AssertTrue(t, !ok, "expected session a to be deleted")
+
+	// expired sessions are swept
+	s.sweptAt = time.Time{}
+	testx.AssertNoError(t, s.Set(ctx, "c", []byte("data"), time.Hour))
+	testx.AssertTrue(t, s.Len() == 1, "expected expired sessions to be removed")
+}
@@ -0,0 +1,60 @@
+package claimsx
+
+import (
+	"encoding/json"
+	"github.com/golang-jwt/jwt/v4"
+	"math"
+	"time"
+)
+
+// maxNumericDate bounds the seconds of a NumericDate, dates beyond it are clamped so they cannot
+// overflow into a time on the other side of the epoch.
+const maxNumericDate = float64(1 << 62)
+
+// Audiences returns the "aud" claim of mc which may either be a string or an array of strings.
+func Audiences(mc jwt.MapClaims) []string {
+	switch v := mc["aud"].(type) {
+	case string:
+		return []string{v}
+	case []string:
+		return v
+	case []interface{}:
+		aud := make([]string, 0, len(v))
+		for _, a := range v {
+			if s, ok := a.(string); ok {
+				aud = append(aud, s)
+			}
+		}
+		return aud
+	}
+
+	return nil
+}
+
+// NumericDate converts a NumericDate claim value into time.
+// dates out of range (e.g., 1e300) are clamped to the farthest representable date in their direction.
+func NumericDate(v interface{}) (time.Time, bool) {
+	var f float64
+	switch n := v.(type) {
+	case float64:
+		f = n
+	case int64:
+		f = float64(n)
+	case json.Number:
+		var err error
+		// a number out of range is parsed as an infinity, which is clamped below
+		if f, err = n.Float64(); err != nil && !math.IsInf(f, 0) {
+			return time.Time{}, false
+		}
+	default:
+		return time.Time{}, false
+	}
+
+	if math.IsNaN(f) {
+		return time.Time{}, false
+	}
+	f = math.Max(-maxNumericDate, math.Min(f, maxNumericDate))
+
+	sec, frac := math.Modf(f)
+	return time.Unix(int64(sec), int64(frac*1e9)), true
+}
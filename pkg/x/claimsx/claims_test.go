@@ -0,0 +1,68 @@
+package claimsx
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/crossid/crossid-go/pkg/x/testx"
+	"github.com/golang-jwt/jwt/v4"
+	"math"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAudiences(t *testing.T) {
+	for k, tc := range []struct {
+		aud      interface{}
+		expected string
+	}{
+		{aud: "a", expected: "a"},
+		{aud: []string{"a", "b"}, expected: "a b"},
+		{aud: []interface{}{"a", 1, "b"}, expected: "a b"},
+		{aud: 1},
+		{},
+	} {
+		t.Run(fmt.Sprintf("case=%d", k), func(t *testing.T) {
+			got := strings.Join(Audiences(jwt.MapClaims{"aud": tc.aud}), " ")
+			testx.AssertTrue(t, got == tc.expected, fmt.Sprintf("expected '%s' but got '%s'", tc.expected, got))
+		})
+	}
+}
+
+func TestNumericDate(t *testing.T) {
+	past, future := time.Unix(-1<<62, 0), time.Unix(1<<62, 0)
+	for k, tc := range []struct {
+		v        interface{}
+		expected time.Time
+		ok       bool
+	}{
+		{v: float64(1500000000), expected: time.Unix(1500000000, 0), ok: true},
+		{v: int64(1500000000), expected: time.Unix(1500000000, 0), ok: true},
+		{v: json.Number("1500000000"), expected: time.Unix(1500000000, 0), ok: true},
+		{v: 1500000000.5, expected: time.Unix(1500000000, 5e8), ok: true},
+		{v: -1.5, expected: time.Unix(-1, -5e8), ok: true},
+		// far dates do not overflow into the other side of the epoch
+		{v: 1e12, expected: time.Unix(1e12, 0), ok: true},
+		{v: int64(1e12), expected: time.Unix(1e12, 0), ok: true},
+		{v: -1e12, expected: time.Unix(-1e12, 0), ok: true},
+		{v: 1e300, expected: future, ok: true},
+		{v: -1e300, expected: past, ok: true},
+		{v: json.Number("1e400"), expected: future, ok: true},
+		{v: json.Number("-1e400"), expected: past, ok: true},
+		{v: math.NaN()},
+		{v: json.Number("x")},
+		{v: "1500000000"},
+		{},
+	} {
+		t.Run(fmt.Sprintf("case=%d", k), func(t *testing.T) {
+			got, ok := NumericDate(tc.v)
+			testx.AssertTrue(t, ok == tc.ok, fmt.Sprintf("expected ok to be %v", tc.ok))
+			if ok {
+				testx.AssertTrue(t, got.Equal(tc.expected), fmt.Sprintf("expected %s but got %s", tc.expected, got))
+			}
+		})
+	}
+
+	got, _ := NumericDate(1e12)
+	testx.AssertTrue(t, got.After(time.Now()), "expected a far future date to be after now")
+}